@@ -2,27 +2,41 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/Shopify/sarama"
+	"github.com/google/uuid"
 	_ "github.com/jackc/pgx/stdlib"
 	"github.com/practice-sem-2/auth-tools"
+	"github.com/practice-sem-2/notification-service/internal/models"
 	"github.com/practice-sem-2/notification-service/internal/pb/notify"
 	"github.com/practice-sem-2/notification-service/internal/server"
 	"github.com/practice-sem-2/notification-service/internal/storage"
 	"github.com/practice-sem-2/notification-service/internal/usecase"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
+	"golang.org/x/oauth2/google"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
 	"time"
+	// Quiet hours need time zones and the runtime image has no tzdata
+	_ "time/tzdata"
 )
 
+// preferencesCacheTTL is how long preferences changed on another replica may be stale
+const preferencesCacheTTL = 30 * time.Second
+
 func initLogger(level string) *logrus.Logger {
 
 	logger := logrus.New()
@@ -46,7 +60,12 @@ func initLogger(level string) *logrus.Logger {
 	return logger
 }
 
-func initServer(address string, useCases *usecase.UseCase, logger *logrus.Logger) (*grpc.Server, net.Listener) {
+func initServer(
+	address string,
+	useCases *usecase.UseCase,
+	streamer *server.Streamer,
+	logger *logrus.Logger,
+) (*grpc.Server, net.Listener) {
 
 	listener, err := net.Listen("tcp", address)
 	logger.Infof("start listening on %s", address)
@@ -55,12 +74,32 @@ func initServer(address string, useCases *usecase.UseCase, logger *logrus.Logger
 		logger.Fatalf("can't listen to address: %s", err.Error())
 	}
 
-	grpcServer := grpc.NewServer()
-	notify.RegisterNotificationsServer(grpcServer, server.NewNotificationServer(useCases, logger))
+	grpcServer := grpc.NewServer(grpc.KeepaliveParams(keepalive.ServerParameters{
+		Time: server.HeartbeatPeriod,
+	}))
+	notify.RegisterNotificationsServer(grpcServer, server.NewNotificationServer(useCases, streamer, logger))
 
 	return grpcServer, listener
 }
 
+func initDeadLetters(brokers []string, config *sarama.Config, logger *logrus.Logger) storage.DeadLetters {
+	topic := viper.GetString("KAFKA_DEAD_LETTER_TOPIC")
+	if topic == "" {
+		logger.Warn("KAFKA_DEAD_LETTER_TOPIC is not defined. Rejected messages will be dropped")
+		return nil
+	}
+
+	config.Producer.Return.Successes = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		logger.
+			WithField("error", err.Error()).
+			Fatalf("can't create dead letters producer")
+	}
+	return storage.NewKafkaDeadLetters(producer, topic)
+}
+
 func initUpdatesConsumers(logger *logrus.Logger) []storage.Consumer {
 	brokers := strings.Split(viper.GetString("KAFKA_BROKERS"), ",")
 
@@ -81,6 +120,21 @@ func initUpdatesConsumers(logger *logrus.Logger) []storage.Consumer {
 	config.Consumer.Offsets.Initial = sarama.OffsetNewest
 	config.Consumer.Offsets.AutoCommit.Enable = false
 	config.Consumer.Offsets.AutoCommit.Interval = 30 * time.Second
+	deadLetters := initDeadLetters(brokers, config, logger)
+
+	if group := viper.GetString("KAFKA_CONSUMER_GROUP"); group != "" {
+		// Group consumer marks offsets only after fan-out, so committing marked offsets is safe
+		config.Consumer.Offsets.AutoCommit.Enable = true
+		config.Consumer.Return.Errors = true
+		cg, err := sarama.NewConsumerGroup(brokers, group, config)
+		if err != nil {
+			logger.
+				WithField("error", err.Error()).
+				Fatalf("can't create consumer group")
+		}
+		return []storage.Consumer{storage.NewGroupUpdatesConsumer(cg, topics, logger).WithDeadLetters(deadLetters)}
+	}
+
 	consumers := make([]storage.Consumer, len(topics))
 	for i, t := range topics {
 		c, err := sarama.NewConsumer(brokers, config)
@@ -89,18 +143,265 @@ func initUpdatesConsumers(logger *logrus.Logger) []storage.Consumer {
 				WithField("error", err.Error()).
 				Fatalf("can't create consumer")
 		}
-		consumers[i] = storage.NewUpdatesConsumer(c, t, logger)
+		consumers[i] = storage.NewUpdatesConsumer(c, t, logger).WithDeadLetters(deadLetters)
 	}
 
 	return consumers
 }
 
-func initNotificationStore(logger *logrus.Logger) *storage.NotificationStore {
+func initDatabase(ctx context.Context, logger *logrus.Logger) *sql.DB {
+	dsn := viper.GetString("DATABASE_URL")
+
+	if dsn == "" {
+		logger.Fatalf("DATABASE_URL must be defined")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		logger.Fatalf("can't open database: %s", err.Error())
+	}
+
+	err = storage.Migrate(ctx, db)
+	if err != nil {
+		logger.Fatalf("can't migrate database: %s", err.Error())
+	}
+
+	return db
+}
+
+func initNotificationStore(
+	inbox storage.Inbox,
+	prefs storage.PreferencesStore,
+	authors storage.MessageAuthors,
+	logger *logrus.Logger,
+	sinks ...storage.Sink,
+) *storage.NotificationStore {
+	viper.SetDefault("LISTENER_OVERFLOW_POLICY", string(storage.OverflowSpill))
+	overflow, err := storage.ParseOverflowPolicy(viper.GetString("LISTENER_OVERFLOW_POLICY"))
+	if err != nil {
+		logger.Fatalf("invalid LISTENER_OVERFLOW_POLICY: %s", err.Error())
+	}
+
 	consumers := initUpdatesConsumers(logger)
-	store := storage.NewNotificationStorage(logger, consumers...)
+	store := storage.NewNotificationStorage(logger, consumers...).
+		WithInbox(inbox).
+		WithPreferences(prefs).
+		WithAuthors(authors).
+		WithSinks(sinks...).
+		WithOverflowPolicy(overflow)
+	// By default there is a shard per CPU
+	if shards := viper.GetInt("STORE_SHARDS"); shards > 0 {
+		store.WithShards(shards)
+	}
 	return store
 }
 
+func initWebhookDispatcher(
+	hooks storage.WebhookStore,
+	addresses *storage.WebhookAddresses,
+	logger *logrus.Logger,
+) *storage.WebhookDispatcher {
+	viper.SetDefault("WEBHOOK_WORKERS", 8)
+	viper.SetDefault("WEBHOOK_MAX_FAILURES", 10)
+	viper.SetDefault("WEBHOOK_OWNERS_RELOAD_INTERVAL", time.Minute)
+	return storage.NewWebhookDispatcher(hooks, server.MarshalNotification, logger).
+		WithWorkers(viper.GetInt("WEBHOOK_WORKERS")).
+		WithMaxFailures(viper.GetInt("WEBHOOK_MAX_FAILURES")).
+		WithReloadInterval(viper.GetDuration("WEBHOOK_OWNERS_RELOAD_INTERVAL")).
+		WithAddresses(addresses)
+}
+
+// initWebhookAddresses forbids webhooks on internal addresses but networks listed
+// in comma-separated WEBHOOK_ALLOWED_NETWORKS, e.g. for receivers inside the cluster
+func initWebhookAddresses(logger *logrus.Logger) *storage.WebhookAddresses {
+	var cidrs []string
+	for _, cidr := range strings.Split(viper.GetString("WEBHOOK_ALLOWED_NETWORKS"), ",") {
+		if cidr = strings.TrimSpace(cidr); cidr != "" {
+			cidrs = append(cidrs, cidr)
+		}
+	}
+	allowed, err := storage.ParseNetworks(cidrs)
+	if err != nil {
+		logger.
+			WithField("networks", cidrs).
+			Fatalf("can't parse allowed webhook networks: %s", err.Error())
+	}
+	return storage.NewWebhookAddresses(allowed...)
+}
+
+// initPushNotifier configures providers of platforms which have credentials set
+func initPushNotifier(
+	ctx context.Context,
+	devices storage.DeviceStore,
+	store *storage.NotificationStore,
+	logger *logrus.Logger,
+) *storage.PushNotifier {
+	notifier := storage.NewPushNotifier(devices, store.Listening, logger)
+
+	viper.SetDefault("FCM_ENDPOINT", storage.DefaultFCMEndpoint)
+	if path := viper.GetString("FCM_CREDENTIALS_PATH"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logger.Fatalf("can't read FCM_CREDENTIALS_PATH: %s", err.Error())
+		}
+		creds, err := google.CredentialsFromJSON(ctx, data, storage.FCMScope)
+		if err != nil {
+			logger.Fatalf("invalid fcm credentials: %s", err.Error())
+		}
+		projectID := viper.GetString("FCM_PROJECT_ID")
+		if projectID == "" {
+			projectID = creds.ProjectID
+		}
+		notifier.WithProvider(models.PlatformFCM,
+			storage.NewFCMProvider(viper.GetString("FCM_ENDPOINT"), projectID, creds.TokenSource))
+		logger.Infof("fcm pushes are enabled for project %s", projectID)
+	}
+
+	viper.SetDefault("APNS_ENDPOINT", storage.DefaultAPNsEndpoint)
+	if path := viper.GetString("APNS_KEY_PATH"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			logger.Fatalf("can't read APNS_KEY_PATH: %s", err.Error())
+		}
+		key, err := storage.ParseAPNsKey(data)
+		if err != nil {
+			logger.Fatalf("invalid apns key: %s", err.Error())
+		}
+		notifier.WithProvider(models.PlatformAPNs, storage.NewAPNsProvider(
+			viper.GetString("APNS_ENDPOINT"),
+			viper.GetString("APNS_TOPIC"),
+			key,
+			viper.GetString("APNS_KEY_ID"),
+			viper.GetString("APNS_TEAM_ID"),
+		))
+		logger.Infof("apns pushes are enabled for %s", viper.GetString("APNS_TOPIC"))
+	}
+	return notifier
+}
+
+// initDigests returns digests use case and whether digests should be sent.
+// Users can subscribe even if SMTP isn't configured, but nothing is sent then.
+func initDigests(
+	digests storage.DigestStore,
+	inbox storage.Inbox,
+	prefs storage.PreferencesStore,
+	logger *logrus.Logger,
+) (*usecase.DigestUseCase, bool) {
+	viper.SetDefault("DIGEST_OFFLINE_AFTER", 24*time.Hour)
+	offlineAfter := viper.GetDuration("DIGEST_OFFLINE_AFTER")
+
+	addr := viper.GetString("SMTP_ADDR")
+	if addr == "" {
+		logger.Info("SMTP_ADDR is not set. Email digests are disabled")
+		return usecase.NewDigestUseCase(digests, inbox, prefs, nil, offlineAfter, logger), false
+	}
+	from, err := mail.ParseAddress(viper.GetString("SMTP_FROM"))
+	if err != nil {
+		logger.Fatalf("invalid SMTP_FROM: %s", err.Error())
+	}
+	var smtpAuth smtp.Auth
+	if username := viper.GetString("SMTP_USERNAME"); username != "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			logger.Fatalf("invalid SMTP_ADDR: %s", err.Error())
+		}
+		smtpAuth = smtp.PlainAuth("", username, viper.GetString("SMTP_PASSWORD"), host)
+	}
+	viper.SetDefault("SMTP_TIMEOUT", 30*time.Second)
+	mailer := storage.NewSMTPMailer(addr, *from, smtpAuth).
+		WithTimeout(viper.GetDuration("SMTP_TIMEOUT"))
+	return usecase.NewDigestUseCase(digests, inbox, prefs, mailer, offlineAfter, logger), true
+}
+
+func initHTTPServer(
+	address string,
+	useCases *usecase.UseCase,
+	streamer *server.Streamer,
+	logger *logrus.Logger,
+) *http.Server {
+	var origins []string
+	if raw := viper.GetString("WEBSOCKET_ALLOWED_ORIGINS"); raw != "" {
+		origins = strings.Split(raw, ",")
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/ws", server.NewWebSocketGateway(useCases, streamer, logger, origins))
+	mux.Handle("/events", server.NewEventStreamHandler(useCases, streamer, logger))
+	srv := &http.Server{
+		Addr:    address,
+		Handler: mux,
+	}
+	go func() {
+		logger.Infof("serving http on %s", address)
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("http serving error: %s", err.Error())
+		}
+	}()
+	return srv
+}
+
+func initMetricsServer(address string, logger *logrus.Logger) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	srv := &http.Server{
+		Addr:    address,
+		Handler: mux,
+	}
+	go func() {
+		logger.Infof("serving metrics on %s", address)
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("metrics serving error: %s", err.Error())
+		}
+	}()
+	return srv
+}
+
+// initCluster lets replicas reach listeners of each other. It is off unless the address
+// other replicas reach the peer port of this one at is defined.
+// Peers authenticate with PEER_SECRET, which must be the same on all replicas.
+// The peer port must not be exposed outside the private network of the replicas:
+// the secret is sent in plain text.
+func initCluster(
+	address string,
+	store *storage.NotificationStore,
+	presence storage.Presence,
+	logger *logrus.Logger,
+) (*storage.Cluster, *grpc.Server) {
+	advertised := viper.GetString("PEER_ADVERTISE_ADDRESS")
+	if advertised == "" {
+		logger.Info("PEER_ADVERTISE_ADDRESS is not defined. Running as a single instance")
+		return nil, nil
+	}
+	secret := viper.GetString("PEER_SECRET")
+	if secret == "" {
+		logger.Fatal("PEER_SECRET must be set to run multiple instances")
+	}
+	viper.SetDefault("INSTANCE_ID", uuid.New().String())
+	viper.SetDefault("INSTANCE_LEASE_TTL", 30*time.Second)
+	self := models.Instance{
+		ID:      viper.GetString("INSTANCE_ID"),
+		Address: advertised,
+	}
+	cluster := storage.NewCluster(self, presence, server.NewPeerClient(secret), logger).
+		WithLeaseTTL(viper.GetDuration("INSTANCE_LEASE_TTL"))
+	store.WithCluster(cluster)
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		logger.Fatalf("can't listen to peer address: %s", err.Error())
+	}
+	srv := grpc.NewServer(grpc.UnaryInterceptor(server.PeerAuthInterceptor(secret)))
+	notify.RegisterNotificationPeerServer(srv, server.NewPeerServer(store, logger))
+	go func() {
+		logger.Infof("serving peers on %s as instance %s", address, self.ID)
+		if err := srv.Serve(listener); err != nil {
+			logger.Errorf("peer serving error: %s", err.Error())
+		}
+	}()
+	return cluster, srv
+}
+
 func main() {
 	viper.AutomaticEnv()
 	ctx := context.Background()
@@ -108,16 +409,69 @@ func main() {
 
 	var host string
 	var port int
+	var metricsPort int
+	var httpPort int
+	var peerPort int
 	var logLevel string
 
 	flag.IntVar(&port, "port", 80, "port on which server will be started")
+	flag.IntVar(&metricsPort, "metrics-port", 9100, "port on which metrics will be served")
+	flag.IntVar(&httpPort, "http-port", 8080, "port on which websocket and event stream will be served")
+	flag.IntVar(&peerPort, "peer-port", 9090, "port on which other instances forward notifications, must be reachable by them only")
 	flag.StringVar(&host, "host", "0.0.0.0", "host on which server will be started")
 	flag.StringVar(&logLevel, "log", "info", "log level")
 
 	flag.Parse()
 
 	logger := initLogger(logLevel)
-	store := initNotificationStore(logger)
+	db := initDatabase(ctx, logger)
+	defer db.Close()
+	inbox := storage.NewPostgresInbox(db)
+	prefs := storage.NewCachedPreferences(storage.NewPostgresPreferences(db), preferencesCacheTTL)
+	webhooks := storage.NewPostgresWebhooks(db)
+	webhookAddresses := initWebhookAddresses(logger)
+	dispatcher := initWebhookDispatcher(webhooks, webhookAddresses, logger)
+	authors := storage.NewPostgresAuthors(db)
+	members := storage.NewPostgresChatMembers(db)
+	store := initNotificationStore(inbox, prefs, authors, logger, dispatcher)
+	store.WithMembers(members)
+	devices := storage.NewPostgresDevices(db)
+	pusher := initPushNotifier(ctx, devices, store, logger)
+	viper.SetDefault("PUSH_COALESCE_WINDOW", 3*time.Second)
+	store.WithSinks(storage.NewCoalescer(pusher, viper.GetDuration("PUSH_COALESCE_WINDOW")))
+	keywords := storage.NewPostgresKeywords(db)
+	alerts := storage.NewKeywordAlerts(keywords, logger)
+	store.WithKeywords(alerts)
+	peerAddress := fmt.Sprintf("%s:%d", host, peerPort)
+	cluster, peerSrv := initCluster(peerAddress, store, storage.NewPostgresPresence(db), logger)
+	if cluster != nil {
+		go func() {
+			err := cluster.Run(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				logger.
+					WithField("error", err).
+					Error("cluster routing ended with error")
+			}
+		}()
+	}
+
+	go func() {
+		err := dispatcher.Run(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.
+				WithField("error", err).
+				Error("webhook delivery ended with error")
+		}
+	}()
+
+	go func() {
+		err := pusher.Run(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.
+				WithField("error", err).
+				Error("push delivery ended with error")
+		}
+	}()
 
 	go func() {
 		err := store.Run(ctx)
@@ -135,11 +489,77 @@ func main() {
 			WithField("key_path", publicKeyPath).
 			Fatalf("can't create verifier: %s", err.Error())
 	}
-	notificationUseCase := usecase.NewNotificationUseCase(store)
-	useCases := usecase.NewUseCase(notificationUseCase, verifier)
+	notificationUseCase := usecase.NewNotificationUseCase(store, inbox)
+	preferencesUseCase := usecase.NewPreferencesUseCase(prefs)
+	webhooksUseCase := usecase.NewWebhooksUseCase(webhooks, dispatcher, webhookAddresses, logger)
+	devicesUseCase := usecase.NewDevicesUseCase(devices)
+	digestUseCase, sendDigests := initDigests(storage.NewPostgresDigests(db), inbox, prefs, logger)
+	keywordsUseCase := usecase.NewKeywordsUseCase(keywords, alerts, logger)
+	presenceUseCase := usecase.NewPresenceUseCase(store, storage.NewPostgresLastSeen(db), prefs, members, logger)
+	connectedDevicesUseCase := usecase.NewConnectedDevicesUseCase(store, storage.NewPostgresReadCursors(db), prefs)
+	useCases := usecase.NewUseCase(
+		notificationUseCase,
+		preferencesUseCase,
+		webhooksUseCase,
+		devicesUseCase,
+		digestUseCase,
+		keywordsUseCase,
+		presenceUseCase,
+		connectedDevicesUseCase,
+		verifier,
+	)
+
+	viper.SetDefault("KEYWORD_RULES_RELOAD_INTERVAL", time.Minute)
+	go func() {
+		err := alerts.Run(ctx, viper.GetDuration("KEYWORD_RULES_RELOAD_INTERVAL"))
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.
+				WithField("error", err).
+				Error("keyword rules reloading ended with error")
+		}
+	}()
+
+	viper.SetDefault("PRESENCE_POLL_INTERVAL", 5*time.Second)
+	go func() {
+		err := presenceUseCase.Run(ctx, viper.GetDuration("PRESENCE_POLL_INTERVAL"))
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.
+				WithField("error", err).
+				Error("presence polling ended with error")
+		}
+	}()
+
+	// Authors are needed to tell about replies, which rarely come to old messages
+	viper.SetDefault("MESSAGE_AUTHORS_RETENTION", 90*24*time.Hour)
+	viper.SetDefault("MESSAGE_AUTHORS_PRUNE_INTERVAL", time.Hour)
+	retention := storage.NewAuthorsRetention(authors, viper.GetDuration("MESSAGE_AUTHORS_RETENTION"), logger)
+	go func() {
+		err := retention.Run(ctx, viper.GetDuration("MESSAGE_AUTHORS_PRUNE_INTERVAL"))
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.
+				WithField("error", err).
+				Error("message authors pruning ended with error")
+		}
+	}()
+
+	if sendDigests {
+		viper.SetDefault("DIGEST_INTERVAL", 15*time.Minute)
+		go func() {
+			err := digestUseCase.Run(ctx, viper.GetDuration("DIGEST_INTERVAL"))
+			if err != nil && !errors.Is(err, context.Canceled) {
+				logger.
+					WithField("error", err).
+					Error("email digests ended with error")
+			}
+		}()
+	}
 
 	address := fmt.Sprintf("%s:%d", host, port)
-	srv, lis := initServer(address, useCases, logger)
+	streamer := server.NewStreamer(useCases, logger)
+	srv, lis := initServer(address, useCases, streamer, logger)
+	metricsSrv := initMetricsServer(fmt.Sprintf("%s:%d", host, metricsPort), logger)
+	defer metricsSrv.Close()
+	httpSrv := initHTTPServer(fmt.Sprintf("%s:%d", host, httpPort), useCases, streamer, logger)
 	osSignal := make(chan os.Signal, 1)
 	signal.Notify(osSignal,
 		syscall.SIGHUP,
@@ -150,7 +570,13 @@ func main() {
 	go func(ctx context.Context) {
 		select {
 		case sig := <-osSignal:
+			// Streams never end on their own, so they are closed before servers wait for them
+			streamer.Close()
+			_ = httpSrv.Shutdown(ctx)
 			srv.GracefulStop()
+			if peerSrv != nil {
+				peerSrv.GracefulStop()
+			}
 			logger.Infof("%s caught. Gracefully shutdown", sig.String())
 		case <-ctx.Done():
 			return
@@ -0,0 +1,39 @@
+package models
+
+import "time"
+
+// Platform is the push service a device token belongs to
+type Platform string
+
+const (
+	PlatformFCM  Platform = "fcm"
+	PlatformAPNs Platform = "apns"
+)
+
+// Device is a phone of a user registered for push notifications
+type Device struct {
+	Token     string
+	UserID    string
+	Platform  Platform
+	UpdatedAt time.Time
+}
+
+// ClientType is the kind of app a device listens from
+type ClientType string
+
+const (
+	ClientUnknown ClientType = ""
+	ClientWeb     ClientType = "web"
+	ClientDesktop ClientType = "desktop"
+	ClientMobile  ClientType = "mobile"
+)
+
+// ConnectedDevice is a device of a user listening for notifications.
+// Listeners which didn't tell their device have empty ID.
+type ConnectedDevice struct {
+	ID          string     `json:"id,omitempty"`
+	Client      ClientType `json:"client,omitempty"`
+	ConnectedAt time.Time  `json:"connected_at"`
+	// Filtered is set if the device receives only notifications matching its filter
+	Filtered bool `json:"filtered,omitempty"`
+}
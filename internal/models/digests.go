@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// DigestSubscription is the state of email digests of a user
+type DigestSubscription struct {
+	UserID string
+	Email  string
+	// LastSeenAt is when the user was last connected to a live stream
+	LastSeenAt   time.Time
+	LastDigestAt *time.Time
+	// LastDigestSeq is the newest notification included into a digest,
+	// so digests don't repeat messages
+	LastDigestSeq int64
+}
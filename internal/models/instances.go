@@ -0,0 +1,8 @@
+package models
+
+// Instance is a replica of the service holding live listeners of users
+type Instance struct {
+	ID string
+	// Address other instances forward notifications to
+	Address string
+}
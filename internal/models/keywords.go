@@ -0,0 +1,42 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	ErrInvalidKeywordRule = errors.New("invalid keyword rule")
+)
+
+const maxKeywordPatternLength = 256
+
+// KeywordRule alerts its user about messages matching Pattern in any of their chats.
+// Keywords match case-insensitively anywhere in the text, regular expressions
+// follow the RE2 syntax.
+type KeywordRule struct {
+	ID        string
+	UserID    string
+	Pattern   string
+	Regexp    bool
+	CreatedAt time.Time
+}
+
+func (r KeywordRule) Validate() error {
+	if strings.TrimSpace(r.Pattern) == "" {
+		return fmt.Errorf("%w: pattern is empty", ErrInvalidKeywordRule)
+	}
+	if utf8.RuneCountInString(r.Pattern) > maxKeywordPatternLength {
+		return fmt.Errorf("%w: pattern is longer than %d characters", ErrInvalidKeywordRule, maxKeywordPatternLength)
+	}
+	if r.Regexp {
+		if _, err := regexp.Compile(r.Pattern); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidKeywordRule, err)
+		}
+	}
+	return nil
+}
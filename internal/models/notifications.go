@@ -7,14 +7,80 @@ type Update interface {
 	GetAudience() []string
 }
 
+type UpdateKind string
+
+const (
+	KindMessageSent   UpdateKind = "message_sent"
+	KindChatCreated   UpdateKind = "chat_created"
+	KindChatDeleted   UpdateKind = "chat_deleted"
+	KindMemberAdded   UpdateKind = "member_added"
+	KindMemberRemoved UpdateKind = "member_removed"
+	KindUnreadChanged UpdateKind = "unread_changed"
+)
+
+// KindOf returns kind of upd or empty string if upd is not a known update
+func KindOf(upd Update) UpdateKind {
+	switch upd.(type) {
+	case *MessageSent:
+		return KindMessageSent
+	case *ChatCreated:
+		return KindChatCreated
+	case *ChatDeleted:
+		return KindChatDeleted
+	case *MemberAdded:
+		return KindMemberAdded
+	case *MemberRemoved:
+		return KindMemberRemoved
+	case *UnreadChanged:
+		return KindUnreadChanged
+	}
+	return ""
+}
+
+// ChatOf returns id of the chat upd relates to
+func ChatOf(upd Update) string {
+	switch upd.(type) {
+	case *MessageSent:
+		return upd.(*MessageSent).ChatID
+	case *ChatCreated:
+		return upd.(*ChatCreated).ChatID
+	case *ChatDeleted:
+		return upd.(*ChatDeleted).ChatID
+	case *MemberAdded:
+		return upd.(*MemberAdded).ChatID
+	case *MemberRemoved:
+		return upd.(*MemberRemoved).ChatID
+	}
+	return ""
+}
+
+// Notification is an update stored in the inbox of a single user.
+// Seq grows monotonically within the inbox of the user.
+type Notification struct {
+	Update
+	ID        string
+	UserID    string
+	Seq       int64
+	Read      bool
+	CreatedAt time.Time
+	// Silent notifications are delivered without alerting the user. It is
+	// decided on delivery and isn't stored.
+	Silent bool
+	// Coalesced is the number of messages of the chat this notification stands for
+	// if a burst of them was merged into the latest one. Zero means a single message.
+	Coalesced int
+	// Reason tells why a message concerns the user in particular. It is decided on delivery.
+	Reason Reason
+}
+
 type FileAttachment struct {
 	MimeType string `validate:"required" db:"mime_type"`
 	FileID   string `validate:"required,uuid" db:"file_id"`
 }
 
 type UpdateMeta struct {
-	Timestamp time.Time
-	Audience  []string
+	Timestamp time.Time `validate:"required"`
+	Audience  []string  `validate:"min=1,dive,required"`
 }
 
 func (m *UpdateMeta) GetTime() time.Time {
@@ -27,17 +93,47 @@ func (m *UpdateMeta) GetAudience() []string {
 
 type MessageSent struct {
 	UpdateMeta
-	MessageID   string  `validate:"required,uuid"`
-	FromUser    string  `validate:"required"`
-	ChatID      string  `validate:"required,uuid"`
-	Text        string  `validate:"required_without=Attachments"`
-	ReplyTo     *string `validate:"uuid"`
-	Attachments []FileAttachment
+	MessageID   string `validate:"required,uuid"`
+	FromUser    string `validate:"required"`
+	ChatID      string `validate:"required,uuid"`
+	Text        string
+	ReplyTo     *string          `validate:"omitempty,uuid"`
+	Attachments []FileAttachment `validate:"dive"`
 }
 
 type ChatCreated struct {
 	UpdateMeta
 	ChatID   string `validate:"required,uuid"`
-	IsDirect bool   `validate:"required"`
-	Members  []string
+	IsDirect bool
+	Members  []string `validate:"dive,required"`
+}
+
+type ChatDeleted struct {
+	UpdateMeta
+	ChatID string `validate:"required,uuid"`
+}
+
+type MemberAdded struct {
+	UpdateMeta
+	ChatID string `validate:"required,uuid"`
+	UserID string `validate:"required"`
+}
+
+type MemberRemoved struct {
+	UpdateMeta
+	ChatID string `validate:"required,uuid"`
+	UserID string `validate:"required"`
+}
+
+// UnreadCounts is the number of unread notifications of a user in total and by chat
+type UnreadCounts struct {
+	Total int64
+	Chats map[string]int64
+}
+
+// UnreadChanged is sent to a user after some of their notifications were read.
+// It is delivered to live listeners only and never stored.
+type UnreadChanged struct {
+	UpdateMeta
+	Counts UnreadCounts
 }
@@ -0,0 +1,138 @@
+package models
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ChatMute silences messages of a chat
+type ChatMute struct {
+	// Until is the moment the mute expires. Nil means forever.
+	Until *time.Time `json:"until,omitempty"`
+	// ExceptMentions lets through messages mentioning the user
+	ExceptMentions bool `json:"except_mentions"`
+}
+
+// Preferences define which notifications a user wants to get on live channels
+type Preferences struct {
+	ChatMutes  map[string]ChatMute `json:"chat_mutes,omitempty"`
+	MutedKinds []UpdateKind        `json:"muted_kinds,omitempty"`
+	QuietHours []QuietHours        `json:"quiet_hours,omitempty"`
+	// MentionsBypassMutes lets through messages mentioning the user despite any mutes
+	MentionsBypassMutes bool `json:"mentions_bypass_mutes,omitempty"`
+	// Devices are preferences of devices of the user by their IDs
+	Devices map[string]DevicePreferences `json:"devices,omitempty"`
+	// HidePresence makes the user look offline and never seen to other users
+	HidePresence bool `json:"hide_presence,omitempty"`
+}
+
+// DevicePreferences tune how a single device of a user is notified
+type DevicePreferences struct {
+	// Silent makes every notification delivered to the device silent
+	Silent bool `json:"silent,omitempty"`
+	// SilentWhileActive makes the device silent while any other device of these types is connected
+	SilentWhileActive []ClientType `json:"silent_while_active,omitempty"`
+}
+
+// Mutes reports whether upd must not be delivered to userID at the moment now
+func (p *Preferences) Mutes(upd Update, userID string, now time.Time) bool {
+	msg, isMessage := upd.(*MessageSent)
+	if isMessage && p.MentionsBypassMutes && msg.Mentions(userID) {
+		return false
+	}
+
+	kind := KindOf(upd)
+	for _, k := range p.MutedKinds {
+		if k == kind {
+			return true
+		}
+	}
+
+	// Chat mutes silence messages only, so clients still learn about chat changes
+	if !isMessage {
+		return false
+	}
+	mute, ok := p.ChatMutes[msg.ChatID]
+	if !ok {
+		return false
+	}
+	if mute.Until != nil && !now.Before(*mute.Until) {
+		return false
+	}
+	if mute.ExceptMentions && msg.Mentions(userID) {
+		return false
+	}
+	return true
+}
+
+// DeviceSilent reports whether notifications must reach deviceID silently
+// while the given devices of the user are connected
+func (p *Preferences) DeviceSilent(deviceID string, connected []ConnectedDevice) bool {
+	device, ok := p.Devices[deviceID]
+	if !ok {
+		return false
+	}
+	if device.Silent {
+		return true
+	}
+	for _, other := range connected {
+		if other.ID == deviceID {
+			continue
+		}
+		for _, client := range device.SilentWhileActive {
+			if other.Client == client {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Quiet reports whether now falls into any quiet hours window of the user
+func (p *Preferences) Quiet(now time.Time) bool {
+	for _, q := range p.QuietHours {
+		if q.Contains(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mentions reports whether text of the message contains @username as a separate word
+func (m *MessageSent) Mentions(username string) bool {
+	mention := "@" + username
+	text := m.Text
+	for {
+		idx := strings.Index(text, mention)
+		if idx < 0 {
+			return false
+		}
+		before, _ := utf8.DecodeLastRuneInString(text[:idx])
+		start := idx == 0 || !isUsernameRune(before)
+		rest := text[idx+len(mention):]
+		if start && !continuesUsername(rest) {
+			return true
+		}
+		text = rest
+	}
+}
+
+// continuesUsername reports whether text right after a mention is still a part of the username.
+// Dots and dashes are such only between other username runes, so punctuation ending a sentence isn't.
+func continuesUsername(text string) bool {
+	r, size := utf8.DecodeRuneInString(text)
+	if r == '.' || r == '-' {
+		r, _ = utf8.DecodeRuneInString(text[size:])
+	}
+	return isWordRune(r)
+}
+
+func isUsernameRune(r rune) bool {
+	return isWordRune(r) || r == '.' || r == '-'
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
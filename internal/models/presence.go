@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// UserPresence tells whether a user is connected and from how many devices
+type UserPresence struct {
+	UserID string
+	Online bool
+	// LastSeen is nil if the user was never seen
+	LastSeen *time.Time
+	// Devices is the number of live streams of the user
+	Devices int
+}
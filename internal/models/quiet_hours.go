@@ -0,0 +1,107 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidQuietHours = errors.New("invalid quiet hours")
+)
+
+// TimeOfDay is the number of minutes since midnight
+type TimeOfDay int
+
+const minutesPerDay = 24 * 60
+
+// ParseTimeOfDay parses time of day in HH:MM format
+func ParseTimeOfDay(s string) (TimeOfDay, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: time of day must be HH:MM, got %q", ErrInvalidQuietHours, s)
+	}
+	return TimeOfDay(t.Hour()*60 + t.Minute()), nil
+}
+
+func (t TimeOfDay) String() string {
+	return fmt.Sprintf("%02d:%02d", int(t)/60, int(t)%60)
+}
+
+// QuietHours is a daily do-not-disturb window in the time zone of the user.
+// A window with Start after End lasts over midnight.
+type QuietHours struct {
+	Start    TimeOfDay `json:"start"`
+	End      TimeOfDay `json:"end"`
+	TimeZone string    `json:"time_zone"`
+	// Weekdays the window starts on. Empty means every day.
+	Weekdays []time.Weekday `json:"weekdays,omitempty"`
+}
+
+func (q QuietHours) Validate() error {
+	if q.Start < 0 || q.Start >= minutesPerDay || q.End < 0 || q.End >= minutesPerDay {
+		return fmt.Errorf("%w: time of day out of range", ErrInvalidQuietHours)
+	}
+	if q.Start == q.End {
+		return fmt.Errorf("%w: window is empty", ErrInvalidQuietHours)
+	}
+	for _, d := range q.Weekdays {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("%w: unknown weekday %d", ErrInvalidQuietHours, d)
+		}
+	}
+	if _, err := loadLocation(q.TimeZone); err != nil {
+		return fmt.Errorf("%w: unknown time zone %q", ErrInvalidQuietHours, q.TimeZone)
+	}
+	return nil
+}
+
+// Contains reports whether now falls into the window
+func (q QuietHours) Contains(now time.Time) bool {
+	loc, err := loadLocation(q.TimeZone)
+	if err != nil {
+		return false
+	}
+	local := now.In(loc)
+	minute := TimeOfDay(local.Hour()*60 + local.Minute())
+	if q.Start < q.End {
+		return q.Start <= minute && minute < q.End && q.startsOn(local.Weekday())
+	}
+	// The window lasts over midnight, so after midnight it belongs to the previous day
+	if minute >= q.Start {
+		return q.startsOn(local.Weekday())
+	}
+	if minute < q.End {
+		return q.startsOn((local.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+func (q QuietHours) startsOn(day time.Weekday) bool {
+	if len(q.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range q.Weekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+var locations sync.Map
+
+// loadLocation is time.LoadLocation that caches loaded zones,
+// because quiet hours are checked for every delivered notification
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
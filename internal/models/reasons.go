@@ -0,0 +1,28 @@
+package models
+
+// Reason tells why a message concerns its recipient in particular.
+// Messages with a reason are of high priority.
+type Reason string
+
+const (
+	ReasonNone    Reason = ""
+	ReasonMention Reason = "mention"
+	ReasonReply   Reason = "reply"
+	// ReasonKeyword messages match a keyword rule of the user. They get through mutes.
+	ReasonKeyword Reason = "keyword"
+)
+
+// ReasonFor returns why m concerns userID. repliedTo is the author of the message m replies to
+// or empty if it is unknown. A mention wins over a reply.
+func (m *MessageSent) ReasonFor(userID string, repliedTo string) Reason {
+	if m.FromUser == userID {
+		return ReasonNone
+	}
+	if m.Mentions(userID) {
+		return ReasonMention
+	}
+	if m.ReplyTo != nil && repliedTo == userID {
+		return ReasonReply
+	}
+	return ReasonNone
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Webhook is an endpoint notifications of a user are posted to
+type Webhook struct {
+	ID     string
+	UserID string
+	URL    string
+	// Secret signs deliveries, so the receiver can check they come from us
+	Secret string
+	// Failures is the number of deliveries failed in a row
+	Failures  int
+	Disabled  bool
+	CreatedAt time.Time
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"github.com/practice-sem-2/notification-service/internal/usecase"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *NotificationsServer) ListConnectedDevices(
+	ctx context.Context,
+	_ *notify.ListConnectedDevicesRequest,
+) (*notify.ListConnectedDevicesResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	devices, err := s.ucases.ConnectedDevices.List(ctx, user.Username)
+	if err != nil {
+		s.logger.Errorf("can't list connected devices of %s: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't list connected devices")
+	}
+	resp := &notify.ListConnectedDevicesResponse{
+		Devices: make([]*notify.ConnectedDevice, 0, len(devices)),
+	}
+	for _, device := range devices {
+		resp.Devices = append(resp.Devices, ConnectedDeviceFromState(device))
+	}
+	return resp, nil
+}
+
+func (s *NotificationsServer) DisconnectDevice(
+	ctx context.Context,
+	r *notify.DisconnectDeviceRequest,
+) (*notify.DisconnectDeviceResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	err = s.ucases.ConnectedDevices.Disconnect(ctx, user.Username, r.DeviceId)
+	if errors.Is(err, usecase.ErrDeviceNotConnected) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		s.logger.Errorf("can't disconnect device %s of %s: %v", r.DeviceId, user.Username, err)
+		return nil, status.Error(codes.Internal, "can't disconnect device")
+	}
+	return &notify.DisconnectDeviceResponse{}, nil
+}
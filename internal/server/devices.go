@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"github.com/practice-sem-2/notification-service/internal/usecase"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var devicePlatforms = map[notify.DevicePlatform]models.Platform{
+	notify.DevicePlatform_DEVICE_PLATFORM_FCM:  models.PlatformFCM,
+	notify.DevicePlatform_DEVICE_PLATFORM_APNS: models.PlatformAPNs,
+}
+
+func (s *NotificationsServer) RegisterDevice(ctx context.Context, r *notify.RegisterDeviceRequest) (*notify.RegisterDeviceResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	err = s.ucases.Devices.Register(ctx, user.Username, r.Token, devicePlatforms[r.Platform])
+	if errors.Is(err, usecase.ErrInvalidDevice) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		s.logger.Errorf("can't register device of %s: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't register device")
+	}
+	return &notify.RegisterDeviceResponse{}, nil
+}
+
+func (s *NotificationsServer) UnregisterDevice(
+	ctx context.Context,
+	r *notify.UnregisterDeviceRequest,
+) (*notify.UnregisterDeviceResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	if err := s.ucases.Devices.Unregister(ctx, user.Username, r.Token); err != nil {
+		s.logger.Errorf("can't unregister device of %s: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't unregister device")
+	}
+	return &notify.UnregisterDeviceResponse{}, nil
+}
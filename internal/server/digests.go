@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"github.com/practice-sem-2/notification-service/internal/usecase"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *NotificationsServer) SetDigestEmail(
+	ctx context.Context,
+	r *notify.SetDigestEmailRequest,
+) (*notify.SetDigestEmailResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	err = s.ucases.Digests.Subscribe(ctx, user.Username, r.Email)
+	if errors.Is(err, usecase.ErrInvalidEmail) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		s.logger.Errorf("can't set digest email of %s: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't set digest email")
+	}
+	return &notify.SetDigestEmailResponse{}, nil
+}
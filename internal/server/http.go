@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"google.golang.org/grpc/metadata"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// httpAuthContext makes the token of an HTTP request visible to auth.VerifierService,
+// which reads it from gRPC metadata. Browsers can't set headers on WebSocket and
+// EventSource requests, so the token may be passed in access_token query parameter.
+func httpAuthContext(r *http.Request) context.Context {
+	authorization := r.Header.Get("Authorization")
+	if authorization == "" {
+		if token := r.URL.Query().Get("access_token"); token != "" {
+			authorization = "Bearer " + token
+		}
+	}
+	return metadata.NewIncomingContext(r.Context(), metadata.Pairs("authorization", authorization))
+}
+
+// sinceSeqFromQuery returns since_seq query parameter if it is set
+func sinceSeqFromQuery(r *http.Request) (*int64, error) {
+	raw := r.URL.Query().Get("since_seq")
+	if raw == "" {
+		return nil, nil
+	}
+	seq, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || seq < 0 {
+		return nil, fmt.Errorf("invalid since_seq: %q", raw)
+	}
+	return &seq, nil
+}
+
+// streamOptionsFromQuery returns options of a stream but since_seq, which transports read on their own
+func streamOptionsFromQuery(r *http.Request, sinceSeq *int64) (StreamOptions, error) {
+	opts := StreamOptions{
+		SinceSeq:      sinceSeq,
+		WatchPresence: listFromQuery(r, "watch_presence"),
+		DeviceID:      r.URL.Query().Get("device_id"),
+		Filter: storage.ListenFilter{
+			ChatIDs:      listFromQuery(r, "chat_ids"),
+			MentionsOnly: r.URL.Query().Get("mentions_only") == "true",
+		},
+	}
+	switch client := models.ClientType(r.URL.Query().Get("client_type")); client {
+	case models.ClientUnknown, models.ClientWeb, models.ClientDesktop, models.ClientMobile:
+		opts.Client = client
+	default:
+		return opts, fmt.Errorf("invalid client_type: %q", client)
+	}
+	for _, kind := range listFromQuery(r, "types") {
+		if !knownKind(models.UpdateKind(kind)) {
+			return opts, fmt.Errorf("unknown update type: %q", kind)
+		}
+		opts.Filter.Kinds = append(opts.Filter.Kinds, models.UpdateKind(kind))
+	}
+	return opts, nil
+}
+
+// listFromQuery returns items of comma-separated query parameter
+func listFromQuery(r *http.Request, name string) []string {
+	var items []string
+	for _, item := range strings.Split(r.URL.Query().Get(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// knownKind reports whether clients may filter updates by kind
+func knownKind(kind models.UpdateKind) bool {
+	for _, k := range updateTypeKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
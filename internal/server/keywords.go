@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/practice-sem-2/notification-service/internal/usecase"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *NotificationsServer) AddKeywordRule(ctx context.Context, r *notify.AddKeywordRuleRequest) (*notify.KeywordRule, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	rule, err := s.ucases.Keywords.Add(ctx, user.Username, r.Pattern, r.Regexp)
+	if errors.Is(err, models.ErrInvalidKeywordRule) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, usecase.ErrTooManyKeywordRules) {
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if err != nil {
+		s.logger.Errorf("can't add keyword rule of %s: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't add keyword rule")
+	}
+	return KeywordRuleFromModel(rule), nil
+}
+
+func (s *NotificationsServer) ListKeywordRules(ctx context.Context, _ *notify.ListKeywordRulesRequest) (*notify.ListKeywordRulesResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	rules, err := s.ucases.Keywords.List(ctx, user.Username)
+	if err != nil {
+		s.logger.Errorf("can't list keyword rules of %s: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't list keyword rules")
+	}
+	resp := &notify.ListKeywordRulesResponse{
+		Rules: make([]*notify.KeywordRule, 0, len(rules)),
+	}
+	for _, rule := range rules {
+		resp.Rules = append(resp.Rules, KeywordRuleFromModel(rule))
+	}
+	return resp, nil
+}
+
+func (s *NotificationsServer) DeleteKeywordRule(ctx context.Context, r *notify.DeleteKeywordRuleRequest) (*notify.DeleteKeywordRuleResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	err = s.ucases.Keywords.Delete(ctx, user.Username, r.Id)
+	if errors.Is(err, storage.ErrKeywordRuleNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		s.logger.Errorf("can't delete keyword rule of %s: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't delete keyword rule")
+	}
+	return &notify.DeleteKeywordRuleResponse{}, nil
+}
@@ -1,14 +1,90 @@
 package server
 
 import (
+	"fmt"
 	"github.com/practice-sem-2/notification-service/internal/models"
 	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/practice-sem-2/notification-service/internal/usecase"
+	"google.golang.org/protobuf/encoding/protojson"
+	"sort"
+	"time"
 )
 
+var updateTypeKinds = map[notify.UpdateType]models.UpdateKind{
+	notify.UpdateType_UPDATE_TYPE_MESSAGE:        models.KindMessageSent,
+	notify.UpdateType_UPDATE_TYPE_NEW_CHAT:       models.KindChatCreated,
+	notify.UpdateType_UPDATE_TYPE_DELETED_CHAT:   models.KindChatDeleted,
+	notify.UpdateType_UPDATE_TYPE_MEMBER_ADDED:   models.KindMemberAdded,
+	notify.UpdateType_UPDATE_TYPE_MEMBER_REMOVED: models.KindMemberRemoved,
+}
+
+// KindsFromUpdateTypes returns update kinds for types. It fails on unknown types.
+func KindsFromUpdateTypes(types []notify.UpdateType) ([]models.UpdateKind, bool) {
+	kinds := make([]models.UpdateKind, 0, len(types))
+	for _, t := range types {
+		kind, ok := updateTypeKinds[t]
+		if !ok {
+			return nil, false
+		}
+		kinds = append(kinds, kind)
+	}
+	return kinds, true
+}
+
+// UpdateTypesFromKinds is the reverse of KindsFromUpdateTypes
+func UpdateTypesFromKinds(kinds []models.UpdateKind) []notify.UpdateType {
+	types := make([]notify.UpdateType, 0, len(kinds))
+	for t, kind := range updateTypeKinds {
+		for _, k := range kinds {
+			if k == kind {
+				types = append(types, t)
+			}
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+func NotificationFromModel(n models.Notification) *notify.Notification {
+	notification := NotificationFromUpdate(n.Update)
+	if notification != nil {
+		notification.Seq = n.Seq
+		notification.Id = n.ID
+		notification.Read = n.Read
+		notification.Silent = n.Silent
+		if message := notification.GetMessage(); message != nil {
+			message.Reason = messageReasons[n.Reason]
+		}
+	}
+	return notification
+}
+
+var messageReasons = map[models.Reason]notify.MessageReason{
+	models.ReasonNone:    notify.MessageReason_MESSAGE_REASON_NONE,
+	models.ReasonMention: notify.MessageReason_MESSAGE_REASON_MENTION,
+	models.ReasonReply:   notify.MessageReason_MESSAGE_REASON_REPLY,
+	models.ReasonKeyword: notify.MessageReason_MESSAGE_REASON_KEYWORD,
+}
+
 func NotificationFromUpdate(upd models.Update) *notify.Notification {
 	switch upd.(type) {
 	case *models.MessageSent:
 		return makeMessageSentNotification(upd.(*models.MessageSent))
+	case *models.ChatCreated:
+		return makeChatCreatedNotification(upd.(*models.ChatCreated))
+	case *models.ChatDeleted:
+		return makeChatDeletedNotification(upd.(*models.ChatDeleted))
+	case *models.MemberAdded:
+		return makeMemberAddedNotification(upd.(*models.MemberAdded))
+	case *models.MemberRemoved:
+		return makeMemberRemovedNotification(upd.(*models.MemberRemoved))
+	case *models.UnreadChanged:
+		return &notify.Notification{
+			Notification: &notify.Notification_UnreadChanged{
+				UnreadChanged: UnreadCountsFromModel(upd.(*models.UnreadChanged).Counts),
+			},
+		}
 	}
 	return nil
 }
@@ -35,3 +111,224 @@ func makeMessageSentNotification(upd *models.MessageSent) *notify.Notification {
 		},
 	}
 }
+
+func makeChatCreatedNotification(upd *models.ChatCreated) *notify.Notification {
+	return &notify.Notification{
+		Notification: &notify.Notification_NewChat{
+			NewChat: &notify.NewChat{
+				ChatId:    upd.ChatID,
+				CreatedAt: upd.Timestamp.UTC().Unix(),
+				IsDirect:  upd.IsDirect,
+				Members:   upd.Members,
+			},
+		},
+	}
+}
+
+func makeChatDeletedNotification(upd *models.ChatDeleted) *notify.Notification {
+	return &notify.Notification{
+		Notification: &notify.Notification_DeletedChat{
+			DeletedChat: &notify.ChatDeleted{
+				ChatId:    upd.ChatID,
+				DeletedAt: upd.Timestamp.UTC().Unix(),
+			},
+		},
+	}
+}
+
+func makeMemberAddedNotification(upd *models.MemberAdded) *notify.Notification {
+	return &notify.Notification{
+		Notification: &notify.Notification_MemberAdded{
+			MemberAdded: &notify.MemberAdded{
+				ChatId:  upd.ChatID,
+				UserId:  upd.UserID,
+				AddedAt: upd.Timestamp.UTC().Unix(),
+			},
+		},
+	}
+}
+
+func makeMemberRemovedNotification(upd *models.MemberRemoved) *notify.Notification {
+	return &notify.Notification{
+		Notification: &notify.Notification_MemberRemoved{
+			MemberRemoved: &notify.MemberRemoved{
+				ChatId:    upd.ChatID,
+				UserId:    upd.UserID,
+				RemovedAt: upd.Timestamp.UTC().Unix(),
+			},
+		},
+	}
+}
+
+func UnreadCountsFromModel(counts models.UnreadCounts) *notify.UnreadCounts {
+	return &notify.UnreadCounts{
+		Total: counts.Total,
+		Chats: counts.Chats,
+	}
+}
+
+// ReadSelectorFromRequest returns selector described by r. It fails if r selects nothing.
+func ReadSelectorFromRequest(r *notify.MarkReadRequest) (storage.ReadSelector, bool) {
+	switch r.Target.(type) {
+	case *notify.MarkReadRequest_Ids:
+		ids := r.Target.(*notify.MarkReadRequest_Ids).Ids.GetIds()
+		return storage.ReadSelector{IDs: ids}, len(ids) > 0
+	case *notify.MarkReadRequest_Chat:
+		chat := r.Target.(*notify.MarkReadRequest_Chat).Chat
+		return storage.ReadSelector{ChatID: chat.GetChatId(), UpToSeq: chat.GetUpToSeq()}, chat.GetChatId() != ""
+	case *notify.MarkReadRequest_All:
+		all := r.Target.(*notify.MarkReadRequest_All).All
+		return storage.ReadSelector{UpToSeq: all.GetUpToSeq()}, true
+	}
+	return storage.ReadSelector{}, false
+}
+
+func PreferencesFromModel(prefs models.Preferences) *notify.Preferences {
+	mutes := make([]*notify.ChatMute, 0, len(prefs.ChatMutes))
+	for chatID, mute := range prefs.ChatMutes {
+		m := &notify.ChatMute{
+			ChatId:         chatID,
+			ExceptMentions: mute.ExceptMentions,
+		}
+		if mute.Until != nil {
+			until := mute.Until.UTC().Unix()
+			m.Until = &until
+		}
+		mutes = append(mutes, m)
+	}
+	sort.Slice(mutes, func(i, j int) bool { return mutes[i].ChatId < mutes[j].ChatId })
+	quietHours := make([]*notify.QuietHours, 0, len(prefs.QuietHours))
+	for _, q := range prefs.QuietHours {
+		weekdays := make([]int32, 0, len(q.Weekdays))
+		for _, d := range q.Weekdays {
+			weekdays = append(weekdays, int32(d))
+		}
+		quietHours = append(quietHours, &notify.QuietHours{
+			Start:    q.Start.String(),
+			End:      q.End.String(),
+			TimeZone: q.TimeZone,
+			Weekdays: weekdays,
+		})
+	}
+	devices := make([]*notify.DevicePreferences, 0, len(prefs.Devices))
+	for deviceID, device := range prefs.Devices {
+		d := &notify.DevicePreferences{
+			DeviceId: deviceID,
+			Silent:   device.Silent,
+		}
+		for _, client := range device.SilentWhileActive {
+			d.SilentWhileActive = append(d.SilentWhileActive, ClientTypeFromModel(client))
+		}
+		devices = append(devices, d)
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].DeviceId < devices[j].DeviceId })
+	return &notify.Preferences{
+		ChatMutes:           mutes,
+		MutedTypes:          UpdateTypesFromKinds(prefs.MutedKinds),
+		QuietHours:          quietHours,
+		MentionsBypassMutes: prefs.MentionsBypassMutes,
+		Devices:             devices,
+		HidePresence:        prefs.HidePresence,
+	}
+}
+
+var clientTypes = map[notify.ClientType]models.ClientType{
+	notify.ClientType_CLIENT_TYPE_WEB:     models.ClientWeb,
+	notify.ClientType_CLIENT_TYPE_DESKTOP: models.ClientDesktop,
+	notify.ClientType_CLIENT_TYPE_MOBILE:  models.ClientMobile,
+}
+
+func ClientTypeFromModel(client models.ClientType) notify.ClientType {
+	for t, c := range clientTypes {
+		if c == client {
+			return t
+		}
+	}
+	return notify.ClientType_CLIENT_TYPE_UNSPECIFIED
+}
+
+func DevicePreferencesFromRequest(r *notify.DevicePreferences) models.DevicePreferences {
+	device := models.DevicePreferences{Silent: r.Silent}
+	for _, t := range r.SilentWhileActive {
+		if client, ok := clientTypes[t]; ok {
+			device.SilentWhileActive = append(device.SilentWhileActive, client)
+		}
+	}
+	return device
+}
+
+func ConnectedDeviceFromState(device usecase.DeviceState) *notify.ConnectedDevice {
+	return &notify.ConnectedDevice{
+		DeviceId:    device.ID,
+		ClientType:  ClientTypeFromModel(device.Client),
+		ConnectedAt: device.ConnectedAt.Unix(),
+		ReadSeq:     device.ReadSeq,
+	}
+}
+
+func QuietHoursFromRequest(schedule []*notify.QuietHours) ([]models.QuietHours, error) {
+	result := make([]models.QuietHours, 0, len(schedule))
+	for _, q := range schedule {
+		start, err := models.ParseTimeOfDay(q.Start)
+		if err != nil {
+			return nil, err
+		}
+		end, err := models.ParseTimeOfDay(q.End)
+		if err != nil {
+			return nil, err
+		}
+		weekdays := make([]time.Weekday, 0, len(q.Weekdays))
+		for _, d := range q.Weekdays {
+			weekdays = append(weekdays, time.Weekday(d))
+		}
+		result = append(result, models.QuietHours{
+			Start:    start,
+			End:      end,
+			TimeZone: q.TimeZone,
+			Weekdays: weekdays,
+		})
+	}
+	return result, nil
+}
+
+// WebhookFromModel maps hook without its secret
+func WebhookFromModel(hook models.Webhook) *notify.Webhook {
+	return &notify.Webhook{
+		Id:        hook.ID,
+		Url:       hook.URL,
+		Failures:  int32(hook.Failures),
+		Disabled:  hook.Disabled,
+		CreatedAt: hook.CreatedAt.Unix(),
+	}
+}
+
+func KeywordRuleFromModel(rule models.KeywordRule) *notify.KeywordRule {
+	return &notify.KeywordRule{
+		Id:        rule.ID,
+		Pattern:   rule.Pattern,
+		Regexp:    rule.Regexp,
+		CreatedAt: rule.CreatedAt.Unix(),
+	}
+}
+
+func PresenceFromModel(p models.UserPresence) *notify.UserPresence {
+	presence := &notify.UserPresence{
+		UserId:  p.UserID,
+		Online:  p.Online,
+		Devices: int32(p.Devices),
+	}
+	if p.LastSeen != nil {
+		lastSeen := p.LastSeen.Unix()
+		presence.LastSeen = &lastSeen
+	}
+	return presence
+}
+
+// MarshalNotification encodes n as JSON the same way it is sent to web clients
+func MarshalNotification(n models.Notification) ([]byte, error) {
+	notification := NotificationFromModel(n)
+	if notification == nil {
+		return nil, fmt.Errorf("%w: %T", storage.ErrUnknownUpdateKind, n.Update)
+	}
+	return protojson.Marshal(notification)
+}
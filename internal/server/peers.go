@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+// peerSecretKey is the metadata key carrying the secret shared by instances
+const peerSecretKey = "x-peer-secret"
+
+// PeerAuthInterceptor rejects calls of the peer service which don't carry secret.
+// Peers can disconnect any device and inject notifications to any user, so the port
+// must be reachable by other instances only. The secret guards it in case it isn't.
+func PeerAuthInterceptor(secret string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get(peerSecretKey)
+		if len(values) != 1 || subtle.ConstantTimeCompare([]byte(values[0]), []byte(secret)) != 1 {
+			return nil, status.Error(codes.Unauthenticated, "invalid peer secret")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// peerCredentials attaches the shared secret to every call to other instances
+type peerCredentials string
+
+func (c peerCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{peerSecretKey: string(c)}, nil
+}
+
+// RequireTransportSecurity is false, since peers talk over the private network of the cluster
+func (c peerCredentials) RequireTransportSecurity() bool {
+	return false
+}
+
+// PeerServer receives notifications other instances forward to listeners of this one
+type PeerServer struct {
+	notify.UnimplementedNotificationPeerServer
+	store  *storage.NotificationStore
+	logger *logrus.Logger
+}
+
+func NewPeerServer(store *storage.NotificationStore, logger *logrus.Logger) *PeerServer {
+	return &PeerServer{
+		store:  store,
+		logger: logger,
+	}
+}
+
+func (s *PeerServer) Forward(_ context.Context, r *notify.ForwardRequest) (*notify.ForwardResponse, error) {
+	for _, forwarded := range r.Notifications {
+		n, err := NotificationFromForwarded(forwarded)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		s.store.Notify(n)
+	}
+	return &notify.ForwardResponse{}, nil
+}
+
+func (s *PeerServer) Kick(_ context.Context, r *notify.KickRequest) (*notify.KickResponse, error) {
+	reason := storage.ErrDeviceKicked
+	if r.Replaced {
+		reason = storage.ErrDeviceReplaced
+	}
+	return &notify.KickResponse{Kicked: s.store.Disconnect(r.UserId, r.DeviceId, reason)}, nil
+}
+
+// PeerClient forwards notifications to other instances over gRPC
+type PeerClient struct {
+	secret peerCredentials
+	mu     sync.Mutex
+	conns  map[string]*grpc.ClientConn
+}
+
+// NewPeerClient creates a client authenticating to other instances with secret
+func NewPeerClient(secret string) *PeerClient {
+	return &PeerClient{
+		secret: peerCredentials(secret),
+		conns:  make(map[string]*grpc.ClientConn),
+	}
+}
+
+func (c *PeerClient) Forward(ctx context.Context, inst models.Instance, notifications []models.Notification) error {
+	r := &notify.ForwardRequest{
+		Notifications: make([]*notify.ForwardedNotification, 0, len(notifications)),
+	}
+	for _, n := range notifications {
+		forwarded, err := ForwardedFromNotification(n)
+		if err != nil {
+			return err
+		}
+		r.Notifications = append(r.Notifications, forwarded)
+	}
+
+	conn, err := c.conn(inst.Address)
+	if err != nil {
+		return err
+	}
+	_, err = notify.NewNotificationPeerClient(conn).Forward(ctx, r)
+	if err != nil {
+		// Address may belong to a dead instance, so the connection isn't kept
+		c.drop(inst.Address, conn)
+	}
+	return err
+}
+
+func (c *PeerClient) Kick(
+	ctx context.Context,
+	inst models.Instance,
+	userID string,
+	deviceID string,
+	reason error,
+) (bool, error) {
+	conn, err := c.conn(inst.Address)
+	if err != nil {
+		return false, err
+	}
+	resp, err := notify.NewNotificationPeerClient(conn).Kick(ctx, &notify.KickRequest{
+		UserId:   userID,
+		DeviceId: deviceID,
+		Replaced: errors.Is(reason, storage.ErrDeviceReplaced),
+	})
+	if err != nil {
+		c.drop(inst.Address, conn)
+		return false, err
+	}
+	return resp.Kicked, nil
+}
+
+func (c *PeerClient) conn(address string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.conns[address]; ok {
+		return conn, nil
+	}
+	conn, err := grpc.Dial(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(c.secret),
+	)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[address] = conn
+	return conn, nil
+}
+
+func (c *PeerClient) drop(address string, conn *grpc.ClientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns[address] == conn {
+		delete(c.conns, address)
+		_ = conn.Close()
+	}
+}
+
+// Close closes connections to all peers
+func (c *PeerClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for address, conn := range c.conns {
+		_ = conn.Close()
+		delete(c.conns, address)
+	}
+	return nil
+}
+
+func ForwardedFromNotification(n models.Notification) (*notify.ForwardedNotification, error) {
+	payload, err := storage.MarshalUpdate(n.Update)
+	if err != nil {
+		return nil, err
+	}
+	return &notify.ForwardedNotification{
+		Id:        n.ID,
+		UserId:    n.UserID,
+		Seq:       n.Seq,
+		Kind:      string(models.KindOf(n.Update)),
+		Payload:   payload,
+		CreatedAt: n.CreatedAt.UnixNano(),
+		Silent:    n.Silent,
+		Reason:    string(n.Reason),
+	}, nil
+}
+
+func NotificationFromForwarded(f *notify.ForwardedNotification) (models.Notification, error) {
+	upd, err := storage.UnmarshalUpdate(models.UpdateKind(f.Kind), f.Payload)
+	if err != nil {
+		return models.Notification{}, err
+	}
+	return models.Notification{
+		Update:    upd,
+		ID:        f.Id,
+		UserID:    f.UserId,
+		Seq:       f.Seq,
+		CreatedAt: time.Unix(0, f.CreatedAt).UTC(),
+		Silent:    f.Silent,
+		Reason:    models.Reason(f.Reason),
+	}, nil
+}
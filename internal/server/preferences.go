@@ -0,0 +1,134 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+func (s *NotificationsServer) GetPreferences(ctx context.Context, _ *notify.GetPreferencesRequest) (*notify.Preferences, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	prefs, err := s.ucases.Preferences.Get(ctx, user.Username)
+	return s.preferencesResponse(user.Username, prefs, err)
+}
+
+func (s *NotificationsServer) MuteChat(ctx context.Context, r *notify.ChatMute) (*notify.Preferences, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if r.ChatId == "" {
+		return nil, status.Error(codes.InvalidArgument, "chat id is required")
+	}
+
+	var until *time.Time
+	if r.Until != nil {
+		t := time.Unix(*r.Until, 0).UTC()
+		until = &t
+	}
+	prefs, err := s.ucases.Preferences.MuteChat(ctx, user.Username, r.ChatId, until, r.ExceptMentions)
+	return s.preferencesResponse(user.Username, prefs, err)
+}
+
+func (s *NotificationsServer) UnmuteChat(ctx context.Context, r *notify.UnmuteChatRequest) (*notify.Preferences, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if r.ChatId == "" {
+		return nil, status.Error(codes.InvalidArgument, "chat id is required")
+	}
+
+	prefs, err := s.ucases.Preferences.UnmuteChat(ctx, user.Username, r.ChatId)
+	return s.preferencesResponse(user.Username, prefs, err)
+}
+
+func (s *NotificationsServer) SetMutedTypes(ctx context.Context, r *notify.SetMutedTypesRequest) (*notify.Preferences, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	kinds, ok := KindsFromUpdateTypes(r.Types)
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown update type")
+	}
+	prefs, err := s.ucases.Preferences.SetMutedKinds(ctx, user.Username, kinds)
+	return s.preferencesResponse(user.Username, prefs, err)
+}
+
+func (s *NotificationsServer) preferencesResponse(userID string, prefs models.Preferences, err error) (*notify.Preferences, error) {
+	if err != nil {
+		s.logger.Errorf("can't access preferences of %s: %v", userID, err)
+		return nil, status.Error(codes.Internal, "can't access preferences")
+	}
+	return PreferencesFromModel(prefs), nil
+}
+
+func (s *NotificationsServer) SetQuietHours(ctx context.Context, r *notify.SetQuietHoursRequest) (*notify.Preferences, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	schedule, err := QuietHoursFromRequest(r.QuietHours)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	prefs, err := s.ucases.Preferences.SetQuietHours(ctx, user.Username, schedule)
+	if errors.Is(err, models.ErrInvalidQuietHours) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return s.preferencesResponse(user.Username, prefs, err)
+}
+
+func (s *NotificationsServer) SetDevicePreferences(
+	ctx context.Context,
+	r *notify.SetDevicePreferencesRequest,
+) (*notify.Preferences, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if r.Preferences.GetDeviceId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "device id is required")
+	}
+
+	prefs, err := s.ucases.Preferences.SetDevicePreferences(
+		ctx, user.Username, r.Preferences.DeviceId, DevicePreferencesFromRequest(r.Preferences))
+	return s.preferencesResponse(user.Username, prefs, err)
+}
+
+func (s *NotificationsServer) SetMentionsBypassMutes(
+	ctx context.Context,
+	r *notify.SetMentionsBypassMutesRequest,
+) (*notify.Preferences, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	prefs, err := s.ucases.Preferences.SetMentionsBypassMutes(ctx, user.Username, r.Enabled)
+	return s.preferencesResponse(user.Username, prefs, err)
+}
+
+func (s *NotificationsServer) SetHidePresence(
+	ctx context.Context,
+	r *notify.SetHidePresenceRequest,
+) (*notify.Preferences, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	prefs, err := s.ucases.Preferences.SetHidePresence(ctx, user.Username, r.Enabled)
+	return s.preferencesResponse(user.Username, prefs, err)
+}
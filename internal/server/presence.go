@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"github.com/practice-sem-2/notification-service/internal/usecase"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *NotificationsServer) GetPresence(ctx context.Context, r *notify.GetPresenceRequest) (*notify.GetPresenceResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	presence, err := s.ucases.Presence.Get(ctx, user.Username, r.UserIds)
+	if errors.Is(err, usecase.ErrTooManyUsers) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		s.logger.Errorf("can't get presence for %s: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't get presence")
+	}
+	resp := &notify.GetPresenceResponse{
+		Presence: make([]*notify.UserPresence, 0, len(presence)),
+	}
+	for _, p := range presence {
+		resp.Presence = append(resp.Presence, PresenceFromModel(p))
+	}
+	return resp, nil
+}
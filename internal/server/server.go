@@ -1,7 +1,10 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"github.com/practice-sem-2/notification-service/internal/storage"
 	"github.com/practice-sem-2/notification-service/internal/usecase"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
@@ -11,13 +14,15 @@ import (
 type NotificationsServer struct {
 	logger *logrus.Logger
 	notify.UnimplementedNotificationsServer
-	ucases *usecase.UseCase
+	ucases   *usecase.UseCase
+	streamer *Streamer
 }
 
-func NewNotificationServer(ucases *usecase.UseCase, l *logrus.Logger) *NotificationsServer {
+func NewNotificationServer(ucases *usecase.UseCase, streamer *Streamer, l *logrus.Logger) *NotificationsServer {
 	return &NotificationsServer{
-		ucases: ucases,
-		logger: l,
+		ucases:   ucases,
+		logger:   l,
+		streamer: streamer,
 	}
 }
 
@@ -31,22 +36,115 @@ func (s *NotificationsServer) Listen(r *notify.ListenRequest, server notify.Noti
 	}
 	s.logger.Infof("Listening notifications for %s", user.Username)
 
-	listener := s.ucases.Notifications.Listen(user.Username)
-
-	for {
-		select {
-		case <-server.Context().Done():
-			s.logger.Infof("User %s detached", user.Username)
-			listener.Detach()
-			return nil
-		case upd := <-listener.Notifications():
-			notification := NotificationFromUpdate(upd)
-			if notification != nil {
-				err := server.Send(notification)
-				if err != nil {
-					return err
-				}
-			}
+	// Heartbeats of gRPC streams are HTTP/2 keepalive pings configured on the server
+	kinds, ok := KindsFromUpdateTypes(r.Types)
+	if !ok {
+		return status.Error(codes.InvalidArgument, "unknown update type")
+	}
+	opts := StreamOptions{
+		SinceSeq:      r.SinceSeq,
+		WatchPresence: r.WatchPresence,
+		DeviceID:      r.DeviceId,
+		Client:        clientTypes[r.ClientType],
+		Filter: storage.ListenFilter{
+			ChatIDs:      r.ChatIds,
+			Kinds:        kinds,
+			MentionsOnly: r.MentionsOnly,
+		},
+	}
+	err = s.streamer.stream(server.Context(), user.Username, opts, server.Send, nil)
+	if errors.Is(err, usecase.ErrTooManyUsers) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if errors.Is(err, storage.ErrDeviceReplaced) || errors.Is(err, storage.ErrDeviceKicked) {
+		return status.Error(codes.Aborted, err.Error())
+	}
+	if errors.Is(err, storage.ErrListenerLagging) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	if errors.Is(err, ErrShuttingDown) {
+		return status.Error(codes.Unavailable, err.Error())
+	}
+	return err
+}
+
+func (s *NotificationsServer) GetNotifications(
+	ctx context.Context,
+	r *notify.GetNotificationsRequest,
+) (*notify.GetNotificationsResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	kinds, ok := KindsFromUpdateTypes(r.Types)
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown update type")
+	}
+
+	page, err := s.ucases.Notifications.History(ctx, user.Username, usecase.HistoryRequest{
+		Cursor: r.Cursor,
+		Limit:  int(r.Limit),
+		ChatID: r.GetChatId(),
+		Kinds:  kinds,
+	})
+	if errors.Is(err, usecase.ErrInvalidCursor) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		s.logger.Errorf("can't get notifications of %s: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't get notifications")
+	}
+
+	resp := &notify.GetNotificationsResponse{
+		Notifications: make([]*notify.Notification, 0, len(page.Notifications)),
+		NextCursor:    page.NextCursor,
+	}
+	for _, n := range page.Notifications {
+		if notification := NotificationFromModel(n); notification != nil {
+			resp.Notifications = append(resp.Notifications, notification)
 		}
 	}
+	return resp, nil
+}
+
+func (s *NotificationsServer) MarkRead(ctx context.Context, r *notify.MarkReadRequest) (*notify.MarkReadResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	sel, ok := ReadSelectorFromRequest(r)
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "nothing to mark as read")
+	}
+
+	counts, err := s.ucases.Notifications.MarkRead(ctx, user.Username, sel)
+	if err != nil {
+		s.logger.Errorf("can't mark notifications of %s as read: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't mark notifications as read")
+	}
+	// Only marking everything up to a seq tells how far the device has read
+	if all := r.GetAll(); r.DeviceId != "" && all.GetUpToSeq() > 0 {
+		err := s.ucases.ConnectedDevices.Read(ctx, user.Username, r.DeviceId, all.GetUpToSeq())
+		if err != nil {
+			s.logger.Errorf("can't move read cursor of device %s of %s: %v", r.DeviceId, user.Username, err)
+			return nil, status.Error(codes.Internal, "can't move read cursor")
+		}
+	}
+	return &notify.MarkReadResponse{Counts: UnreadCountsFromModel(counts)}, nil
+}
+
+func (s *NotificationsServer) GetUnreadCounts(ctx context.Context, _ *notify.GetUnreadCountsRequest) (*notify.UnreadCounts, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	counts, err := s.ucases.Notifications.UnreadCounts(ctx, user.Username)
+	if err != nil {
+		s.logger.Errorf("can't get unread counts of %s: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't get unread counts")
+	}
+	return UnreadCountsFromModel(counts), nil
 }
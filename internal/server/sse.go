@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"github.com/practice-sem-2/notification-service/internal/usecase"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+	"net/http"
+	"strconv"
+)
+
+// sseRetry is how long browsers wait before reconnecting, in milliseconds
+const sseRetry = 3000
+
+// EventStreamHandler streams notifications as Server-Sent Events. Event ids are
+// sequence numbers, so Last-Event-ID of a reconnecting browser resumes the stream.
+type EventStreamHandler struct {
+	ucases   *usecase.UseCase
+	logger   *logrus.Logger
+	streamer *Streamer
+}
+
+func NewEventStreamHandler(ucases *usecase.UseCase, streamer *Streamer, l *logrus.Logger) *EventStreamHandler {
+	return &EventStreamHandler{
+		ucases:   ucases,
+		logger:   l,
+		streamer: streamer,
+	}
+}
+
+func (h *EventStreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	user, err := h.ucases.Verifier.GetUser(httpAuthContext(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	sinceSeq, err := sinceSeqFromEventStream(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := streamOptionsFromQuery(r, sinceSeq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stops nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry); err != nil {
+		return
+	}
+	flusher.Flush()
+	h.logger.Infof("Listening notifications for %s over event stream", user.Username)
+
+	send := func(n *notify.Notification) error {
+		data, err := protojson.Marshal(n)
+		if err != nil {
+			return err
+		}
+		// Notifications without seq aren't stored, so they don't move Last-Event-ID.
+		// An empty id field would reset it, so it is omitted instead.
+		if n.Seq != 0 {
+			if _, err := fmt.Fprintf(w, "id: %d\n", n.Seq); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	heartbeat := func() error {
+		if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	err = h.streamer.stream(r.Context(), user.Username, opts, send, heartbeat)
+	if err != nil {
+		// Browsers reconnect on their own once the response ends
+		h.logger.Infof("Event stream of %s ended: %v", user.Username, err)
+	}
+}
+
+// sinceSeqFromEventStream prefers Last-Event-ID sent by reconnecting browsers
+// over since_seq query parameter of the first connection
+func sinceSeqFromEventStream(r *http.Request) (*int64, error) {
+	raw := r.Header.Get("Last-Event-ID")
+	if raw == "" {
+		return sinceSeqFromQuery(r)
+	}
+	seq, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || seq < 0 {
+		return nil, fmt.Errorf("invalid Last-Event-ID: %q", raw)
+	}
+	return &seq, nil
+}
@@ -0,0 +1,366 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/practice-sem-2/notification-service/internal/usecase"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// HeartbeatPeriod is how often idle streams are pinged, so proxies
+// don't drop them and dead clients are detected
+const HeartbeatPeriod = 30 * time.Second
+
+// seenPeriod is how often activity of connected users is recorded
+const seenPeriod = 5 * time.Minute
+
+// silencePeriod is how often silence of a device is evaluated again, so changes
+// of its preferences and devices connected to other instances are picked up
+const silencePeriod = 30 * time.Second
+
+// maxReorderedSeqs is how far behind the latest sent notification a live one may come
+// and still be sent. Seqs of a user are stored in order, but notifications are fanned out
+// by different instances, so live ones may come out of order.
+const maxReorderedSeqs = 1024
+
+var (
+	ErrShuttingDown = errors.New("server is shutting down")
+)
+
+// Streamer pushes notifications of users to clients over any transport.
+// All transports share it, so closing it ends every stream on shutdown.
+type Streamer struct {
+	ucases    *usecase.UseCase
+	logger    *logrus.Logger
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func NewStreamer(ucases *usecase.UseCase, l *logrus.Logger) *Streamer {
+	return &Streamer{
+		ucases: ucases,
+		logger: l,
+		done:   make(chan struct{}),
+	}
+}
+
+// Close ends all current streams with ErrShuttingDown and makes new ones end at once
+func (s *Streamer) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+}
+
+// StreamOptions are set by the client when it starts listening
+type StreamOptions struct {
+	// If set, notifications stored after it are replayed first
+	SinceSeq *int64
+	// Presence of these users is sent at start and then on every change
+	WatchPresence []string
+	// DeviceID, if set, tells which device listens, so it may be kicked and have its own preferences
+	DeviceID string
+	Client   models.ClientType
+	// Filter restricts notifications sent to the client, replayed ones included
+	Filter storage.ListenFilter
+}
+
+// stream sends notifications of userID until ctx is done or send fails.
+// heartbeat, if not nil, is called every HeartbeatPeriod.
+// It returns the reason the store detached the listener, e.g. ErrListenerLagging,
+// and ErrShuttingDown if the streamer was closed.
+func (s *Streamer) stream(
+	ctx context.Context,
+	userID string,
+	opts StreamOptions,
+	send func(*notify.Notification) error,
+	heartbeat func() error,
+) error {
+	select {
+	case <-s.done:
+		return ErrShuttingDown
+	default:
+	}
+
+	// Listener is attached before replay, so nothing stored during replay is missed
+	listener := s.ucases.Notifications.Listen(userID, storage.ListenOptions{
+		DeviceID: opts.DeviceID,
+		Client:   opts.Client,
+		Filter:   opts.Filter,
+	})
+	defer listener.Detach()
+	s.seen(userID)
+	defer s.seen(userID)
+	lastSeen := time.Now()
+
+	// Silence of the device depends on its preferences and other connected devices,
+	// so it is evaluated on connect and again when they may have changed
+	var silent bool
+	var silenceTick <-chan time.Time
+	var devicesChanged <-chan struct{}
+	if opts.DeviceID != "" {
+		if err := s.ucases.ConnectedDevices.Connected(ctx, userID, opts.DeviceID); err != nil {
+			s.logger.Errorf("can't detach device %s of %s from other instances: %v", opts.DeviceID, userID, err)
+		}
+		silent = s.deviceSilent(ctx, userID, opts.DeviceID)
+		silenceTicker := time.NewTicker(silencePeriod)
+		defer silenceTicker.Stop()
+		silenceTick = silenceTicker.C
+		devicesChanged = listener.DevicesChanged()
+	}
+
+	sendModel := func(n models.Notification) error {
+		// Live notifications are filtered by the store, but replayed ones come from the inbox
+		if !opts.Filter.Matches(n) {
+			return nil
+		}
+		if silent {
+			n.Silent = true
+		}
+		notification := NotificationFromModel(n)
+		if notification == nil {
+			return nil
+		}
+		return send(notification)
+	}
+
+	// Devices resume from where they've read unless told otherwise
+	if opts.SinceSeq == nil && opts.DeviceID != "" {
+		cursor, err := s.ucases.ConnectedDevices.ReadCursor(ctx, userID, opts.DeviceID)
+		if err != nil {
+			return err
+		}
+		if cursor > 0 {
+			opts.SinceSeq = &cursor
+		}
+	}
+
+	seqs := newSentSeqs()
+	if opts.SinceSeq != nil {
+		s.logger.Infof("Replaying notifications of %s since %d", userID, *opts.SinceSeq)
+		last, err := s.ucases.Notifications.Replay(ctx, userID, *opts.SinceSeq, sendModel)
+		if err != nil {
+			return err
+		}
+		seqs.replayed(last)
+	}
+
+	// Presence is polled for all streams of the instance at once, the stream sends changes only
+	var presenceUpdates <-chan []models.UserPresence
+	watch := usecase.NewPresenceWatch()
+	if len(opts.WatchPresence) > 0 {
+		sub, err := s.ucases.Presence.Watch(ctx, userID, opts.WatchPresence)
+		if err != nil {
+			return err
+		}
+		defer s.ucases.Presence.Unwatch(sub)
+		presenceUpdates = sub.Updates()
+
+		presence, err := s.ucases.Presence.Get(ctx, userID, opts.WatchPresence)
+		if err != nil {
+			return err
+		}
+		if err := s.sendPresence(presence, watch, send); err != nil {
+			return err
+		}
+	}
+
+	// sendLive skips notifications which were already sent during replay or live
+	sendLive := func(n models.Notification) error {
+		if n.Seq != 0 && seqs.sent(n.Seq) {
+			return nil
+		}
+		if err := sendModel(n); err != nil {
+			return err
+		}
+		if n.Seq != 0 {
+			seqs.add(n.Seq)
+		}
+		return nil
+	}
+
+	ticker := time.NewTicker(HeartbeatPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			s.logger.Infof("User %s detached", userID)
+			return nil
+		case <-s.done:
+			return ErrShuttingDown
+		case <-ticker.C:
+			if time.Since(lastSeen) >= seenPeriod {
+				s.seen(userID)
+				lastSeen = time.Now()
+			}
+			if heartbeat == nil {
+				continue
+			}
+			if err := heartbeat(); err != nil {
+				return err
+			}
+		case <-devicesChanged:
+			silent = s.deviceSilent(ctx, userID, opts.DeviceID)
+		case <-silenceTick:
+			silent = s.deviceSilent(ctx, userID, opts.DeviceID)
+		case presence := <-presenceUpdates:
+			if err := s.sendPresence(presence, watch, send); err != nil {
+				return err
+			}
+		case n, ok := <-listener.Notifications():
+			if !ok {
+				return listener.Err()
+			}
+			if err := sendLive(n); err != nil {
+				return err
+			}
+		case <-listener.Behind():
+			s.logger.Infof("Listener of %s is behind. Catching up from inbox", userID)
+			if err := s.catchUp(ctx, &listener, sendLive, seqs); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// seen records that userID is online, so email digests are sent only to offline users
+// and contacts know when the user was last seen
+func (s *Streamer) seen(userID string) {
+	// Called when the stream context is already done too
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.ucases.Digests.Seen(ctx, userID); err != nil {
+		s.logger.Errorf("can't record activity of %s: %v", userID, err)
+	}
+	if err := s.ucases.Presence.Seen(ctx, userID); err != nil {
+		s.logger.Errorf("can't record last seen of %s: %v", userID, err)
+	}
+}
+
+// deviceSilent reports whether preferences of deviceID make it get notifications silently
+func (s *Streamer) deviceSilent(ctx context.Context, userID string, deviceID string) bool {
+	silent, err := s.ucases.ConnectedDevices.Silent(ctx, userID, deviceID)
+	if err != nil {
+		s.logger.Errorf("can't get preferences of device %s of %s: %v", deviceID, userID, err)
+		return false
+	}
+	return silent
+}
+
+// sendPresence sends presence of watched users which changed since the last check
+func (s *Streamer) sendPresence(
+	presence []models.UserPresence,
+	watch *usecase.PresenceWatch,
+	send func(*notify.Notification) error,
+) error {
+	for _, p := range watch.Changes(presence, time.Now()) {
+		err := send(&notify.Notification{
+			Notification: &notify.Notification_PresenceChanged{
+				PresenceChanged: PresenceFromModel(p),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catchUp sends everything buffered by listener and then notifications
+// which didn't fit into the buffer and were left in the inbox. The store stops
+// buffering stored notifications once it spills, so everything buffered precedes them.
+func (s *Streamer) catchUp(
+	ctx context.Context,
+	listener *storage.NotificationListener,
+	sendLive func(models.Notification) error,
+	seqs *sentSeqs,
+) error {
+	for drained := false; !drained; {
+		select {
+		case n, ok := <-listener.Notifications():
+			if !ok {
+				return listener.Err()
+			}
+			if err := sendLive(n); err != nil {
+				return err
+			}
+		default:
+			drained = true
+		}
+	}
+	listener.CaughtUp()
+
+	// Replay starts before gaps, since notifications sent live may have skipped some
+	since, ok := seqs.since()
+	if !ok {
+		s.logger.Warnf("Can't catch up %s: position in inbox is unknown", listener.UserID)
+		return nil
+	}
+	last, err := s.ucases.Notifications.Replay(ctx, listener.UserID, since, sendLive)
+	seqs.replayed(last)
+	return err
+}
+
+// sentSeqs tracks stored notifications sent to a stream, so those coming
+// both from the inbox and live are sent once
+type sentSeqs struct {
+	// Every notification up to upTo was sent or is too late to be sent live
+	upTo int64
+	// live holds seqs above upTo which were sent live
+	live map[int64]bool
+	last int64
+}
+
+func newSentSeqs() *sentSeqs {
+	return &sentSeqs{live: make(map[int64]bool)}
+}
+
+func (s *sentSeqs) sent(seq int64) bool {
+	return seq <= s.upTo || s.live[seq]
+}
+
+// add records that seq was sent live
+func (s *sentSeqs) add(seq int64) {
+	s.live[seq] = true
+	if seq > s.last {
+		s.last = seq
+	}
+	if len(s.live) > maxReorderedSeqs {
+		s.replayed(s.last - maxReorderedSeqs)
+	}
+}
+
+// replayed records that every notification up to seq was sent
+func (s *sentSeqs) replayed(seq int64) {
+	if seq <= s.upTo {
+		return
+	}
+	s.upTo = seq
+	if seq > s.last {
+		s.last = seq
+	}
+	for live := range s.live {
+		if live <= seq {
+			delete(s.live, live)
+		}
+	}
+}
+
+// since returns the seq after which nothing may be missing. It is false if nothing
+// was replayed or sent yet, so the position in the inbox is unknown.
+func (s *sentSeqs) since() (int64, bool) {
+	if s.upTo > 0 {
+		return s.upTo, true
+	}
+	var first int64
+	for seq := range s.live {
+		if first == 0 || seq < first {
+			first = seq
+		}
+	}
+	return first - 1, first > 0
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/practice-sem-2/notification-service/internal/usecase"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *NotificationsServer) RegisterWebhook(ctx context.Context, r *notify.RegisterWebhookRequest) (*notify.Webhook, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	hook, err := s.ucases.Webhooks.Register(ctx, user.Username, r.Url)
+	if errors.Is(err, usecase.ErrInvalidWebhookURL) || errors.Is(err, usecase.ErrWebhookUnreachable) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		s.logger.Errorf("can't register webhook of %s: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't register webhook")
+	}
+	// Secret is returned only once, so it doesn't leak through listing
+	webhook := WebhookFromModel(hook)
+	webhook.Secret = hook.Secret
+	return webhook, nil
+}
+
+func (s *NotificationsServer) ListWebhooks(ctx context.Context, _ *notify.ListWebhooksRequest) (*notify.ListWebhooksResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	hooks, err := s.ucases.Webhooks.List(ctx, user.Username)
+	if err != nil {
+		s.logger.Errorf("can't list webhooks of %s: %v", user.Username, err)
+		return nil, status.Error(codes.Internal, "can't list webhooks")
+	}
+	resp := &notify.ListWebhooksResponse{
+		Webhooks: make([]*notify.Webhook, 0, len(hooks)),
+	}
+	for _, hook := range hooks {
+		resp.Webhooks = append(resp.Webhooks, WebhookFromModel(hook))
+	}
+	return resp, nil
+}
+
+func (s *NotificationsServer) DeleteWebhook(ctx context.Context, r *notify.DeleteWebhookRequest) (*notify.DeleteWebhookResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	err = s.ucases.Webhooks.Delete(ctx, user.Username, r.Id)
+	if err != nil {
+		return nil, s.webhookError(user.Username, err)
+	}
+	return &notify.DeleteWebhookResponse{}, nil
+}
+
+func (s *NotificationsServer) EnableWebhook(ctx context.Context, r *notify.EnableWebhookRequest) (*notify.EnableWebhookResponse, error) {
+	user, err := s.ucases.Verifier.GetUser(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	err = s.ucases.Webhooks.Enable(ctx, user.Username, r.Id)
+	if err != nil {
+		return nil, s.webhookError(user.Username, err)
+	}
+	return &notify.EnableWebhookResponse{}, nil
+}
+
+func (s *NotificationsServer) webhookError(userID string, err error) error {
+	if errors.Is(err, storage.ErrWebhookNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	s.logger.Errorf("can't change webhook of %s: %v", userID, err)
+	return status.Error(codes.Internal, "can't change webhook")
+}
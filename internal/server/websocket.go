@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/practice-sem-2/notification-service/internal/pb/notify"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/practice-sem-2/notification-service/internal/usecase"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/encoding/protojson"
+	"net/http"
+	"time"
+)
+
+const (
+	wsWriteWait = 10 * time.Second
+	// wsPongWait must be longer than HeartbeatPeriod, so the client has time to answer pings
+	wsPongWait = 2 * HeartbeatPeriod
+	// Clients aren't expected to send anything but control frames
+	wsMaxMessageSize = 512
+)
+
+// WebSocketGateway streams notifications as JSON encoded notify.Notification
+// messages to clients which can't use gRPC streaming, such as browsers.
+type WebSocketGateway struct {
+	ucases   *usecase.UseCase
+	logger   *logrus.Logger
+	streamer *Streamer
+	upgrader websocket.Upgrader
+}
+
+// NewWebSocketGateway creates a gateway accepting connections from allowedOrigins.
+// If allowedOrigins is empty, only same origin connections are accepted.
+func NewWebSocketGateway(
+	ucases *usecase.UseCase,
+	streamer *Streamer,
+	l *logrus.Logger,
+	allowedOrigins []string,
+) *WebSocketGateway {
+	g := &WebSocketGateway{
+		ucases:   ucases,
+		logger:   l,
+		streamer: streamer,
+	}
+	if len(allowedOrigins) > 0 {
+		g.upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			for _, allowed := range allowedOrigins {
+				if origin == allowed || allowed == "*" {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return g
+}
+
+func (g *WebSocketGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, err := g.ucases.Verifier.GetUser(httpAuthContext(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	sinceSeq, err := sinceSeqFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts, err := streamOptionsFromQuery(r, sinceSeq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Upgrade replies to the client itself if it fails
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		g.logger.Infof("Can't upgrade connection of %s: %v", user.Username, err)
+		return
+	}
+	defer conn.Close()
+	g.logger.Infof("Listening notifications for %s over websocket", user.Username)
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go g.readPump(conn, cancel)
+
+	send := func(n *notify.Notification) error {
+		data, err := protojson.Marshal(n)
+		if err != nil {
+			return err
+		}
+		_ = conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		return conn.WriteMessage(websocket.TextMessage, data)
+	}
+	// WriteControl is safe to call concurrently with the read pump
+	ping := func() error {
+		return conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+	}
+	err = g.streamer.stream(ctx, user.Username, opts, send, ping)
+
+	code, text := websocket.CloseNormalClosure, ""
+	if errors.Is(err, storage.ErrListenerLagging) {
+		code, text = websocket.CloseTryAgainLater, err.Error()
+	} else if errors.Is(err, storage.ErrDeviceReplaced) || errors.Is(err, storage.ErrDeviceKicked) {
+		code, text = websocket.ClosePolicyViolation, err.Error()
+	} else if errors.Is(err, ErrShuttingDown) {
+		code, text = websocket.CloseGoingAway, err.Error()
+	} else if err != nil {
+		g.logger.Infof("Websocket stream of %s failed: %v", user.Username, err)
+		code, text = websocket.CloseInternalServerErr, "stream failed"
+	}
+	_ = conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, text), time.Now().Add(wsWriteWait))
+}
+
+// readPump handles control frames and cancels streaming once the client is gone
+func (g *WebSocketGateway) readPump(conn *websocket.Conn, cancel context.CancelFunc) {
+	defer cancel()
+	conn.SetReadLimit(wsMaxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package storage
+
+// ahoCorasick finds occurrences of many patterns in a text in a single pass over it
+type ahoCorasick struct {
+	nodes []acNode
+}
+
+type acNode struct {
+	next map[byte]int
+	fail int
+	// outputs are indices of patterns ending at the node, including ones reachable by fail links
+	outputs []int
+}
+
+func newAhoCorasick(patterns []string) *ahoCorasick {
+	a := &ahoCorasick{nodes: []acNode{{next: make(map[byte]int)}}}
+	for i, p := range patterns {
+		node := 0
+		for j := 0; j < len(p); j++ {
+			child, ok := a.nodes[node].next[p[j]]
+			if !ok {
+				child = len(a.nodes)
+				a.nodes = append(a.nodes, acNode{next: make(map[byte]int)})
+				a.nodes[node].next[p[j]] = child
+			}
+			node = child
+		}
+		a.nodes[node].outputs = append(a.nodes[node].outputs, i)
+	}
+
+	// Fail links are set in BFS order, so links of shallower nodes are ready when needed
+	queue := make([]int, 0, len(a.nodes))
+	for _, child := range a.nodes[0].next {
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for b, child := range a.nodes[node].next {
+			fail := a.nodes[node].fail
+			for fail != 0 && !a.has(fail, b) {
+				fail = a.nodes[fail].fail
+			}
+			if next, ok := a.nodes[fail].next[b]; ok && next != child {
+				fail = next
+			}
+			a.nodes[child].fail = fail
+			a.nodes[child].outputs = append(a.nodes[child].outputs, a.nodes[fail].outputs...)
+			queue = append(queue, child)
+		}
+	}
+	return a
+}
+
+func (a *ahoCorasick) has(node int, b byte) bool {
+	_, ok := a.nodes[node].next[b]
+	return ok
+}
+
+// match calls found with the index of every pattern occurring in text, once per occurrence
+func (a *ahoCorasick) match(text string, found func(pattern int)) {
+	node := 0
+	for i := 0; i < len(text); i++ {
+		for node != 0 && !a.has(node, text[i]) {
+			node = a.nodes[node].fail
+		}
+		if next, ok := a.nodes[node].next[text[i]]; ok {
+			node = next
+		}
+		for _, p := range a.nodes[node].outputs {
+			found(p)
+		}
+	}
+}
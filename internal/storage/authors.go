@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+// authorsDeleteBatch limits rows deleted by a single statement, so pruning doesn't hold long locks
+const authorsDeleteBatch = 10000
+
+// MessageAuthors remembers who sent messages, so replies can be attributed
+// to the author of the message they reply to
+type MessageAuthors interface {
+	Remember(ctx context.Context, msg *models.MessageSent) error
+
+	// Author returns the sender of messageID or empty string if it is unknown
+	Author(ctx context.Context, messageID string) (string, error)
+
+	// Forget removes authors of messages sent before before and returns how many were removed
+	Forget(ctx context.Context, before time.Time) (int64, error)
+}
+
+type PostgresAuthors struct {
+	db *sql.DB
+}
+
+func NewPostgresAuthors(db *sql.DB) *PostgresAuthors {
+	return &PostgresAuthors{
+		db: db,
+	}
+}
+
+func (s *PostgresAuthors) Remember(ctx context.Context, msg *models.MessageSent) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO message_authors (message_id, user_id, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (message_id) DO NOTHING`,
+		msg.MessageID, msg.FromUser, msg.Timestamp)
+	return err
+}
+
+func (s *PostgresAuthors) Author(ctx context.Context, messageID string) (string, error) {
+	var author string
+	err := s.db.QueryRowContext(ctx, `
+		SELECT user_id FROM message_authors WHERE message_id = $1`, messageID).Scan(&author)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return author, err
+}
+
+func (s *PostgresAuthors) Forget(ctx context.Context, before time.Time) (int64, error) {
+	var total int64
+	for {
+		res, err := s.db.ExecContext(ctx, `
+			DELETE FROM message_authors
+			WHERE message_id IN (
+				SELECT message_id FROM message_authors WHERE created_at < $1 LIMIT $2
+			)`, before, authorsDeleteBatch)
+		if err != nil {
+			return total, err
+		}
+		deleted, err := res.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+		if deleted < authorsDeleteBatch {
+			return total, nil
+		}
+	}
+}
+
+// MemoryAuthors keeps message authors in process memory. It is meant for tests.
+type MemoryAuthors struct {
+	mu      sync.RWMutex
+	authors map[string]*models.MessageSent
+}
+
+func NewMemoryAuthors() *MemoryAuthors {
+	return &MemoryAuthors{
+		authors: make(map[string]*models.MessageSent),
+	}
+}
+
+func (s *MemoryAuthors) Remember(_ context.Context, msg *models.MessageSent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.authors[msg.MessageID]; !ok {
+		s.authors[msg.MessageID] = msg
+	}
+	return nil
+}
+
+func (s *MemoryAuthors) Author(_ context.Context, messageID string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if msg, ok := s.authors[messageID]; ok {
+		return msg.FromUser, nil
+	}
+	return "", nil
+}
+
+func (s *MemoryAuthors) Forget(_ context.Context, before time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deleted int64
+	for id, msg := range s.authors {
+		if msg.Timestamp.Before(before) {
+			delete(s.authors, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// AuthorsRetention periodically forgets authors of messages older than the retention period.
+// Replies to older messages aren't attributed to their authors then.
+type AuthorsRetention struct {
+	authors   MessageAuthors
+	retention time.Duration
+	logger    *logrus.Logger
+}
+
+func NewAuthorsRetention(authors MessageAuthors, retention time.Duration, logger *logrus.Logger) *AuthorsRetention {
+	return &AuthorsRetention{
+		authors:   authors,
+		retention: retention,
+		logger:    logger,
+	}
+}
+
+// Run forgets outdated authors every interval until ctx is done
+func (r *AuthorsRetention) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		deleted, err := r.authors.Forget(ctx, time.Now().Add(-r.retention))
+		if err != nil && ctx.Err() == nil {
+			r.logger.Errorf("can't forget authors of old messages: %v", err)
+		}
+		if deleted > 0 {
+			r.logger.Infof("Forgot authors of %d messages older than %s", deleted, r.retention)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
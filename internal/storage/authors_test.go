@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestAuthorsRetention(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	authors := NewMemoryAuthors()
+	message := func(sentAt time.Time) *models.MessageSent {
+		return &models.MessageSent{
+			UpdateMeta: models.UpdateMeta{Timestamp: sentAt},
+			MessageID:  uuid.New().String(),
+			FromUser:   "friend",
+		}
+	}
+	old, recent := message(time.Now().Add(-48*time.Hour)), message(time.Now().Add(-time.Hour))
+	assert.NoError(t, authors.Remember(ctx, old))
+	assert.NoError(t, authors.Remember(ctx, recent))
+
+	go NewAuthorsRetention(authors, 24*time.Hour, logrus.New()).Run(ctx, time.Hour)
+
+	assert.Eventually(t, func() bool {
+		author, _ := authors.Author(ctx, old.MessageID)
+		return author == ""
+	}, time.Second, 5*time.Millisecond, "authors of old messages must be forgotten")
+	author, err := authors.Author(ctx, recent.MessageID)
+	assert.NoError(t, err)
+	assert.Equal(t, "friend", author)
+}
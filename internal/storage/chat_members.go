@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"sync"
+)
+
+// ChatMembers tracks who is in which chat as told by updates, so things like presence
+// are shown only to users sharing a chat. Chats are learnt from their updates, so
+// members of chats without updates since tracking began are unknown.
+type ChatMembers interface {
+	// Track records changes of members told by upd
+	Track(ctx context.Context, upd models.Update) error
+
+	// Shared returns which of userIDs share a chat with userID
+	Shared(ctx context.Context, userID string, userIDs []string) (map[string]bool, error)
+}
+
+// membersChange is what an update tells about members of its chat
+type membersChange struct {
+	chatID  string
+	joined  []string
+	left    string
+	deleted bool
+}
+
+func membersChangeOf(upd models.Update) (membersChange, bool) {
+	switch u := upd.(type) {
+	case *models.MessageSent:
+		return membersChange{chatID: u.ChatID, joined: append([]string{u.FromUser}, u.Audience...)}, true
+	case *models.ChatCreated:
+		return membersChange{chatID: u.ChatID, joined: append(append([]string(nil), u.Members...), u.Audience...)}, true
+	case *models.MemberAdded:
+		return membersChange{chatID: u.ChatID, joined: append([]string{u.UserID}, u.Audience...)}, true
+	case *models.MemberRemoved:
+		return membersChange{chatID: u.ChatID, left: u.UserID}, true
+	case *models.ChatDeleted:
+		return membersChange{chatID: u.ChatID, deleted: true}, true
+	}
+	return membersChange{}, false
+}
+
+type PostgresChatMembers struct {
+	db *sql.DB
+}
+
+func NewPostgresChatMembers(db *sql.DB) *PostgresChatMembers {
+	return &PostgresChatMembers{
+		db: db,
+	}
+}
+
+func (m *PostgresChatMembers) Track(ctx context.Context, upd models.Update) error {
+	change, ok := membersChangeOf(upd)
+	if !ok {
+		return nil
+	}
+	if change.deleted {
+		_, err := m.db.ExecContext(ctx, `DELETE FROM chat_members WHERE chat_id = $1`, change.chatID)
+		return err
+	}
+	if change.left != "" {
+		_, err := m.db.ExecContext(ctx, `
+			DELETE FROM chat_members WHERE chat_id = $1 AND user_id = $2`, change.chatID, change.left)
+		return err
+	}
+	users, err := json.Marshal(change.joined)
+	if err != nil {
+		return err
+	}
+	_, err = m.db.ExecContext(ctx, `
+		INSERT INTO chat_members (chat_id, user_id)
+		SELECT $1, jsonb_array_elements_text($2::JSONB)
+		ON CONFLICT DO NOTHING`, change.chatID, string(users))
+	return err
+}
+
+func (m *PostgresChatMembers) Shared(ctx context.Context, userID string, userIDs []string) (map[string]bool, error) {
+	users, err := json.Marshal(userIDs)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT DISTINCT other.user_id
+		FROM chat_members mine
+		JOIN chat_members other ON other.chat_id = mine.chat_id
+		WHERE mine.user_id = $1
+		  AND other.user_id IN (SELECT jsonb_array_elements_text($2::JSONB))`, userID, string(users))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	shared := make(map[string]bool)
+	for rows.Next() {
+		var other string
+		if err := rows.Scan(&other); err != nil {
+			return nil, err
+		}
+		shared[other] = true
+	}
+	return shared, rows.Err()
+}
+
+// MemoryChatMembers keeps chat members in process memory. It is meant for tests.
+type MemoryChatMembers struct {
+	mu    sync.RWMutex
+	chats map[string]map[string]bool
+}
+
+func NewMemoryChatMembers() *MemoryChatMembers {
+	return &MemoryChatMembers{
+		chats: make(map[string]map[string]bool),
+	}
+}
+
+func (m *MemoryChatMembers) Track(_ context.Context, upd models.Update) error {
+	change, ok := membersChangeOf(upd)
+	if !ok {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case change.deleted:
+		delete(m.chats, change.chatID)
+	case change.left != "":
+		delete(m.chats[change.chatID], change.left)
+	default:
+		members, ok := m.chats[change.chatID]
+		if !ok {
+			members = make(map[string]bool)
+			m.chats[change.chatID] = members
+		}
+		for _, userID := range change.joined {
+			members[userID] = true
+		}
+	}
+	return nil
+}
+
+func (m *MemoryChatMembers) Shared(_ context.Context, userID string, userIDs []string) (map[string]bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	shared := make(map[string]bool)
+	for _, members := range m.chats {
+		if !members[userID] {
+			continue
+		}
+		for _, other := range userIDs {
+			if members[other] {
+				shared[other] = true
+			}
+		}
+	}
+	return shared, nil
+}
@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMemoryChatMembers_Track(t *testing.T) {
+	ctx := context.Background()
+	members := NewMemoryChatMembers()
+	upds := []models.Update{
+		&models.ChatCreated{ChatID: "work", Members: []string{"burenotti", "alice"}},
+		&models.MemberAdded{UpdateMeta: models.UpdateMeta{Audience: []string{"burenotti", "alice"}}, ChatID: "work", UserID: "bob"},
+		&models.MessageSent{UpdateMeta: models.UpdateMeta{Audience: []string{"carol"}}, ChatID: "direct", FromUser: "burenotti"},
+		&models.MemberRemoved{ChatID: "work", UserID: "alice"},
+		&models.ChatCreated{ChatID: "old", Members: []string{"burenotti", "dave"}},
+		&models.ChatDeleted{ChatID: "old"},
+	}
+	for _, upd := range upds {
+		assert.NoError(t, members.Track(ctx, upd))
+	}
+
+	shared, err := members.Shared(ctx, "burenotti", []string{"alice", "bob", "carol", "dave", "eve"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"bob": true, "carol": true}, shared)
+	shared, err = members.Shared(ctx, "alice", []string{"burenotti"})
+	assert.NoError(t, err)
+	assert.Empty(t, shared, "removed members must not share the chat")
+}
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"context"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+const (
+	clusterQueueSize = 4096
+	// forwardBatchSize limits how many queued notifications are routed with a single lookup
+	forwardBatchSize = 256
+	forwardTimeout   = 5 * time.Second
+	onlineTimeout    = time.Second
+)
+
+// Peers transports notifications and commands to other instances
+type Peers interface {
+	Forward(ctx context.Context, inst models.Instance, notifications []models.Notification) error
+
+	// Kick disconnects deviceID of userID listening on inst with reason, either ErrDeviceKicked
+	// or ErrDeviceReplaced. It returns false if there is no such device.
+	Kick(ctx context.Context, inst models.Instance, userID string, deviceID string, reason error) (bool, error)
+}
+
+// Cluster routes notifications consumed by this instance to listeners held by other ones.
+// It is a Sink of the store, which tells it which users listen on this instance, so it
+// keeps their presence up to date.
+type Cluster struct {
+	self     models.Instance
+	presence Presence
+	peers    Peers
+	ttl      time.Duration
+	queue    chan models.Notification
+	logger   *logrus.Logger
+
+	mu sync.Mutex
+	// local are devices users listen from on this instance
+	local map[string][]models.ConnectedDevice
+	// pending are users whose presence is to be synced
+	pending map[string]bool
+	changed chan struct{}
+}
+
+func NewCluster(self models.Instance, presence Presence, peers Peers, logger *logrus.Logger) *Cluster {
+	return &Cluster{
+		self:     self,
+		presence: presence,
+		peers:    peers,
+		ttl:      30 * time.Second,
+		queue:    make(chan models.Notification, clusterQueueSize),
+		logger:   logger,
+		local:    make(map[string][]models.ConnectedDevice),
+		pending:  make(map[string]bool),
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+// WithLeaseTTL sets for how long the instance is considered alive after it announced itself.
+// Instances announce themselves three times per ttl.
+func (c *Cluster) WithLeaseTTL(ttl time.Duration) *Cluster {
+	c.ttl = ttl
+	return c
+}
+
+// Deliver queues n to be forwarded to other instances its user listens on
+func (c *Cluster) Deliver(_ context.Context, n models.Notification) {
+	select {
+	case c.queue <- n:
+	default:
+		forwardedNotifications.WithLabelValues("dropped").Inc()
+		c.logger.Warnf("Forwarding queue is full. Dropping notification %d of %s", n.Seq, n.UserID)
+	}
+}
+
+// Online reports whether userID listens without a filter on any other instance
+func (c *Cluster) Online(userID string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), onlineTimeout)
+	defer cancel()
+	devices, err := c.presence.Devices(ctx, userID, c.self.ID)
+	if err != nil {
+		c.logger.Errorf("can't locate %s: %v", userID, err)
+		return false
+	}
+	for _, device := range devices {
+		if !device.Filtered {
+			return true
+		}
+	}
+	return false
+}
+
+// Count returns the number of listeners every one of userIDs has on other instances
+func (c *Cluster) Count(ctx context.Context, userIDs []string) (map[string]int, error) {
+	return c.presence.Count(ctx, userIDs, c.self.ID)
+}
+
+// Devices returns devices userID listens from on other instances
+func (c *Cluster) Devices(ctx context.Context, userID string) ([]models.ConnectedDevice, error) {
+	return c.presence.Devices(ctx, userID, c.self.ID)
+}
+
+// Kick disconnects deviceID of userID with reason on whichever other instance it listens on
+func (c *Cluster) Kick(ctx context.Context, userID string, deviceID string, reason error) (bool, error) {
+	located, err := c.presence.Locate(ctx, []string{userID})
+	if err != nil {
+		return false, err
+	}
+	for _, inst := range located[userID] {
+		if inst.ID == c.self.ID {
+			continue
+		}
+		kicked, err := c.peers.Kick(ctx, inst, userID, deviceID, reason)
+		if err != nil {
+			return false, err
+		}
+		if kicked {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// listenersChanged doesn't touch presence itself, because it is called under the lock of the store
+func (c *Cluster) listenersChanged(userID string, devices []models.ConnectedDevice) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(devices) > 0 {
+		c.local[userID] = devices
+	} else {
+		delete(c.local, userID)
+	}
+	c.pending[userID] = true
+	c.signal()
+}
+
+func (c *Cluster) signal() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Run keeps the instance registered and forwards queued notifications until ctx is done
+func (c *Cluster) Run(ctx context.Context) error {
+	c.announce(ctx)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		c.syncPresence(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		c.forward(ctx)
+	}()
+
+	ticker := time.NewTicker(c.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		case <-ticker.C:
+			c.announce(ctx)
+		}
+	}
+}
+
+func (c *Cluster) announce(ctx context.Context) {
+	fresh, err := c.presence.Announce(ctx, c.self, c.ttl)
+	if err != nil {
+		c.logger.Errorf("can't announce instance %s: %v", c.self.ID, err)
+		return
+	}
+	if !fresh {
+		return
+	}
+	// Presence of the instance is gone with its registration, so all of its users join again
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for userID := range c.local {
+		c.pending[userID] = true
+	}
+	c.signal()
+}
+
+func (c *Cluster) syncPresence(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.changed:
+		}
+
+		c.mu.Lock()
+		pending := c.pending
+		c.pending = make(map[string]bool)
+		c.mu.Unlock()
+
+		for userID := range pending {
+			if err := c.syncUser(ctx, userID); err != nil {
+				c.logger.Errorf("can't update presence of %s: %v", userID, err)
+				c.mu.Lock()
+				c.pending[userID] = true
+				c.mu.Unlock()
+			}
+		}
+	}
+}
+
+// syncUser writes the latest known state of userID, so changes made meanwhile aren't lost
+func (c *Cluster) syncUser(ctx context.Context, userID string) error {
+	c.mu.Lock()
+	devices := c.local[userID]
+	c.mu.Unlock()
+	if len(devices) > 0 {
+		return c.presence.Join(ctx, userID, c.self.ID, devices)
+	}
+	return c.presence.Leave(ctx, userID, c.self.ID)
+}
+
+func (c *Cluster) forward(ctx context.Context) {
+	for {
+		var batch []models.Notification
+		select {
+		case <-ctx.Done():
+			return
+		case n := <-c.queue:
+			batch = append(batch, n)
+		}
+	drain:
+		for len(batch) < forwardBatchSize {
+			select {
+			case n := <-c.queue:
+				batch = append(batch, n)
+			default:
+				break drain
+			}
+		}
+		c.route(ctx, batch)
+	}
+}
+
+// route forwards every notification of batch to the other instances its user listens on
+func (c *Cluster) route(ctx context.Context, batch []models.Notification) {
+	seen := make(map[string]bool)
+	var userIDs []string
+	for _, n := range batch {
+		if !seen[n.UserID] {
+			seen[n.UserID] = true
+			userIDs = append(userIDs, n.UserID)
+		}
+	}
+	located, err := c.presence.Locate(ctx, userIDs)
+	if err != nil {
+		forwardedNotifications.WithLabelValues("failed").Add(float64(len(batch)))
+		c.logger.Errorf("can't locate listeners: %v", err)
+		return
+	}
+
+	instances := make(map[string]models.Instance)
+	routed := make(map[string][]models.Notification)
+	for _, n := range batch {
+		for _, inst := range located[n.UserID] {
+			if inst.ID == c.self.ID {
+				continue
+			}
+			instances[inst.ID] = inst
+			routed[inst.ID] = append(routed[inst.ID], n)
+		}
+	}
+	for id, notifications := range routed {
+		forwardCtx, cancel := context.WithTimeout(ctx, forwardTimeout)
+		err := c.peers.Forward(forwardCtx, instances[id], notifications)
+		cancel()
+		if err != nil {
+			forwardedNotifications.WithLabelValues("failed").Add(float64(len(notifications)))
+			c.logger.Errorf("can't forward notifications to instance %s: %v", id, err)
+			continue
+		}
+		forwardedNotifications.WithLabelValues("ok").Add(float64(len(notifications)))
+	}
+}
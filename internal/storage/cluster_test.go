@@ -0,0 +1,161 @@
+package storage
+
+import (
+	"context"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// LocalPeers forwards notifications to stores running in the same process.
+// Updates are encoded the way they are sent between instances.
+type LocalPeers map[string]*NotificationStore
+
+func (p LocalPeers) Forward(_ context.Context, inst models.Instance, notifications []models.Notification) error {
+	for _, n := range notifications {
+		payload, err := MarshalUpdate(n.Update)
+		if err != nil {
+			return err
+		}
+		n.Update, err = UnmarshalUpdate(models.KindOf(n.Update), payload)
+		if err != nil {
+			return err
+		}
+		p[inst.ID].Notify(n)
+	}
+	return nil
+}
+
+func (p LocalPeers) Kick(_ context.Context, inst models.Instance, userID string, deviceID string, reason error) (bool, error) {
+	return p[inst.ID].Disconnect(userID, deviceID, reason), nil
+}
+
+func TestCluster_RoutesToListenersOnOtherInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	presence := NewMemoryPresence()
+	peers := make(LocalPeers)
+	cons := NewFakeConsumer()
+
+	stores := make(map[string]*NotificationStore)
+	for _, id := range []string{"a", "b", "c"} {
+		var consumers []Consumer
+		// Only the first instance gets updates, as if it owned the partition of the topic
+		if id == "a" {
+			consumers = append(consumers, cons)
+		}
+		store := NewNotificationStorage(logrus.New(), consumers...)
+		cluster := NewCluster(models.Instance{ID: id, Address: id}, presence, peers, logrus.New())
+		store.WithCluster(cluster)
+		stores[id], peers[id] = store, store
+		go cluster.Run(ctx)
+	}
+
+	onA := stores["a"].Listen("burenotti")
+	onB := stores["b"].Listen("burenotti")
+	onC := stores["c"].Listen("alice")
+	filtered := stores["b"].ListenWith("carol", ListenOptions{Filter: ListenFilter{ChatIDs: []string{"other"}}})
+	defer filtered.Detach()
+	assert.Eventually(t, func() bool {
+		located, _ := presence.Locate(ctx, []string{"burenotti", "alice", "carol"})
+		return len(located["burenotti"]) == 2 && len(located["alice"]) == 1 && len(located["carol"]) == 1
+	}, time.Second, 10*time.Millisecond, "listeners must be registered in presence")
+	assert.True(t, stores["a"].Listening(models.Notification{UserID: "alice", Update: &models.ChatDeleted{}}),
+		"users listening on other instances must be online")
+
+	toCarol := models.Notification{UserID: "carol", Update: &models.ChatDeleted{ChatID: "chat"}}
+	assert.False(t, stores["b"].Listening(toCarol), "listeners filtering a notification out must not count")
+	assert.True(t, stores["b"].Listening(models.Notification{UserID: "carol", Update: &models.ChatDeleted{ChatID: "other"}}))
+	assert.False(t, stores["a"].Listening(toCarol), "filtered listeners on other instances must not count")
+
+	meta := models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"burenotti", "alice", "bob"}}
+	cons.Fit(&models.ChatDeleted{UpdateMeta: meta, ChatID: "chat"})
+	go stores["a"].Run(ctx)
+
+	for name, l := range map[string]NotificationListener{"local": onA, "forwarded": onB, "other user": onC} {
+		n := ReadWithTimeout(t, l.Notifications(), time.Second, name+" listener must get the notification")
+		if n != nil {
+			assert.Equal(t, l.UserID, n.UserID)
+		}
+	}
+	select {
+	case n := <-onA.Notifications():
+		assert.Failf(t, "notification must be delivered once", "got %+v", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	onB.Detach()
+	onC.Detach()
+	assert.Eventually(t, func() bool {
+		located, _ := presence.Locate(ctx, []string{"burenotti", "alice"})
+		return len(located["burenotti"]) == 1 && len(located["alice"]) == 0
+	}, time.Second, 10*time.Millisecond, "detached listeners must leave presence")
+	assert.False(t, stores["a"].Listening(models.Notification{UserID: "alice", Update: &models.ChatDeleted{}}))
+}
+
+func TestCluster_BroadcastsToOtherInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	presence := NewMemoryPresence()
+	peers := make(LocalPeers)
+	stores := make(map[string]*NotificationStore)
+	for _, id := range []string{"a", "b"} {
+		store := NewNotificationStorage(logrus.New())
+		cluster := NewCluster(models.Instance{ID: id, Address: id}, presence, peers, logrus.New())
+		store.WithCluster(cluster)
+		stores[id], peers[id] = store, store
+		go cluster.Run(ctx)
+	}
+
+	onA := stores["a"].Listen("burenotti")
+	onB := stores["b"].Listen("burenotti")
+	assert.Eventually(t, func() bool {
+		located, _ := presence.Locate(ctx, []string{"burenotti"})
+		return len(located["burenotti"]) == 2
+	}, time.Second, 10*time.Millisecond, "listeners must be registered in presence")
+
+	counts := models.UnreadCounts{Total: 2, Chats: map[string]int64{"chat": 2}}
+	stores["a"].Broadcast(ctx, models.Notification{
+		UserID: "burenotti",
+		Update: &models.UnreadChanged{
+			UpdateMeta: models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"burenotti"}},
+			Counts:     counts,
+		},
+	})
+	for name, l := range map[string]NotificationListener{"local": onA, "other instance": onB} {
+		n := ReadWithTimeout(t, l.Notifications(), time.Second, name+" listener must get unread counts")
+		if n != nil {
+			assert.Equal(t, counts, n.Update.(*models.UnreadChanged).Counts)
+		}
+	}
+}
+
+func TestMemoryPresence_Announce(t *testing.T) {
+	ctx := context.Background()
+	presence := NewMemoryPresence()
+	a, b := models.Instance{ID: "a"}, models.Instance{ID: "b"}
+
+	fresh, err := presence.Announce(ctx, a, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, fresh)
+	_, err = presence.Announce(ctx, b, time.Millisecond)
+	assert.NoError(t, err)
+	assert.NoError(t, presence.Join(ctx, "burenotti", "a", []models.ConnectedDevice{{ID: "phone"}}))
+	assert.NoError(t, presence.Join(ctx, "burenotti", "b", []models.ConnectedDevice{{ID: "phone"}}))
+
+	time.Sleep(5 * time.Millisecond)
+	located, err := presence.Locate(ctx, []string{"burenotti"})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.Instance{a}, located["burenotti"], "expired instances must be skipped")
+
+	fresh, err = presence.Announce(ctx, a, time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, fresh)
+	fresh, err = presence.Announce(ctx, b, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, fresh, "expired instance must be registered anew")
+	located, _ = presence.Locate(ctx, []string{"burenotti"})
+	assert.Equal(t, []models.Instance{a}, located["burenotti"], "users of expired instance must be gone")
+}
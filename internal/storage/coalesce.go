@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"context"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"sync"
+	"time"
+)
+
+type burstKey struct {
+	userID string
+	chatID string
+}
+
+type burst struct {
+	latest models.Notification
+	count  int
+}
+
+// Coalescer is a Sink merging bursts of messages of a chat into a single notification
+// for the next sink. Messages are held for window since the first one of a burst, then
+// the latest of them is passed on with Coalesced set to the number of merged messages.
+// Other updates and messages concerning the user in particular are passed on at once.
+type Coalescer struct {
+	next   Sink
+	window time.Duration
+	mu     sync.Mutex
+	bursts map[burstKey]*burst
+}
+
+func NewCoalescer(next Sink, window time.Duration) *Coalescer {
+	return &Coalescer{
+		next:   next,
+		window: window,
+		bursts: make(map[burstKey]*burst),
+	}
+}
+
+func (c *Coalescer) Deliver(ctx context.Context, n models.Notification) {
+	msg, ok := n.Update.(*models.MessageSent)
+	if !ok || n.Reason != models.ReasonNone {
+		c.next.Deliver(ctx, n)
+		return
+	}
+
+	key := burstKey{userID: n.UserID, chatID: msg.ChatID}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b, ok := c.bursts[key]; ok {
+		b.latest = n
+		b.count++
+		return
+	}
+	c.bursts[key] = &burst{latest: n, count: 1}
+	time.AfterFunc(c.window, func() {
+		c.flush(key)
+	})
+}
+
+func (c *Coalescer) flush(key burstKey) {
+	c.mu.Lock()
+	b := c.bursts[key]
+	delete(c.bursts, key)
+	c.mu.Unlock()
+
+	n := b.latest
+	if b.count > 1 {
+		n.Coalesced = b.count
+	}
+	// The context of fan-out is gone by now and sinks must not block anyway
+	c.next.Deliver(context.Background(), n)
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type FakeSink struct {
+	delivered chan models.Notification
+}
+
+func (s *FakeSink) Deliver(_ context.Context, n models.Notification) {
+	s.delivered <- n
+}
+
+func TestCoalescer_Deliver(t *testing.T) {
+	ctx := context.Background()
+	sink := &FakeSink{delivered: make(chan models.Notification, 16)}
+	c := NewCoalescer(sink, 50*time.Millisecond)
+	busyChat, quietChat := uuid.New().String(), uuid.New().String()
+	message := func(seq int64, chatId string, text string) models.Notification {
+		return models.Notification{UserID: "burenotti", Seq: seq, Update: &models.MessageSent{ChatID: chatId, Text: text}}
+	}
+
+	c.Deliver(ctx, message(1, busyChat, "first"))
+	c.Deliver(ctx, message(2, quietChat, "hello"))
+	c.Deliver(ctx, message(3, busyChat, "second"))
+	c.Deliver(ctx, models.Notification{UserID: "burenotti", Seq: 4, Update: &models.ChatDeleted{ChatID: busyChat}})
+	c.Deliver(ctx, message(5, busyChat, "third"))
+
+	n := ReadWithTimeout(t, sink.delivered, time.Second, "other updates must be passed at once")
+	if n != nil {
+		assert.Equal(t, int64(4), n.Seq)
+	}
+
+	got := make(map[int64]models.Notification)
+	for i := 0; i < 2; i++ {
+		if n := ReadWithTimeout(t, sink.delivered, time.Second, "bursts must be flushed after window"); n != nil {
+			got[n.Seq] = *n
+		}
+	}
+	assert.Equal(t, 3, got[5].Coalesced, "burst must be merged into the latest message")
+	assert.Equal(t, "third", got[5].Update.(*models.MessageSent).Text)
+	assert.Equal(t, 0, got[2].Coalesced, "single message must be passed as is")
+	assert.Equal(t, "3 new messages", PushMessageOf(got[5]).Title)
+
+	c.Deliver(ctx, message(6, busyChat, "after burst"))
+	n = ReadWithTimeout(t, sink.delivered, time.Second, "new burst must start after flush")
+	if n != nil {
+		assert.Equal(t, int64(6), n.Seq)
+		assert.Equal(t, 0, n.Coalesced)
+	}
+}
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+)
+
+var (
+	ErrDeviceReplaced = errors.New("device listens on another connection")
+	ErrDeviceKicked   = errors.New("device was disconnected by the user")
+)
+
+// Devices returns devices userID listens from on this and other instances.
+// Listeners which didn't tell their device aren't listed.
+func (s *NotificationStore) Devices(ctx context.Context, userID string) ([]models.ConnectedDevice, error) {
+	sh := s.shardOf(userID)
+	sh.mu.RLock()
+	devices := devicesOf(sh.listeners.Get(userID))
+	sh.mu.RUnlock()
+	if s.cluster != nil {
+		remote, err := s.cluster.Devices(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, remote...)
+	}
+
+	known := devices[:0]
+	for _, device := range devices {
+		if device.ID != "" {
+			known = append(known, device)
+		}
+	}
+	return known, nil
+}
+
+// Kick detaches the listener of deviceID of userID with ErrDeviceKicked wherever it listens.
+// It returns false if the device isn't connected.
+func (s *NotificationStore) Kick(ctx context.Context, userID string, deviceID string) (bool, error) {
+	if s.Disconnect(userID, deviceID, ErrDeviceKicked) {
+		return true, nil
+	}
+	if s.cluster == nil {
+		return false, nil
+	}
+	return s.cluster.Kick(ctx, userID, deviceID, ErrDeviceKicked)
+}
+
+// ReplaceRemote detaches the listener of deviceID of userID on other instances with ErrDeviceReplaced.
+// Listen replaces the previous listener of a device on this instance only,
+// while the device may have listened on another one before it reconnected.
+func (s *NotificationStore) ReplaceRemote(ctx context.Context, userID string, deviceID string) error {
+	if s.cluster == nil || deviceID == "" {
+		return nil
+	}
+	_, err := s.cluster.Kick(ctx, userID, deviceID, ErrDeviceReplaced)
+	return err
+}
+
+// Disconnect is like Kick but looks for the device on this instance only.
+// The listener is detached with reason, either ErrDeviceKicked or ErrDeviceReplaced.
+func (s *NotificationStore) Disconnect(userID string, deviceID string, reason error) bool {
+	if deviceID == "" {
+		return false
+	}
+	sh := s.shardOf(userID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sub := deviceListener(sh.listeners.Get(userID), deviceID)
+	if sub == nil {
+		return false
+	}
+	s.logger.Infof("Disconnecting device %s of %s", deviceID, userID)
+	s.detachLocked(sh, userID, sub, reason)
+	return true
+}
+
+func deviceListener(subs []*subscription, deviceID string) *subscription {
+	for _, sub := range subs {
+		if sub.device.ID == deviceID {
+			return sub
+		}
+	}
+	return nil
+}
+
+// devicesOf returns devices of subs including unnamed ones, so presence counts every listener
+func devicesOf(subs []*subscription) []models.ConnectedDevice {
+	devices := make([]models.ConnectedDevice, 0, len(subs))
+	for _, sub := range subs {
+		devices = append(devices, sub.device)
+	}
+	return devices
+}
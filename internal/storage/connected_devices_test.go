@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNotificationStore_Devices(t *testing.T) {
+	ctx := context.Background()
+	store := NewNotificationStorage(logrus.New())
+
+	phone := store.ListenWith("burenotti", ListenOptions{DeviceID: "phone", Client: models.ClientMobile})
+	anonymous := store.Listen("burenotti")
+	defer anonymous.Detach()
+	reconnected := store.ListenWith("burenotti", ListenOptions{DeviceID: "phone", Client: models.ClientMobile})
+	defer reconnected.Detach()
+
+	_, open := <-phone.Notifications()
+	assert.False(t, open, "reconnecting device must replace its previous listener")
+	assert.ErrorIs(t, phone.Err(), ErrDeviceReplaced)
+
+	devices, err := store.Devices(ctx, "burenotti")
+	assert.NoError(t, err)
+	if assert.Len(t, devices, 1, "listeners without device must not be listed") {
+		assert.Equal(t, "phone", devices[0].ID)
+		assert.Equal(t, models.ClientMobile, devices[0].Client)
+	}
+
+	kicked, err := store.Kick(ctx, "burenotti", "laptop")
+	assert.NoError(t, err)
+	assert.False(t, kicked)
+	kicked, err = store.Kick(ctx, "burenotti", "phone")
+	assert.NoError(t, err)
+	assert.True(t, kicked)
+	_, open = <-reconnected.Notifications()
+	assert.False(t, open)
+	assert.ErrorIs(t, reconnected.Err(), ErrDeviceKicked)
+}
+
+func TestCluster_KicksDevicesOnOtherInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	presence := NewMemoryPresence()
+	peers := make(LocalPeers)
+	stores := make(map[string]*NotificationStore)
+	for _, id := range []string{"a", "b"} {
+		store := NewNotificationStorage(logrus.New())
+		cluster := NewCluster(models.Instance{ID: id, Address: id}, presence, peers, logrus.New())
+		store.WithCluster(cluster)
+		stores[id], peers[id] = store, store
+		go cluster.Run(ctx)
+	}
+
+	onB := stores["b"].ListenWith("burenotti", ListenOptions{DeviceID: "laptop", Client: models.ClientDesktop})
+	assert.Eventually(t, func() bool {
+		devices, _ := stores["a"].Devices(ctx, "burenotti")
+		return len(devices) == 1 && devices[0].ID == "laptop"
+	}, time.Second, 10*time.Millisecond, "devices on other instances must be listed")
+
+	kicked, err := stores["a"].Kick(ctx, "burenotti", "laptop")
+	assert.NoError(t, err)
+	assert.True(t, kicked)
+	_, open := <-onB.Notifications()
+	assert.False(t, open)
+	assert.ErrorIs(t, onB.Err(), ErrDeviceKicked)
+
+	onB = stores["b"].ListenWith("burenotti", ListenOptions{DeviceID: "laptop", Client: models.ClientDesktop})
+	assert.Eventually(t, func() bool {
+		devices, _ := stores["a"].Devices(ctx, "burenotti")
+		return len(devices) == 1
+	}, time.Second, 10*time.Millisecond, "devices on other instances must be listed")
+	onA := stores["a"].ListenWith("burenotti", ListenOptions{DeviceID: "laptop", Client: models.ClientDesktop})
+	defer onA.Detach()
+	assert.NoError(t, stores["a"].ReplaceRemote(ctx, "burenotti", "laptop"))
+	_, open = <-onB.Notifications()
+	assert.False(t, open, "device reconnecting to another instance must replace its previous listener")
+	assert.ErrorIs(t, onB.Err(), ErrDeviceReplaced)
+	select {
+	case _, open := <-onA.Notifications():
+		assert.True(t, open, "the new listener must be kept")
+	default:
+	}
+}
+
+func TestNotificationStore_DevicesChanged(t *testing.T) {
+	store := NewNotificationStorage(logrus.New())
+	phone := store.ListenWith("burenotti", ListenOptions{DeviceID: "phone", Client: models.ClientMobile})
+	defer phone.Detach()
+
+	other := store.Listen("alice")
+	defer other.Detach()
+	select {
+	case <-phone.DevicesChanged():
+		assert.Fail(t, "devices of other users must not be signalled")
+	default:
+	}
+
+	laptop := store.ListenWith("burenotti", ListenOptions{DeviceID: "laptop", Client: models.ClientDesktop})
+	ReadWithTimeout(t, phone.DevicesChanged(), time.Second, "connected device must be signalled")
+	laptop.Detach()
+	ReadWithTimeout(t, phone.DevicesChanged(), time.Second, "disconnected device must be signalled")
+}
@@ -17,19 +17,28 @@ var (
 )
 
 type UpdatesConsumer struct {
-	consumer sarama.Consumer
-	topic    string
-	logger   *logrus.Logger
+	consumer    sarama.Consumer
+	topic       string
+	logger      *logrus.Logger
+	validator   *UpdateValidator
+	deadLetters DeadLetters
 }
 
 func NewUpdatesConsumer(c sarama.Consumer, topic string, l *logrus.Logger) *UpdatesConsumer {
 	return &UpdatesConsumer{
-		consumer: c,
-		topic:    topic,
-		logger:   l,
+		consumer:  c,
+		topic:     topic,
+		logger:    l,
+		validator: NewUpdateValidator(),
 	}
 }
 
+// WithDeadLetters makes consumer send messages which can't be parsed to d.
+func (c *UpdatesConsumer) WithDeadLetters(d DeadLetters) *UpdatesConsumer {
+	c.deadLetters = d
+	return c
+}
+
 func (c *UpdatesConsumer) Run(ctx context.Context, updates chan<- models.Update) error {
 	c.logger.Infof("Running consumer for topic %s", c.topic)
 	ctx, cancel := context.WithCancel(ctx)
@@ -59,16 +68,19 @@ func (c *UpdatesConsumer) Run(ctx context.Context, updates chan<- models.Update)
 				case _ = <-ctx.Done():
 					c.logger.Infof("Closing consumer for partition")
 					cons.AsyncClose()
-					break
+					return
 				case msg, ok := <-cons.Messages():
 
 					if !ok {
-						break
+						return
 					}
-					upd, err := parseUpdate(msg)
 					c.logger.Infof("Consumed message with key %s", msg.Key)
+					upd, err := decodeUpdate(msg, c.validator)
 					if err != nil {
-						c.logger.Errorf("error occurred while parsing message %v:", err)
+						if err := rejectMessage(c.deadLetters, c.logger, msg, err); err != nil {
+							c.logger.Errorf("can't send message to dead letters: %v", err)
+						}
+						continue
 					}
 					updates <- upd
 				}
@@ -78,25 +90,55 @@ func (c *UpdatesConsumer) Run(ctx context.Context, updates chan<- models.Update)
 	return nil
 }
 
+// decodeUpdate parses msg and validates the resulting update
+func decodeUpdate(msg *sarama.ConsumerMessage, v *UpdateValidator) (models.Update, error) {
+	upd, err := parseUpdate(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.Validate(upd); err != nil {
+		return nil, err
+	}
+	return upd, nil
+}
+
 func parseUpdate(msg *sarama.ConsumerMessage) (models.Update, error) {
 	u := &updates.Update{}
 	err := proto.Unmarshal(msg.Value, u)
 
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %v", ErrParseMessage, err)
 	}
 
+	// Mappers read meta and the body without checks, so messages lacking them are rejected here
 	meta := u.Meta
-	switch u.Update.(type) {
+	if meta == nil {
+		return nil, fmt.Errorf("%w: missing meta", ErrParseMessage)
+	}
+	switch body := u.Update.(type) {
+	case nil:
 	case *updates.Update_Message:
-		upd := u.Update.(*updates.Update_Message).Message
-		return MessageSentUpdateToDomain(meta, upd), nil
+		if body.Message != nil {
+			return MessageSentUpdateToDomain(meta, body.Message), nil
+		}
 	case *updates.Update_CreatedChat:
-		upd := u.Update.(*updates.Update_CreatedChat).CreatedChat
-		ChatCreatedToDomain(meta, upd)
+		if body.CreatedChat != nil {
+			return ChatCreatedToDomain(meta, body.CreatedChat), nil
+		}
 	case *updates.Update_DeletedChat:
+		if body.DeletedChat != nil {
+			return ChatDeletedToDomain(meta, body.DeletedChat), nil
+		}
 	case *updates.Update_MemberAdded:
+		if body.MemberAdded != nil {
+			return MemberAddedToDomain(meta, body.MemberAdded), nil
+		}
 	case *updates.Update_MemberRemoved:
+		if body.MemberRemoved != nil {
+			return MemberRemovedToDomain(meta, body.MemberRemoved), nil
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported body type", ErrParseMessage)
 	}
-	return nil, fmt.Errorf("%v: unsupported body type", ErrParseMessage)
+	return nil, fmt.Errorf("%w: missing body", ErrParseMessage)
 }
@@ -72,3 +72,146 @@ func TestUpdatesConsumer_Run(t *testing.T) {
 	assert.True(t, ok, "should correctly consume from channel")
 	assert.Equal(t, expectedMsg, actualMsg)
 }
+
+func TestUpdatesConsumer_RunChatCreated(t *testing.T) {
+	topic := "chat.updates"
+	userId := uuid.New().String()
+	chatId := uuid.New().String()
+	expectedMsg := &models.ChatCreated{
+		UpdateMeta: models.UpdateMeta{
+			Timestamp: time.Now().UTC().Truncate(time.Second),
+			Audience:  []string{userId},
+		},
+		ChatID:   chatId,
+		IsDirect: false,
+		Members:  []string{userId, uuid.New().String()},
+	}
+
+	protoMsg := &updates.Update{
+		Meta: &updates.UpdateMeta{
+			Timestamp: expectedMsg.Timestamp.Unix(),
+			Audience:  expectedMsg.Audience,
+		},
+		Update: &updates.Update_CreatedChat{
+			CreatedChat: &updates.ChatCreated{
+				ChatId:   expectedMsg.ChatID,
+				IsDirect: expectedMsg.IsDirect,
+				Members:  expectedMsg.Members,
+			},
+		},
+	}
+	value, _ := proto.Marshal(protoMsg)
+
+	cfg := sarama.NewConfig()
+	c := mocks.NewConsumer(t, cfg)
+	c.SetTopicMetadata(map[string][]int32{
+		"chat.updates": {1},
+	})
+	p1 := c.ExpectConsumePartition(topic, 1, sarama.OffsetNewest)
+	key, _ := sarama.StringEncoder(chatId).Encode()
+	p1.YieldMessage(&sarama.ConsumerMessage{
+		Timestamp: time.Now().UTC(),
+		Key:       key,
+		Value:     value,
+		Topic:     topic,
+	})
+	consumer := NewUpdatesConsumer(c, topic, logrus.New())
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	result := make(chan models.Update)
+	defer cancel()
+	go consumer.Run(ctx, result)
+	actualMsg, ok := <-result
+	assert.True(t, ok, "should correctly consume from channel")
+	assert.Equal(t, expectedMsg, actualMsg)
+}
+
+func TestParseUpdate(t *testing.T) {
+	chatId := uuid.New().String()
+	userId := uuid.New().String()
+	timestamp := time.Now().UTC().Truncate(time.Second)
+	meta := &updates.UpdateMeta{
+		Timestamp: timestamp.Unix(),
+		Audience:  []string{userId},
+	}
+	expectedMeta := models.UpdateMeta{
+		Timestamp: timestamp,
+		Audience:  []string{userId},
+	}
+
+	cases := []struct {
+		name     string
+		update   *updates.Update
+		expected models.Update
+	}{
+		{
+			name: "chat created",
+			update: &updates.Update{
+				Meta: meta,
+				Update: &updates.Update_CreatedChat{CreatedChat: &updates.ChatCreated{
+					ChatId:   chatId,
+					IsDirect: true,
+					Members:  []string{userId},
+				}},
+			},
+			expected: &models.ChatCreated{UpdateMeta: expectedMeta, ChatID: chatId, IsDirect: true, Members: []string{userId}},
+		},
+		{
+			name: "chat deleted",
+			update: &updates.Update{
+				Meta:   meta,
+				Update: &updates.Update_DeletedChat{DeletedChat: &updates.ChatDeleted{ChatId: chatId}},
+			},
+			expected: &models.ChatDeleted{UpdateMeta: expectedMeta, ChatID: chatId},
+		},
+		{
+			name: "member added",
+			update: &updates.Update{
+				Meta:   meta,
+				Update: &updates.Update_MemberAdded{MemberAdded: &updates.MemberAdded{ChatId: chatId, UserId: userId}},
+			},
+			expected: &models.MemberAdded{UpdateMeta: expectedMeta, ChatID: chatId, UserID: userId},
+		},
+		{
+			name: "member removed",
+			update: &updates.Update{
+				Meta:   meta,
+				Update: &updates.Update_MemberRemoved{MemberRemoved: &updates.MemberRemoved{ChatId: chatId, UserId: userId}},
+			},
+			expected: &models.MemberRemoved{UpdateMeta: expectedMeta, ChatID: chatId, UserID: userId},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			value, _ := proto.Marshal(c.update)
+			actual, err := parseUpdate(&sarama.ConsumerMessage{Value: value})
+			assert.NoError(t, err)
+			assert.Equal(t, c.expected, actual)
+		})
+	}
+
+	malformed := map[string]*updates.Update{
+		"no meta": {Update: &updates.Update_DeletedChat{DeletedChat: &updates.ChatDeleted{ChatId: chatId}}},
+		"no body": {Meta: meta},
+	}
+	for name, update := range malformed {
+		t.Run(name, func(t *testing.T) {
+			value, _ := proto.Marshal(update)
+			_, err := parseUpdate(&sarama.ConsumerMessage{Value: value})
+			assert.ErrorIs(t, err, ErrParseMessage)
+		})
+	}
+}
+
+func TestDecodeUpdate_ZeroTimestamp(t *testing.T) {
+	value, _ := proto.Marshal(&updates.Update{
+		Meta:   &updates.UpdateMeta{Timestamp: 0, Audience: []string{uuid.New().String()}},
+		Update: &updates.Update_DeletedChat{DeletedChat: &updates.ChatDeleted{ChatId: uuid.New().String()}},
+	})
+	_, err := decodeUpdate(&sarama.ConsumerMessage{Value: value}, NewUpdateValidator())
+	assert.ErrorIs(t, err, ErrInvalidUpdate, "updates without a timestamp must not pass as 1970")
+	var verr *ValidationError
+	if assert.ErrorAs(t, err, &verr) {
+		assert.Equal(t, "ChatDeleted.UpdateMeta.Timestamp", verr.Fields[0].Field)
+	}
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"github.com/Shopify/sarama"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"time"
+)
+
+// Headers added to a dead letter alongside the headers of the original message
+const (
+	DeadLetterErrorHeader     = "dead-letter-error"
+	DeadLetterTopicHeader     = "dead-letter-original-topic"
+	DeadLetterPartitionHeader = "dead-letter-original-partition"
+	DeadLetterOffsetHeader    = "dead-letter-original-offset"
+	DeadLetterFailedAtHeader  = "dead-letter-failed-at"
+)
+
+// DeadLetters accepts messages which can't be turned into updates.
+type DeadLetters interface {
+	Send(msg *sarama.ConsumerMessage, reason error) error
+}
+
+// KafkaDeadLetters publishes rejected messages to a dedicated kafka topic
+// keeping original key and value.
+type KafkaDeadLetters struct {
+	producer sarama.SyncProducer
+	topic    string
+}
+
+func NewKafkaDeadLetters(p sarama.SyncProducer, topic string) *KafkaDeadLetters {
+	return &KafkaDeadLetters{
+		producer: p,
+		topic:    topic,
+	}
+}
+
+func (d *KafkaDeadLetters) Send(msg *sarama.ConsumerMessage, reason error) error {
+	headers := make([]sarama.RecordHeader, 0, len(msg.Headers)+5)
+	for _, h := range msg.Headers {
+		if h != nil {
+			headers = append(headers, *h)
+		}
+	}
+	headers = append(headers,
+		sarama.RecordHeader{Key: []byte(DeadLetterErrorHeader), Value: []byte(reason.Error())},
+		sarama.RecordHeader{Key: []byte(DeadLetterTopicHeader), Value: []byte(msg.Topic)},
+		sarama.RecordHeader{Key: []byte(DeadLetterPartitionHeader), Value: []byte(strconv.Itoa(int(msg.Partition)))},
+		sarama.RecordHeader{Key: []byte(DeadLetterOffsetHeader), Value: []byte(strconv.FormatInt(msg.Offset, 10))},
+		sarama.RecordHeader{Key: []byte(DeadLetterFailedAtHeader), Value: []byte(time.Now().UTC().Format(time.RFC3339))},
+	)
+
+	_, _, err := d.producer.SendMessage(&sarama.ProducerMessage{
+		Topic:   d.topic,
+		Key:     sarama.ByteEncoder(msg.Key),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	})
+	return err
+}
+
+// rejectMessage sends msg to dead letters if they are configured
+func rejectMessage(d DeadLetters, l *logrus.Logger, msg *sarama.ConsumerMessage, reason error) error {
+	l.
+		WithField("topic", msg.Topic).
+		WithField("partition", msg.Partition).
+		WithField("offset", msg.Offset).
+		Errorf("rejecting message: %v", reason)
+	if d == nil {
+		return nil
+	}
+	return d.Send(msg, reason)
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type FakeDeadLetters struct {
+	mu       sync.Mutex
+	messages []*sarama.ConsumerMessage
+	reasons  []error
+	// failures is the number of sends failing before they succeed
+	failures int
+}
+
+func (d *FakeDeadLetters) Send(msg *sarama.ConsumerMessage, reason error) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.failures > 0 {
+		d.failures--
+		return errors.New("dead letters are unavailable")
+	}
+	d.messages = append(d.messages, msg)
+	d.reasons = append(d.reasons, reason)
+	return nil
+}
+
+func (d *FakeDeadLetters) Reasons() []error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]error(nil), d.reasons...)
+}
+
+func TestKafkaDeadLetters_Send(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, sarama.NewConfig())
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		assert.Equal(t, "chat.updates.dead", msg.Topic)
+		key, _ := msg.Key.Encode()
+		value, _ := msg.Value.Encode()
+		assert.Equal(t, []byte("key"), key)
+		assert.Equal(t, []byte("value"), value)
+
+		headers := make(map[string]string)
+		for _, h := range msg.Headers {
+			headers[string(h.Key)] = string(h.Value)
+		}
+		assert.Equal(t, "original", headers["trace-id"])
+		assert.Equal(t, "broken", headers[DeadLetterErrorHeader])
+		assert.Equal(t, "chat.updates", headers[DeadLetterTopicHeader])
+		assert.Equal(t, "3", headers[DeadLetterPartitionHeader])
+		assert.Equal(t, "42", headers[DeadLetterOffsetHeader])
+		assert.NotEmpty(t, headers[DeadLetterFailedAtHeader])
+		return nil
+	})
+	defer producer.Close()
+
+	d := NewKafkaDeadLetters(producer, "chat.updates.dead")
+	err := d.Send(&sarama.ConsumerMessage{
+		Headers:   []*sarama.RecordHeader{{Key: []byte("trace-id"), Value: []byte("original")}},
+		Key:       []byte("key"),
+		Value:     []byte("value"),
+		Topic:     "chat.updates",
+		Partition: 3,
+		Offset:    42,
+	}, errors.New("broken"))
+	assert.NoError(t, err)
+}
+
+func TestUpdatesConsumer_RejectsUnparseable(t *testing.T) {
+	topic := "chat.updates"
+	c := mocks.NewConsumer(t, sarama.NewConfig())
+	c.SetTopicMetadata(map[string][]int32{
+		topic: {1},
+	})
+	p1 := c.ExpectConsumePartition(topic, 1, sarama.OffsetNewest)
+	p1.YieldMessage(&sarama.ConsumerMessage{
+		Value: []byte("definitely not a protobuf"),
+		Topic: topic,
+	})
+
+	deadLetters := &FakeDeadLetters{}
+	consumer := NewUpdatesConsumer(c, topic, logrus.New()).WithDeadLetters(deadLetters)
+	ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	result := make(chan models.Update, 1)
+	go consumer.Run(ctx, result)
+
+	assert.Eventually(t, func() bool {
+		return len(deadLetters.Reasons()) == 1
+	}, 1*time.Second, 10*time.Millisecond, "message must be sent to dead letters")
+	assert.ErrorIs(t, deadLetters.Reasons()[0], ErrParseMessage)
+	assert.Empty(t, result, "rejected message must not reach the store")
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// delayQueue holds items until their time comes and hands them out in time order
+type delayQueue[T any] struct {
+	mu    sync.Mutex
+	items delayHeap[T]
+	limit int
+	wake  chan struct{}
+}
+
+func newDelayQueue[T any](limit int) *delayQueue[T] {
+	return &delayQueue[T]{
+		limit: limit,
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// push schedules item to be handed out at at. It reports false if the queue is full.
+func (q *delayQueue[T]) push(item T, at time.Time) bool {
+	q.mu.Lock()
+	if len(q.items) >= q.limit {
+		q.mu.Unlock()
+		return false
+	}
+	heap.Push(&q.items, delayed[T]{at: at, item: item})
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// run sends items to out once they are due until ctx is done
+func (q *delayQueue[T]) run(ctx context.Context, out chan<- T) {
+	for {
+		item, wait, due := q.next()
+		if due {
+			select {
+			case <-ctx.Done():
+				return
+			case out <- item:
+			}
+			continue
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-timeout:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// next pops the earliest item if it is due. Otherwise, it returns the time left
+// until the earliest item is, or zero if the queue is empty.
+func (q *delayQueue[T]) next() (T, time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var zero T
+	if len(q.items) == 0 {
+		return zero, 0, false
+	}
+	if wait := time.Until(q.items[0].at); wait > 0 {
+		return zero, wait, false
+	}
+	return heap.Pop(&q.items).(delayed[T]).item, 0, true
+}
+
+type delayed[T any] struct {
+	at   time.Time
+	item T
+}
+
+type delayHeap[T any] []delayed[T]
+
+func (h delayHeap[T]) Len() int           { return len(h) }
+func (h delayHeap[T]) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h delayHeap[T]) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *delayHeap[T]) Push(x any) {
+	*h = append(*h, x.(delayed[T]))
+}
+
+func (h *delayHeap[T]) Pop() any {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
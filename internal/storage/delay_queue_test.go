@@ -0,0 +1,28 @@
+package storage
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestDelayQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	q := newDelayQueue[int](2)
+	out := make(chan int)
+	go q.run(ctx, out)
+
+	start := time.Now()
+	assert.True(t, q.push(2, start.Add(40*time.Millisecond)))
+	assert.True(t, q.push(1, start.Add(20*time.Millisecond)))
+	assert.False(t, q.push(3, start), "full queue must reject items")
+
+	first := ReadWithTimeout(t, out, time.Second, "item must be handed out when due")
+	second := ReadWithTimeout(t, out, time.Second, "item must be handed out when due")
+	if first != nil && second != nil {
+		assert.Equal(t, []int{1, 2}, []int{*first, *second}, "items must be handed out in time order")
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond, "items must not be handed out early")
+}
@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"sync"
+)
+
+// DeviceStore keeps device tokens push notifications are sent to
+type DeviceStore interface {
+	// Register saves the device. A token registered by another user before
+	// is moved to the new one, because a phone has a single signed in user.
+	Register(ctx context.Context, d models.Device) error
+
+	// Unregister removes token of userID. Unknown tokens are ignored.
+	Unregister(ctx context.Context, userID string, token string) error
+
+	// Remove removes token no matter whose it is. Used for tokens rejected by push services.
+	Remove(ctx context.Context, token string) error
+
+	List(ctx context.Context, userID string) ([]models.Device, error)
+}
+
+type PostgresDevices struct {
+	db *sql.DB
+}
+
+func NewPostgresDevices(db *sql.DB) *PostgresDevices {
+	return &PostgresDevices{
+		db: db,
+	}
+}
+
+func (s *PostgresDevices) Register(ctx context.Context, d models.Device) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO devices (token, user_id, platform, updated_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (token) DO UPDATE
+		SET user_id = excluded.user_id, platform = excluded.platform, updated_at = excluded.updated_at`,
+		d.Token, d.UserID, string(d.Platform), d.UpdatedAt)
+	return err
+}
+
+func (s *PostgresDevices) Unregister(ctx context.Context, userID string, token string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM devices WHERE token = $1 AND user_id = $2`, token, userID)
+	return err
+}
+
+func (s *PostgresDevices) Remove(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM devices WHERE token = $1`, token)
+	return err
+}
+
+func (s *PostgresDevices) List(ctx context.Context, userID string) ([]models.Device, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT token, platform, updated_at
+		FROM devices
+		WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []models.Device
+	for rows.Next() {
+		d := models.Device{UserID: userID}
+		var platform string
+		if err := rows.Scan(&d.Token, &platform, &d.UpdatedAt); err != nil {
+			return nil, err
+		}
+		d.Platform = models.Platform(platform)
+		devices = append(devices, d)
+	}
+	return devices, rows.Err()
+}
+
+// MemoryDevices keeps devices in process memory. It is meant for tests.
+type MemoryDevices struct {
+	mu      sync.Mutex
+	devices map[string]models.Device
+}
+
+func NewMemoryDevices() *MemoryDevices {
+	return &MemoryDevices{
+		devices: make(map[string]models.Device),
+	}
+}
+
+func (s *MemoryDevices) Register(_ context.Context, d models.Device) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.devices[d.Token] = d
+	return nil
+}
+
+func (s *MemoryDevices) Unregister(_ context.Context, userID string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[token]; ok && d.UserID == userID {
+		delete(s.devices, token)
+	}
+	return nil
+}
+
+func (s *MemoryDevices) Remove(_ context.Context, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.devices, token)
+	return nil
+}
+
+func (s *MemoryDevices) List(_ context.Context, userID string) ([]models.Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var devices []models.Device
+	for _, d := range s.devices {
+		if d.UserID == userID {
+			devices = append(devices, d)
+		}
+	}
+	return devices, nil
+}
@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DigestStore keeps email digest subscriptions and tracks when their users were online
+type DigestStore interface {
+	// Subscribe sends digests of userID to email. Empty email unsubscribes.
+	Subscribe(ctx context.Context, userID string, email string) error
+
+	// Seen records that userID was connected at the moment at.
+	// Users without a subscription are ignored.
+	Seen(ctx context.Context, userID string, at time.Time) error
+
+	// Due returns at most limit subscriptions of users offline since before
+	// offlineSince who got no digest since digestSince. Subscriptions are ordered
+	// by last seen time and user and start after the position after.
+	Due(
+		ctx context.Context,
+		offlineSince time.Time,
+		digestSince time.Time,
+		after DigestCursor,
+		limit int,
+	) ([]models.DigestSubscription, error)
+
+	// Claim marks a digest of sub as being sent at the moment at, so sub isn't due until
+	// the next digest is. It fails to claim if the digest was claimed since sub was read,
+	// e.g. by another replica.
+	Claim(ctx context.Context, sub models.DigestSubscription, at time.Time) (bool, error)
+
+	// Sent records that a claimed digest of userID was sent with messages up to seq
+	Sent(ctx context.Context, userID string, seq int64) error
+
+	// Release undoes the claim of sub made at the moment at, so its digest is due again
+	Release(ctx context.Context, sub models.DigestSubscription, at time.Time) error
+}
+
+// DigestCursor is a position in subscriptions returned by DigestStore.Due.
+// The zero value points before the first one.
+type DigestCursor struct {
+	LastSeenAt time.Time
+	UserID     string
+}
+
+// DigestCursorOf returns the position right after sub
+func DigestCursorOf(sub models.DigestSubscription) DigestCursor {
+	return DigestCursor{LastSeenAt: sub.LastSeenAt, UserID: sub.UserID}
+}
+
+func (c DigestCursor) before(sub models.DigestSubscription) bool {
+	if !c.LastSeenAt.Equal(sub.LastSeenAt) {
+		return c.LastSeenAt.Before(sub.LastSeenAt)
+	}
+	return c.UserID < sub.UserID
+}
+
+type PostgresDigests struct {
+	db *sql.DB
+}
+
+func NewPostgresDigests(db *sql.DB) *PostgresDigests {
+	return &PostgresDigests{
+		db: db,
+	}
+}
+
+func (d *PostgresDigests) Subscribe(ctx context.Context, userID string, email string) error {
+	if email == "" {
+		_, err := d.db.ExecContext(ctx, `DELETE FROM email_digests WHERE user_id = $1`, userID)
+		return err
+	}
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO email_digests (user_id, email)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET email = excluded.email`, userID, email)
+	return err
+}
+
+func (d *PostgresDigests) Seen(ctx context.Context, userID string, at time.Time) error {
+	_, err := d.db.ExecContext(ctx, `
+		UPDATE email_digests
+		SET last_seen_at = greatest(last_seen_at, $2)
+		WHERE user_id = $1`, userID, at)
+	return err
+}
+
+func (d *PostgresDigests) Due(
+	ctx context.Context,
+	offlineSince time.Time,
+	digestSince time.Time,
+	after DigestCursor,
+	limit int,
+) ([]models.DigestSubscription, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT user_id, email, last_seen_at, last_digest_at, last_digest_seq
+		FROM email_digests
+		WHERE last_seen_at < $1
+		  AND (last_digest_at IS NULL OR last_digest_at < $2)
+		  AND (last_seen_at, user_id) > ($3, $4)
+		ORDER BY last_seen_at, user_id
+		LIMIT $5`, offlineSince, digestSince, after.LastSeenAt, after.UserID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []models.DigestSubscription
+	for rows.Next() {
+		var sub models.DigestSubscription
+		var lastDigestAt sql.NullTime
+		err := rows.Scan(&sub.UserID, &sub.Email, &sub.LastSeenAt, &lastDigestAt, &sub.LastDigestSeq)
+		if err != nil {
+			return nil, err
+		}
+		if lastDigestAt.Valid {
+			sub.LastDigestAt = &lastDigestAt.Time
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (d *PostgresDigests) Claim(ctx context.Context, sub models.DigestSubscription, at time.Time) (bool, error) {
+	res, err := d.db.ExecContext(ctx, `
+		UPDATE email_digests
+		SET last_digest_at = $2
+		WHERE user_id = $1 AND last_digest_at IS NOT DISTINCT FROM $3`,
+		sub.UserID, at, sub.LastDigestAt)
+	if err != nil {
+		return false, err
+	}
+	affected, err := res.RowsAffected()
+	return affected == 1, err
+}
+
+func (d *PostgresDigests) Sent(ctx context.Context, userID string, seq int64) error {
+	_, err := d.db.ExecContext(ctx, `
+		UPDATE email_digests
+		SET last_digest_seq = greatest(last_digest_seq, $2)
+		WHERE user_id = $1`, userID, seq)
+	return err
+}
+
+func (d *PostgresDigests) Release(ctx context.Context, sub models.DigestSubscription, at time.Time) error {
+	_, err := d.db.ExecContext(ctx, `
+		UPDATE email_digests
+		SET last_digest_at = $3
+		WHERE user_id = $1 AND last_digest_at = $2`,
+		sub.UserID, at, sub.LastDigestAt)
+	return err
+}
+
+// MemoryDigests keeps digest subscriptions in process memory. It is meant for tests.
+type MemoryDigests struct {
+	mu   sync.Mutex
+	subs map[string]models.DigestSubscription
+}
+
+func NewMemoryDigests() *MemoryDigests {
+	return &MemoryDigests{
+		subs: make(map[string]models.DigestSubscription),
+	}
+}
+
+func (d *MemoryDigests) Subscribe(_ context.Context, userID string, email string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if email == "" {
+		delete(d.subs, userID)
+		return nil
+	}
+	sub, ok := d.subs[userID]
+	if !ok {
+		sub = models.DigestSubscription{UserID: userID, LastSeenAt: time.Now()}
+	}
+	sub.Email = email
+	d.subs[userID] = sub
+	return nil
+}
+
+func (d *MemoryDigests) Seen(_ context.Context, userID string, at time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if sub, ok := d.subs[userID]; ok && at.After(sub.LastSeenAt) {
+		sub.LastSeenAt = at
+		d.subs[userID] = sub
+	}
+	return nil
+}
+
+func (d *MemoryDigests) Due(
+	_ context.Context,
+	offlineSince time.Time,
+	digestSince time.Time,
+	after DigestCursor,
+	limit int,
+) ([]models.DigestSubscription, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var subs []models.DigestSubscription
+	for _, sub := range d.subs {
+		if sub.LastSeenAt.Before(offlineSince) && (sub.LastDigestAt == nil || sub.LastDigestAt.Before(digestSince)) &&
+			after.before(sub) {
+			subs = append(subs, sub)
+		}
+	}
+	sort.Slice(subs, func(i, j int) bool { return DigestCursorOf(subs[i]).before(subs[j]) })
+	if len(subs) > limit {
+		subs = subs[:limit]
+	}
+	return subs, nil
+}
+
+func (d *MemoryDigests) Claim(_ context.Context, sub models.DigestSubscription, at time.Time) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stored, ok := d.subs[sub.UserID]
+	if !ok || !sameTime(stored.LastDigestAt, sub.LastDigestAt) {
+		return false, nil
+	}
+	stored.LastDigestAt = &at
+	d.subs[sub.UserID] = stored
+	return true, nil
+}
+
+func (d *MemoryDigests) Sent(_ context.Context, userID string, seq int64) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if stored, ok := d.subs[userID]; ok && seq > stored.LastDigestSeq {
+		stored.LastDigestSeq = seq
+		d.subs[userID] = stored
+	}
+	return nil
+}
+
+func (d *MemoryDigests) Release(_ context.Context, sub models.DigestSubscription, at time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if stored, ok := d.subs[sub.UserID]; ok && sameTime(stored.LastDigestAt, &at) {
+		stored.LastDigestAt = sub.LastDigestAt
+		d.subs[sub.UserID] = stored
+	}
+	return nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ChannelConsumer passes updates sent to it to the store
+type ChannelConsumer chan models.Update
+
+func (c ChannelConsumer) Run(ctx context.Context, upds chan<- models.Update) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case upd := <-c:
+			upds <- upd
+		}
+	}
+}
+
+// BenchmarkNotificationStore_FanOut measures how fast updates are fanned out to listeners
+// depending on the number of shards. A single shard still runs the sharded pipeline with
+// its worker and ordered acks, so it isn't the store as it was before sharding. This file
+// uses nothing the store lacked back then but sharding, so the baseline is measured by
+// running it against the tree before sharding, where it reports a single unsharded run:
+//
+//	git worktree add /tmp/unsharded 5fc8dcf^
+//	cp internal/storage/fanout_bench_test.go /tmp/unsharded/internal/storage/
+//	cd /tmp/unsharded && go test -run '^$' -bench FanOut -cpu 1 -count 3 ./internal/storage
+//
+// Latency is the time from handing an update to the store to its acknowledgement.
+func BenchmarkNotificationStore_FanOut(b *testing.B) {
+	if _, sharded := interface{}(&NotificationStore{}).(shardedStore); !sharded {
+		b.Run("unsharded", func(b *testing.B) {
+			benchmarkFanOut(b, 0)
+		})
+		return
+	}
+	for _, shards := range []int{1, 4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			benchmarkFanOut(b, shards)
+		})
+	}
+}
+
+// shardedStore is asserted rather than called, so the benchmark builds before sharding too
+type shardedStore interface {
+	WithShards(n int) *NotificationStore
+}
+
+func benchmarkFanOut(b *testing.B, shards int) {
+	const users, audienceSize = 10000, 200
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	updates := make(ChannelConsumer)
+	store := NewNotificationStorage(logger, updates)
+	if s, ok := interface{}(store).(shardedStore); ok {
+		s.WithShards(shards)
+	}
+	for i := 0; i < users; i++ {
+		l := store.Listen(strconv.Itoa(i))
+		defer l.Detach()
+		go func() {
+			for range l.Notifications() {
+			}
+		}()
+	}
+	go store.Run(ctx)
+
+	rnd := rand.New(rand.NewSource(1))
+	audiences := make([][]string, 64)
+	for i := range audiences {
+		for _, u := range rnd.Perm(users)[:audienceSize] {
+			audiences[i] = append(audiences[i], strconv.Itoa(u))
+		}
+	}
+
+	latencies := make([]time.Duration, b.N)
+	var wg sync.WaitGroup
+	wg.Add(b.N)
+	b.ResetTimer()
+	began := time.Now()
+	for i := 0; i < b.N; i++ {
+		i, start := i, time.Now()
+		updates <- &ackedUpdate{
+			Update: &models.ChatDeleted{
+				UpdateMeta: models.UpdateMeta{Timestamp: start, Audience: audiences[i%len(audiences)]},
+				ChatID:     "chat",
+			},
+			ack: func() {
+				latencies[i] = time.Since(start)
+				wg.Done()
+			},
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(began)
+	b.StopTimer()
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	b.ReportMetric(float64(b.N*audienceSize)/elapsed.Seconds(), "notifications/s")
+	b.ReportMetric(float64(latencies[len(latencies)/2].Microseconds()), "p50-µs")
+	b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds()), "p99-µs")
+}
@@ -0,0 +1,42 @@
+package storage
+
+import "github.com/practice-sem-2/notification-service/internal/models"
+
+// ListenFilter restricts which notifications reach a listener. Empty filter lets everything through.
+type ListenFilter struct {
+	// ChatIDs lets through only notifications of these chats if not empty
+	ChatIDs []string
+	// Kinds lets through only updates of these kinds if not empty
+	Kinds []models.UpdateKind
+	// MentionsOnly lets through only messages mentioning the user
+	MentionsOnly bool
+}
+
+// Empty reports whether the filter lets through every notification
+func (f ListenFilter) Empty() bool {
+	return len(f.ChatIDs) == 0 && len(f.Kinds) == 0 && !f.MentionsOnly
+}
+
+// Matches reports whether n passes the filter
+func (f ListenFilter) Matches(n models.Notification) bool {
+	if len(f.ChatIDs) > 0 && !contains(f.ChatIDs, models.ChatOf(n.Update)) {
+		return false
+	}
+	if len(f.Kinds) > 0 && !contains(f.Kinds, models.KindOf(n.Update)) {
+		return false
+	}
+	if f.MentionsOnly {
+		msg, ok := n.Update.(*models.MessageSent)
+		return ok && msg.Mentions(n.UserID)
+	}
+	return true
+}
+
+func contains[T comparable](items []T, item T) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
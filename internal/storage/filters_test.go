@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestListenFilter_Matches(t *testing.T) {
+	meta := models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"burenotti"}}
+	message := models.Notification{UserID: "burenotti", Update: &models.MessageSent{UpdateMeta: meta, ChatID: "chat", Text: "hi"}}
+	mention := models.Notification{UserID: "burenotti", Update: &models.MessageSent{UpdateMeta: meta, ChatID: "chat", Text: "hi @burenotti"}}
+	deleted := models.Notification{UserID: "burenotti", Update: &models.ChatDeleted{UpdateMeta: meta, ChatID: "other"}}
+
+	cases := []struct {
+		name   string
+		filter ListenFilter
+		n      models.Notification
+		want   bool
+	}{
+		{"empty filter", ListenFilter{}, deleted, true},
+		{"chat listed", ListenFilter{ChatIDs: []string{"chat"}}, message, true},
+		{"chat not listed", ListenFilter{ChatIDs: []string{"chat"}}, deleted, false},
+		{"kind listed", ListenFilter{Kinds: []models.UpdateKind{models.KindChatDeleted}}, deleted, true},
+		{"kind not listed", ListenFilter{Kinds: []models.UpdateKind{models.KindChatDeleted}}, message, false},
+		{"mention", ListenFilter{MentionsOnly: true}, mention, true},
+		{"message without mention", ListenFilter{MentionsOnly: true}, message, false},
+		{"not a message", ListenFilter{MentionsOnly: true}, deleted, false},
+		{"all conditions", ListenFilter{ChatIDs: []string{"other"}, MentionsOnly: true}, mention, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.want, c.filter.Matches(c.n))
+		})
+	}
+}
+
+func TestNotificationStore_FiltersBeforeBuffering(t *testing.T) {
+	store := NewNotificationStorage(logrus.New())
+	all := store.Listen("burenotti")
+	defer all.Detach()
+	widget := store.ListenWith("burenotti", ListenOptions{
+		Overflow: OverflowDisconnect,
+		Filter:   ListenFilter{ChatIDs: []string{"chat"}},
+	})
+	defer widget.Detach()
+
+	meta := models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"burenotti"}}
+	// More filtered out notifications than fit into the buffer must not disconnect the widget
+	for i := 0; i < readerBufferSize+1; i++ {
+		store.Notify(models.Notification{UserID: "burenotti", Update: &models.ChatDeleted{UpdateMeta: meta, ChatID: "other"}})
+	}
+	store.Notify(models.Notification{UserID: "burenotti", Update: &models.ChatDeleted{UpdateMeta: meta, ChatID: "chat"}})
+
+	n := ReadWithTimeout(t, widget.Notifications(), time.Second, "matching notification must be delivered")
+	if n != nil {
+		assert.Equal(t, "chat", models.ChatOf(n.Update))
+	}
+	assert.NoError(t, widget.Err())
+	assert.Len(t, all.Notifications(), readerBufferSize, "listeners without filter must get everything")
+}
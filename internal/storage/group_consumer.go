@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/Shopify/sarama"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+const (
+	rejectRetryDelay    = 100 * time.Millisecond
+	maxRejectRetryDelay = 10 * time.Second
+)
+
+// GroupUpdatesConsumer reads updates as a member of a kafka consumer group,
+// so partitions are shared between replicas and the position survives restarts.
+type GroupUpdatesConsumer struct {
+	group       sarama.ConsumerGroup
+	topics      []string
+	logger      *logrus.Logger
+	deadLetters DeadLetters
+}
+
+func NewGroupUpdatesConsumer(g sarama.ConsumerGroup, topics []string, l *logrus.Logger) *GroupUpdatesConsumer {
+	return &GroupUpdatesConsumer{
+		group:  g,
+		topics: topics,
+		logger: l,
+	}
+}
+
+// WithDeadLetters makes consumer send messages which can't be parsed to d.
+func (c *GroupUpdatesConsumer) WithDeadLetters(d DeadLetters) *GroupUpdatesConsumer {
+	c.deadLetters = d
+	return c
+}
+
+// Run joins the group and consumes claimed partitions until ctx is done.
+// A message offset is marked only after the store has fanned out its update.
+func (c *GroupUpdatesConsumer) Run(ctx context.Context, updates chan<- models.Update) error {
+	c.logger.Infof("Running consumer group for topics %v", c.topics)
+	defer func() {
+		if err := c.group.Close(); err != nil {
+			c.logger.Errorf("error occurred while closing consumer group: %v", err)
+		}
+	}()
+
+	go func() {
+		for err := range c.group.Errors() {
+			c.logger.Errorf("consumer group error: %v", err)
+		}
+	}()
+
+	handler := &updatesHandler{
+		updates:     updates,
+		logger:      c.logger,
+		validator:   NewUpdateValidator(),
+		deadLetters: c.deadLetters,
+		retryDelay:  rejectRetryDelay,
+	}
+
+	for {
+		// Consume returns on every rebalance, so the group has to be rejoined
+		err := c.group.Consume(ctx, c.topics, handler)
+		if errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		c.logger.Infof("Consumer group session ended. Rejoining")
+	}
+}
+
+type updatesHandler struct {
+	updates     chan<- models.Update
+	logger      *logrus.Logger
+	validator   *UpdateValidator
+	deadLetters DeadLetters
+	// retryDelay is the first delay before sending to dead letters is retried
+	retryDelay time.Duration
+}
+
+func (h *updatesHandler) Setup(session sarama.ConsumerGroupSession) error {
+	h.logger.
+		WithField("generation", session.GenerationID()).
+		Infof("Consumer group session started with claims %v", session.Claims())
+	return nil
+}
+
+func (h *updatesHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	h.logger.
+		WithField("generation", session.GenerationID()).
+		Infof("Consumer group session cleaned up")
+	return nil
+}
+
+func (h *updatesHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	h.logger.Infof("Consuming partition %d of %s", claim.Partition(), claim.Topic())
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			h.logger.Infof("Consumed message with key %s", msg.Key)
+			upd, err := decodeUpdate(msg, h.validator)
+			if err != nil {
+				if !h.reject(session.Context(), msg, err) {
+					return nil
+				}
+				// Such a message will never be accepted, so there is no point to consume it again
+				session.MarkMessage(msg, "")
+				continue
+			}
+			acked := &ackedUpdate{
+				Update: upd,
+				ack: func() {
+					session.MarkMessage(msg, "")
+				},
+			}
+			select {
+			case h.updates <- acked:
+			case <-session.Context().Done():
+				return nil
+			}
+		}
+	}
+}
+
+// reject sends msg to dead letters. Failed sends are retried with backoff, which holds back
+// the partition, until ctx is done. Then reject returns false and msg must not be marked,
+// so it is consumed again rather than lost.
+func (h *updatesHandler) reject(ctx context.Context, msg *sarama.ConsumerMessage, reason error) bool {
+	for delay := h.retryDelay; ; delay *= 2 {
+		if delay > maxRejectRetryDelay {
+			delay = maxRejectRetryDelay
+		}
+		err := rejectMessage(h.deadLetters, h.logger, msg, reason)
+		if err == nil {
+			return true
+		}
+		h.logger.Errorf("can't send message to dead letters, retrying in %s: %v", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
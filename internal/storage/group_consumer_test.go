@@ -0,0 +1,170 @@
+package storage
+
+import (
+	"context"
+	"github.com/Shopify/sarama"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/pb/chats/updates"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"sync"
+	"testing"
+	"time"
+)
+
+type FakeGroupSession struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	marked []int64
+}
+
+func (s *FakeGroupSession) Claims() map[string][]int32               { return nil }
+func (s *FakeGroupSession) MemberID() string                         { return "member" }
+func (s *FakeGroupSession) GenerationID() int32                      { return 1 }
+func (s *FakeGroupSession) MarkOffset(string, int32, int64, string)  {}
+func (s *FakeGroupSession) Commit()                                  {}
+func (s *FakeGroupSession) ResetOffset(string, int32, int64, string) {}
+func (s *FakeGroupSession) Context() context.Context                 { return s.ctx }
+func (s *FakeGroupSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, msg.Offset)
+}
+
+func (s *FakeGroupSession) Marked() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int64(nil), s.marked...)
+}
+
+type FakeGroupClaim struct {
+	topic    string
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *FakeGroupClaim) Topic() string                            { return c.topic }
+func (c *FakeGroupClaim) Partition() int32                         { return 0 }
+func (c *FakeGroupClaim) InitialOffset() int64                     { return 0 }
+func (c *FakeGroupClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *FakeGroupClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+// FakeConsumerGroup runs a single session over a single claim.
+type FakeConsumerGroup struct {
+	session *FakeGroupSession
+	claim   *FakeGroupClaim
+	errors  chan error
+}
+
+func (g *FakeConsumerGroup) Consume(ctx context.Context, _ []string, handler sarama.ConsumerGroupHandler) error {
+	g.session.ctx = ctx
+	if err := handler.Setup(g.session); err != nil {
+		return err
+	}
+	err := handler.ConsumeClaim(g.session, g.claim)
+	_ = handler.Cleanup(g.session)
+	return err
+}
+
+func (g *FakeConsumerGroup) Errors() <-chan error      { return g.errors }
+func (g *FakeConsumerGroup) Close() error              { close(g.errors); return nil }
+func (g *FakeConsumerGroup) Pause(map[string][]int32)  {}
+func (g *FakeConsumerGroup) Resume(map[string][]int32) {}
+func (g *FakeConsumerGroup) PauseAll()                 {}
+func (g *FakeConsumerGroup) ResumeAll()                {}
+
+func TestGroupUpdatesConsumer_MarksAfterFanOut(t *testing.T) {
+	topic := "chat.updates"
+	userId := uuid.New().String()
+	chatId := uuid.New().String()
+	value, _ := proto.Marshal(&updates.Update{
+		Meta: &updates.UpdateMeta{
+			Timestamp: time.Now().Unix(),
+			Audience:  []string{userId},
+		},
+		Update: &updates.Update_DeletedChat{DeletedChat: &updates.ChatDeleted{ChatId: chatId}},
+	})
+
+	claim := &FakeGroupClaim{topic: topic, messages: make(chan *sarama.ConsumerMessage, 2)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: topic, Offset: 1, Value: []byte("garbage")}
+	claim.messages <- &sarama.ConsumerMessage{Topic: topic, Offset: 2, Value: value}
+	group := &FakeConsumerGroup{
+		session: &FakeGroupSession{},
+		claim:   claim,
+		errors:  make(chan error),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	result := make(chan models.Update)
+	consumer := NewGroupUpdatesConsumer(group, []string{topic}, logrus.New())
+	go consumer.Run(ctx, result)
+
+	upd := *ReadWithTimeout[models.Update](t, result, 1*time.Second, "should consume update")
+	acked, ok := upd.(*ackedUpdate)
+	assert.True(t, ok, "update must be wrapped to be acknowledged")
+	assert.Equal(t, chatId, acked.Update.(*models.ChatDeleted).ChatID)
+	assert.Equal(t, []int64{1}, group.session.Marked(), "only unparseable message must be marked before ack")
+
+	acked.ack()
+	assert.Equal(t, []int64{1, 2}, group.session.Marked())
+}
+
+func TestGroupUpdatesConsumer_MarksRejectedOnceDeadLettered(t *testing.T) {
+	topic := "chat.updates"
+	claim := &FakeGroupClaim{topic: topic, messages: make(chan *sarama.ConsumerMessage, 1)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: topic, Offset: 1, Value: []byte("garbage")}
+	group := &FakeConsumerGroup{
+		session: &FakeGroupSession{},
+		claim:   claim,
+		errors:  make(chan error),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	deadLetters := &FakeDeadLetters{failures: 2}
+	consumer := NewGroupUpdatesConsumer(group, []string{topic}, logrus.New()).WithDeadLetters(deadLetters)
+	go consumer.Run(ctx, make(chan models.Update))
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, group.session.Marked(), "message must not be marked while dead letters fail")
+	assert.Eventually(t, func() bool {
+		return len(deadLetters.Reasons()) == 1
+	}, 2*time.Second, 10*time.Millisecond, "sending to dead letters must be retried")
+	assert.Eventually(t, func() bool {
+		return len(group.session.Marked()) == 1
+	}, time.Second, 10*time.Millisecond, "message must be marked once it is in dead letters")
+}
+
+func TestNotificationStore_AcksGroupUpdates(t *testing.T) {
+	topic := "chat.updates"
+	userId := uuid.New().String()
+	value, _ := proto.Marshal(&updates.Update{
+		Meta: &updates.UpdateMeta{
+			Timestamp: time.Now().Unix(),
+			Audience:  []string{userId},
+		},
+		Update: &updates.Update_DeletedChat{DeletedChat: &updates.ChatDeleted{ChatId: uuid.New().String()}},
+	})
+	claim := &FakeGroupClaim{topic: topic, messages: make(chan *sarama.ConsumerMessage, 1)}
+	claim.messages <- &sarama.ConsumerMessage{Topic: topic, Offset: 7, Value: value}
+	group := &FakeConsumerGroup{
+		session: &FakeGroupSession{},
+		claim:   claim,
+		errors:  make(chan error),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := NewNotificationStorage(logrus.New(), NewGroupUpdatesConsumer(group, []string{topic}, logrus.New()))
+	l := store.Listen(userId)
+	go store.Run(ctx)
+
+	msg := *ReadWithTimeout(t, l.Notifications(), 1*time.Second, "should receive update")
+	_, isDeleted := msg.Update.(*models.ChatDeleted)
+	assert.True(t, isDeleted, "listener must receive unwrapped update")
+	assert.Eventually(t, func() bool {
+		return len(group.session.Marked()) == 1
+	}, 1*time.Second, 10*time.Millisecond, "offset must be marked after fan-out")
+}
@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrUnknownUpdateKind = errors.New("unknown update kind")
+)
+
+// Inbox persists notifications so users can get them after they reconnect
+type Inbox interface {
+	// Save stores upd in the inbox of every recipient and assigns
+	// the next sequence number of the recipient to the notification
+	Save(ctx context.Context, upd models.Update, recipients []string) ([]models.Notification, error)
+
+	// Since returns at most limit notifications of userID with sequence
+	// number greater than seq in ascending order
+	Since(ctx context.Context, userID string, seq int64, limit int) ([]models.Notification, error)
+
+	// List returns notifications of userID matching q from newest to oldest
+	List(ctx context.Context, userID string, q InboxQuery) ([]models.Notification, error)
+
+	// MarkRead marks unread notifications of userID matching sel as read
+	// and returns how many of them were marked
+	MarkRead(ctx context.Context, userID string, sel ReadSelector) (int64, error)
+
+	// UnreadCounts returns the number of unread notifications of userID
+	UnreadCounts(ctx context.Context, userID string) (models.UnreadCounts, error)
+}
+
+// InboxQuery selects a page of the inbox
+type InboxQuery struct {
+	// BeforeSeq limits the page to notifications older than it. Zero means from the newest one.
+	BeforeSeq int64
+	Limit     int
+	// ChatID limits the page to notifications of a single chat if not empty
+	ChatID string
+	// Kinds limits the page to the listed update kinds if not empty
+	Kinds []models.UpdateKind
+}
+
+func (q InboxQuery) matches(n models.Notification) bool {
+	if q.BeforeSeq != 0 && n.Seq >= q.BeforeSeq {
+		return false
+	}
+	if q.ChatID != "" && models.ChatOf(n.Update) != q.ChatID {
+		return false
+	}
+	if len(q.Kinds) == 0 {
+		return true
+	}
+	kind := models.KindOf(n.Update)
+	for _, k := range q.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+type PostgresInbox struct {
+	db *sql.DB
+}
+
+func NewPostgresInbox(db *sql.DB) *PostgresInbox {
+	return &PostgresInbox{
+		db: db,
+	}
+}
+
+func (i *PostgresInbox) Save(ctx context.Context, upd models.Update, recipients []string) ([]models.Notification, error) {
+	kind := models.KindOf(upd)
+	payload, err := MarshalUpdate(upd)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Row lock on the sequence is held until commit, so notifications
+	// of a user become visible strictly in order of their sequence numbers
+	stmt, err := tx.PrepareContext(ctx, `
+		WITH next AS (
+			INSERT INTO inbox_sequences (user_id, last_seq)
+			VALUES ($2, 1)
+			ON CONFLICT (user_id) DO UPDATE SET last_seq = inbox_sequences.last_seq + 1
+			RETURNING last_seq
+		)
+		INSERT INTO inbox (id, user_id, seq, kind, chat_id, payload, created_at)
+		SELECT $1, $2, next.last_seq, $3, $4, $5, $6 FROM next
+		RETURNING seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	notifications := make([]models.Notification, 0, len(recipients))
+	for _, userID := range recipients {
+		n := models.Notification{
+			Update:    upd,
+			ID:        uuid.New().String(),
+			UserID:    userID,
+			CreatedAt: upd.GetTime(),
+		}
+		err := stmt.QueryRowContext(ctx,
+			n.ID, n.UserID, string(kind), models.ChatOf(upd), payload, n.CreatedAt,
+		).Scan(&n.Seq)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, tx.Commit()
+}
+
+func (i *PostgresInbox) Since(ctx context.Context, userID string, seq int64, limit int) ([]models.Notification, error) {
+	rows, err := i.db.QueryContext(ctx, `
+		SELECT id, seq, kind, payload, read_at IS NOT NULL, created_at
+		FROM inbox
+		WHERE user_id = $1 AND seq > $2
+		ORDER BY seq
+		LIMIT $3`, userID, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanNotifications(rows, userID, limit)
+}
+
+func (i *PostgresInbox) List(ctx context.Context, userID string, q InboxQuery) ([]models.Notification, error) {
+	kinds := make([]string, 0, len(q.Kinds))
+	for _, k := range q.Kinds {
+		kinds = append(kinds, string(k))
+	}
+	rows, err := i.db.QueryContext(ctx, `
+		SELECT id, seq, kind, payload, read_at IS NOT NULL, created_at
+		FROM inbox
+		WHERE user_id = $1
+		  AND ($2::BIGINT = 0 OR seq < $2::BIGINT)
+		  AND ($3::TEXT = '' OR chat_id = $3::TEXT)
+		  AND ($4::TEXT = '' OR kind = ANY (string_to_array($4::TEXT, ',')))
+		ORDER BY seq DESC
+		LIMIT $5`, userID, q.BeforeSeq, q.ChatID, strings.Join(kinds, ","), q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanNotifications(rows, userID, q.Limit)
+}
+
+func scanNotifications(rows *sql.Rows, userID string, capacity int) ([]models.Notification, error) {
+	notifications := make([]models.Notification, 0, capacity)
+	for rows.Next() {
+		n := models.Notification{UserID: userID}
+		var kind string
+		var payload []byte
+		if err := rows.Scan(&n.ID, &n.Seq, &kind, &payload, &n.Read, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		var err error
+		n.Update, err = UnmarshalUpdate(models.UpdateKind(kind), payload)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications, rows.Err()
+}
+
+// MemoryInbox keeps notifications in process memory. It is meant for tests.
+type MemoryInbox struct {
+	mu            sync.RWMutex
+	notifications map[string][]models.Notification
+}
+
+func NewMemoryInbox() *MemoryInbox {
+	return &MemoryInbox{
+		notifications: make(map[string][]models.Notification),
+	}
+}
+
+func (i *MemoryInbox) Save(_ context.Context, upd models.Update, recipients []string) ([]models.Notification, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	notifications := make([]models.Notification, 0, len(recipients))
+	for _, userID := range recipients {
+		n := models.Notification{
+			Update:    upd,
+			ID:        uuid.New().String(),
+			UserID:    userID,
+			Seq:       int64(len(i.notifications[userID]) + 1),
+			CreatedAt: upd.GetTime(),
+		}
+		i.notifications[userID] = append(i.notifications[userID], n)
+		notifications = append(notifications, n)
+	}
+	return notifications, nil
+}
+
+func (i *MemoryInbox) Since(_ context.Context, userID string, seq int64, limit int) ([]models.Notification, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	stored := i.notifications[userID]
+	// Sequence numbers start from 1, so notification with seq n is stored at n-1
+	if seq < 0 {
+		seq = 0
+	}
+	if seq >= int64(len(stored)) {
+		return nil, nil
+	}
+	end := seq + int64(limit)
+	if end > int64(len(stored)) {
+		end = int64(len(stored))
+	}
+	return append([]models.Notification(nil), stored[seq:end]...), nil
+}
+
+func (i *MemoryInbox) List(_ context.Context, userID string, q InboxQuery) ([]models.Notification, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	stored := i.notifications[userID]
+	page := make([]models.Notification, 0, q.Limit)
+	for j := len(stored) - 1; j >= 0 && len(page) < q.Limit; j-- {
+		if q.matches(stored[j]) {
+			page = append(page, stored[j])
+		}
+	}
+	return page, nil
+}
+
+// Notifications returns all notifications saved for userID in order of saving
+func (i *MemoryInbox) Notifications(userID string) []models.Notification {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return append([]models.Notification(nil), i.notifications[userID]...)
+}
+
+// MarshalUpdate encodes upd the way it is stored in the inbox
+func MarshalUpdate(upd models.Update) ([]byte, error) {
+	if models.KindOf(upd) == "" {
+		return nil, fmt.Errorf("%w: %T", ErrUnknownUpdateKind, upd)
+	}
+	return json.Marshal(upd)
+}
+
+// UnmarshalUpdate decodes an update of the given kind encoded by MarshalUpdate
+func UnmarshalUpdate(kind models.UpdateKind, payload []byte) (models.Update, error) {
+	var upd models.Update
+	switch kind {
+	case models.KindMessageSent:
+		upd = &models.MessageSent{}
+	case models.KindChatCreated:
+		upd = &models.ChatCreated{}
+	case models.KindChatDeleted:
+		upd = &models.ChatDeleted{}
+	case models.KindMemberAdded:
+		upd = &models.MemberAdded{}
+	case models.KindMemberRemoved:
+		upd = &models.MemberRemoved{}
+	case models.KindUnreadChanged:
+		upd = &models.UnreadChanged{}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownUpdateKind, kind)
+	}
+	if err := json.Unmarshal(payload, upd); err != nil {
+		return nil, err
+	}
+	return upd, nil
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"strings"
+)
+
+// ReadSelector selects notifications to mark as read. Empty selector matches every notification.
+type ReadSelector struct {
+	// IDs limits selection to the listed notifications if not empty
+	IDs []string
+	// ChatID limits selection to notifications of a single chat if not empty
+	ChatID string
+	// UpToSeq limits selection to notifications with sequence number not greater than it if not zero
+	UpToSeq int64
+}
+
+func (sel ReadSelector) matches(n models.Notification) bool {
+	if sel.ChatID != "" && models.ChatOf(n.Update) != sel.ChatID {
+		return false
+	}
+	if sel.UpToSeq != 0 && n.Seq > sel.UpToSeq {
+		return false
+	}
+	if len(sel.IDs) == 0 {
+		return true
+	}
+	for _, id := range sel.IDs {
+		if id == n.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (i *PostgresInbox) MarkRead(ctx context.Context, userID string, sel ReadSelector) (int64, error) {
+	res, err := i.db.ExecContext(ctx, `
+		UPDATE inbox
+		SET read_at = now()
+		WHERE user_id = $1
+		  AND read_at IS NULL
+		  AND ($2::TEXT = '' OR id::TEXT = ANY (string_to_array($2::TEXT, ',')))
+		  AND ($3::TEXT = '' OR chat_id = $3::TEXT)
+		  AND ($4::BIGINT = 0 OR seq <= $4::BIGINT)`,
+		userID, strings.Join(sel.IDs, ","), sel.ChatID, sel.UpToSeq)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (i *PostgresInbox) UnreadCounts(ctx context.Context, userID string) (models.UnreadCounts, error) {
+	counts := models.UnreadCounts{Chats: make(map[string]int64)}
+	rows, err := i.db.QueryContext(ctx, `
+		SELECT chat_id, count(*)
+		FROM inbox
+		WHERE user_id = $1 AND read_at IS NULL
+		GROUP BY chat_id`, userID)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chatID string
+		var count int64
+		if err := rows.Scan(&chatID, &count); err != nil {
+			return counts, err
+		}
+		counts.Total += count
+		if chatID != "" {
+			counts.Chats[chatID] = count
+		}
+	}
+	return counts, rows.Err()
+}
+
+func (i *MemoryInbox) MarkRead(_ context.Context, userID string, sel ReadSelector) (int64, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	var marked int64
+	stored := i.notifications[userID]
+	for j := range stored {
+		if !stored[j].Read && sel.matches(stored[j]) {
+			stored[j].Read = true
+			marked++
+		}
+	}
+	return marked, nil
+}
+
+func (i *MemoryInbox) UnreadCounts(_ context.Context, userID string) (models.UnreadCounts, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	counts := models.UnreadCounts{Chats: make(map[string]int64)}
+	for _, n := range i.notifications[userID] {
+		if n.Read {
+			continue
+		}
+		counts.Total++
+		if chatID := models.ChatOf(n.Update); chatID != "" {
+			counts.Chats[chatID]++
+		}
+	}
+	return counts, nil
+}
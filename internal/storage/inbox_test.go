@@ -0,0 +1,181 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUpdatePayloadRoundTrip(t *testing.T) {
+	replyTo := uuid.New().String()
+	meta := models.UpdateMeta{
+		Timestamp: time.Date(2023, 04, 15, 20, 0, 0, 0, time.UTC),
+		Audience:  []string{"burenotti"},
+	}
+	upds := []models.Update{
+		&models.MessageSent{
+			UpdateMeta: meta,
+			MessageID:  uuid.New().String(),
+			FromUser:   "burenotti",
+			ChatID:     uuid.New().String(),
+			Text:       "Hello, world!",
+			ReplyTo:    &replyTo,
+			Attachments: []models.FileAttachment{
+				{MimeType: "image/png", FileID: uuid.New().String()},
+			},
+		},
+		&models.ChatCreated{UpdateMeta: meta, ChatID: uuid.New().String(), Members: []string{"burenotti"}},
+		&models.ChatDeleted{UpdateMeta: meta, ChatID: uuid.New().String()},
+		&models.MemberAdded{UpdateMeta: meta, ChatID: uuid.New().String(), UserID: "burenotti"},
+		&models.MemberRemoved{UpdateMeta: meta, ChatID: uuid.New().String(), UserID: "burenotti"},
+		&models.UnreadChanged{UpdateMeta: meta, Counts: models.UnreadCounts{Total: 1, Chats: map[string]int64{"chat": 1}}},
+	}
+
+	for _, upd := range upds {
+		payload, err := MarshalUpdate(upd)
+		assert.NoError(t, err)
+		actual, err := UnmarshalUpdate(models.KindOf(upd), payload)
+		assert.NoError(t, err)
+		assert.Equal(t, upd, actual)
+	}
+
+	_, err := UnmarshalUpdate("unknown", []byte("{}"))
+	assert.ErrorIs(t, err, ErrUnknownUpdateKind)
+}
+
+func TestNotificationStore_SavesToInbox(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	upd := &models.ChatDeleted{
+		UpdateMeta: models.UpdateMeta{
+			Timestamp: time.Now().UTC(),
+			Audience:  []string{"online", "offline"},
+		},
+		ChatID: uuid.New().String(),
+	}
+	upds := make(chan models.Update, 1)
+	upds <- upd
+
+	inbox := NewMemoryInbox()
+	store := NewNotificationStorage(logrus.New()).WithInbox(inbox)
+	l := store.Listen("online")
+	defer l.Detach()
+	go store.fanOutUpdates(ctx, upds)
+
+	n := *ReadWithTimeout(t, l.Notifications(), 1*time.Second, "online user must be notified")
+	assert.Equal(t, int64(1), n.Seq, "listener must receive sequence number assigned by inbox")
+	assert.Len(t, inbox.Notifications("online"), 1)
+	offline := inbox.Notifications("offline")
+	assert.Len(t, offline, 1, "update must be kept for offline user")
+	assert.Equal(t, upd, offline[0].Update)
+	assert.Equal(t, "offline", offline[0].UserID)
+}
+
+func TestMemoryInbox_Since(t *testing.T) {
+	ctx := context.Background()
+	inbox := NewMemoryInbox()
+	for i := 0; i < 5; i++ {
+		_, err := inbox.Save(ctx, &models.ChatDeleted{
+			UpdateMeta: models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"a", "b"}},
+			ChatID:     uuid.New().String(),
+		}, []string{"a", "b"})
+		assert.NoError(t, err)
+	}
+
+	page, err := inbox.Since(ctx, "a", 1, 3)
+	assert.NoError(t, err)
+	seqs := make([]int64, 0, len(page))
+	for _, n := range page {
+		seqs = append(seqs, n.Seq)
+	}
+	assert.Equal(t, []int64{2, 3, 4}, seqs)
+
+	page, err = inbox.Since(ctx, "b", 5, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, page)
+}
+
+// FlakyInbox fails to save the given number of times before it starts to work
+type FlakyInbox struct {
+	*MemoryInbox
+	failures atomic.Int32
+}
+
+func (i *FlakyInbox) Save(ctx context.Context, upd models.Update, recipients []string) ([]models.Notification, error) {
+	if i.failures.Add(-1) >= 0 {
+		return nil, errors.New("connection refused")
+	}
+	return i.MemoryInbox.Save(ctx, upd, recipients)
+}
+
+func TestNotificationStore_RetriesSavingToInbox(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	inbox := &FlakyInbox{MemoryInbox: NewMemoryInbox()}
+	inbox.failures.Store(3)
+	store := NewNotificationStorage(logrus.New()).WithInbox(inbox)
+	store.retryDelay = time.Millisecond
+	l := store.Listen("online")
+	defer l.Detach()
+
+	acked := make(chan struct{})
+	upds := make(chan models.Update, 1)
+	upds <- &ackedUpdate{
+		Update: &models.ChatDeleted{
+			UpdateMeta: models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"online", "offline"}},
+			ChatID:     uuid.New().String(),
+		},
+		ack: func() { close(acked) },
+	}
+	go store.fanOutUpdates(ctx, upds)
+
+	n := ReadWithTimeout(t, l.Notifications(), time.Second, "update must be delivered once it is saved")
+	if n != nil {
+		assert.Equal(t, int64(1), n.Seq)
+	}
+	select {
+	case <-acked:
+	case <-time.After(time.Second):
+		assert.Fail(t, "update must be acked once it is saved")
+	}
+	assert.Len(t, inbox.Notifications("offline"), 1, "update must be kept for offline user")
+}
+
+func TestNotificationStore_DoesNotAckUnsavedUpdates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	inbox := &FlakyInbox{MemoryInbox: NewMemoryInbox()}
+	inbox.failures.Store(math.MaxInt32)
+	store := NewNotificationStorage(logrus.New()).WithInbox(inbox)
+	store.retryDelay = time.Millisecond
+
+	upds := make(chan models.Update, 1)
+	upds <- &ackedUpdate{
+		Update: &models.ChatDeleted{
+			UpdateMeta: models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"offline"}},
+			ChatID:     uuid.New().String(),
+		},
+		ack: func() { t.Error("update which isn't saved must not be acked") },
+	}
+	done := make(chan struct{})
+	go func() {
+		store.fanOutUpdates(ctx, upds)
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		assert.Fail(t, "fan-out must stop retrying once the store is stopped")
+	}
+}
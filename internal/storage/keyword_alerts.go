@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// keywordMatcher is an immutable index of keyword rules of all users
+type keywordMatcher struct {
+	keywords *ahoCorasick
+	// owners are users of every keyword passed to the automaton
+	owners  [][]string
+	regexps map[string][]*regexp.Regexp
+}
+
+func newKeywordMatcher(rules []models.KeywordRule) *keywordMatcher {
+	m := &keywordMatcher{regexps: make(map[string][]*regexp.Regexp)}
+	// Users often pick the same keywords, so every keyword is put into the automaton once
+	index := make(map[string]int)
+	var patterns []string
+	for _, rule := range rules {
+		if rule.Regexp {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				continue
+			}
+			m.regexps[rule.UserID] = append(m.regexps[rule.UserID], re)
+			continue
+		}
+		keyword := strings.ToLower(rule.Pattern)
+		i, ok := index[keyword]
+		if !ok {
+			i = len(patterns)
+			index[keyword] = i
+			patterns = append(patterns, keyword)
+			m.owners = append(m.owners, nil)
+		}
+		m.owners[i] = append(m.owners[i], rule.UserID)
+	}
+	m.keywords = newAhoCorasick(patterns)
+	return m
+}
+
+// match returns members of audience having a rule matching text
+func (m *keywordMatcher) match(text string, audience []string) map[string]bool {
+	members := make(map[string]bool, len(audience))
+	for _, userID := range audience {
+		members[userID] = true
+	}
+	matched := make(map[string]bool)
+	m.keywords.match(strings.ToLower(text), func(pattern int) {
+		for _, userID := range m.owners[pattern] {
+			if members[userID] {
+				matched[userID] = true
+			}
+		}
+	})
+	for _, userID := range audience {
+		if matched[userID] {
+			continue
+		}
+		for _, re := range m.regexps[userID] {
+			if re.MatchString(text) {
+				matched[userID] = true
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// KeywordAlerts matches messages against keyword rules of all users.
+// Rules are kept in memory and reloaded from the store on changes and periodically,
+// so rules changed through other replicas are picked up as well.
+type KeywordAlerts struct {
+	rules   KeywordStore
+	matcher atomic.Pointer[keywordMatcher]
+	logger  *logrus.Logger
+}
+
+func NewKeywordAlerts(rules KeywordStore, logger *logrus.Logger) *KeywordAlerts {
+	k := &KeywordAlerts{
+		rules:  rules,
+		logger: logger,
+	}
+	k.matcher.Store(newKeywordMatcher(nil))
+	return k
+}
+
+// Reload rebuilds the matcher from rules in the store
+func (k *KeywordAlerts) Reload(ctx context.Context) error {
+	rules, err := k.rules.All(ctx)
+	if err != nil {
+		return err
+	}
+	k.matcher.Store(newKeywordMatcher(rules))
+	return nil
+}
+
+// Run reloads rules every interval until ctx is done
+func (k *KeywordAlerts) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := k.Reload(ctx); err != nil {
+			k.logger.Errorf("can't reload keyword rules: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Match returns recipients of msg having a rule matching it. The sender is never returned.
+func (k *KeywordAlerts) Match(msg *models.MessageSent) map[string]bool {
+	matched := k.matcher.Load().match(msg.Text, msg.GetAudience())
+	delete(matched, msg.FromUser)
+	return matched
+}
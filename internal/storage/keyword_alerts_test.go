@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestAhoCorasick_Match(t *testing.T) {
+	patterns := []string{"he", "she", "his", "hers", "release"}
+	a := newAhoCorasick(patterns)
+
+	var found []string
+	a.match("ushers and the release", func(p int) {
+		found = append(found, patterns[p])
+	})
+	sort.Strings(found)
+	assert.Equal(t, []string{"he", "he", "hers", "release", "she"}, found)
+}
+
+func TestKeywordAlerts_Match(t *testing.T) {
+	ctx := context.Background()
+	rules := NewMemoryKeywords()
+	for _, rule := range []models.KeywordRule{
+		{UserID: "burenotti", Pattern: "Release"},
+		{UserID: "alice", Pattern: "release"},
+		{UserID: "bob", Pattern: `\bv\d+\.\d+\b`, Regexp: true},
+		{UserID: "carol", Pattern: "deploy"},
+	} {
+		assert.NoError(t, rules.Create(ctx, rule))
+	}
+	alerts := NewKeywordAlerts(rules, logrus.New())
+	assert.NoError(t, alerts.Reload(ctx))
+
+	msg := func(from string, text string) *models.MessageSent {
+		return &models.MessageSent{
+			UpdateMeta: models.UpdateMeta{Audience: []string{"burenotti", "alice", "bob"}},
+			FromUser:   from,
+			Text:       text,
+		}
+	}
+	assert.Equal(t, map[string]bool{"burenotti": true, "alice": true, "bob": true}, alerts.Match(msg("dave", "RELEASE v1.2 is out")))
+	assert.Equal(t, map[string]bool{"burenotti": true}, alerts.Match(msg("alice", "release soon")), "sender must not be alerted")
+	assert.Empty(t, alerts.Match(msg("dave", "time to deploy")), "users out of the chat must not be alerted")
+}
+
+func TestNotificationStore_KeywordBypassesMutes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chatId := uuid.New().String()
+
+	prefs := NewMemoryPreferences()
+	_, err := prefs.Update(ctx, "burenotti", func(p *models.Preferences) error {
+		p.ChatMutes = map[string]models.ChatMute{chatId: {}}
+		return nil
+	})
+	assert.NoError(t, err)
+	rules := NewMemoryKeywords()
+	assert.NoError(t, rules.Create(ctx, models.KeywordRule{UserID: "burenotti", Pattern: "outage"}))
+	alerts := NewKeywordAlerts(rules, logrus.New())
+	assert.NoError(t, alerts.Reload(ctx))
+
+	meta := models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"burenotti"}}
+	cons := NewFakeConsumer()
+	cons.Fit(
+		&models.MessageSent{UpdateMeta: meta, MessageID: uuid.New().String(), ChatID: chatId, FromUser: "alice", Text: "lunch?"},
+		&models.MessageSent{UpdateMeta: meta, MessageID: uuid.New().String(), ChatID: chatId, FromUser: "alice", Text: "Outage in prod"},
+	)
+	store := NewNotificationStorage(logrus.New(), cons).
+		WithInbox(NewMemoryInbox()).
+		WithPreferences(prefs).
+		WithKeywords(alerts)
+	l := store.Listen("burenotti")
+	defer l.Detach()
+	go store.Run(ctx)
+
+	n := ReadWithTimeout(t, l.Notifications(), time.Second, "message matching a keyword must be delivered")
+	if n != nil {
+		assert.Equal(t, int64(2), n.Seq, "muted message must be skipped")
+		assert.Equal(t, models.ReasonKeyword, n.Reason)
+	}
+}
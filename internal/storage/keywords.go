@@ -0,0 +1,141 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"sync"
+)
+
+var (
+	ErrKeywordRuleNotFound = errors.New("keyword rule not found")
+)
+
+// KeywordStore keeps keyword rules registered by users
+type KeywordStore interface {
+	Create(ctx context.Context, rule models.KeywordRule) error
+
+	List(ctx context.Context, userID string) ([]models.KeywordRule, error)
+
+	Delete(ctx context.Context, userID string, id string) error
+
+	// All returns rules of all users to build a matcher of
+	All(ctx context.Context) ([]models.KeywordRule, error)
+}
+
+type PostgresKeywords struct {
+	db *sql.DB
+}
+
+func NewPostgresKeywords(db *sql.DB) *PostgresKeywords {
+	return &PostgresKeywords{
+		db: db,
+	}
+}
+
+func (s *PostgresKeywords) Create(ctx context.Context, rule models.KeywordRule) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO keyword_rules (id, user_id, pattern, is_regexp, created_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		rule.ID, rule.UserID, rule.Pattern, rule.Regexp, rule.CreatedAt)
+	return err
+}
+
+func (s *PostgresKeywords) List(ctx context.Context, userID string) ([]models.KeywordRule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, pattern, is_regexp, created_at
+		FROM keyword_rules
+		WHERE user_id = $1
+		ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanKeywordRules(rows)
+}
+
+func (s *PostgresKeywords) Delete(ctx context.Context, userID string, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM keyword_rules WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrKeywordRuleNotFound
+	}
+	return nil
+}
+
+func (s *PostgresKeywords) All(ctx context.Context) ([]models.KeywordRule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, pattern, is_regexp, created_at
+		FROM keyword_rules`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanKeywordRules(rows)
+}
+
+func scanKeywordRules(rows *sql.Rows) ([]models.KeywordRule, error) {
+	var rules []models.KeywordRule
+	for rows.Next() {
+		var rule models.KeywordRule
+		if err := rows.Scan(&rule.ID, &rule.UserID, &rule.Pattern, &rule.Regexp, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// MemoryKeywords keeps keyword rules in process memory. It is meant for tests.
+type MemoryKeywords struct {
+	mu    sync.Mutex
+	rules []models.KeywordRule
+}
+
+func NewMemoryKeywords() *MemoryKeywords {
+	return &MemoryKeywords{}
+}
+
+func (s *MemoryKeywords) Create(_ context.Context, rule models.KeywordRule) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rules = append(s.rules, rule)
+	return nil
+}
+
+func (s *MemoryKeywords) List(_ context.Context, userID string) ([]models.KeywordRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var rules []models.KeywordRule
+	for _, rule := range s.rules {
+		if rule.UserID == userID {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+func (s *MemoryKeywords) Delete(_ context.Context, userID string, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, rule := range s.rules {
+		if rule.ID == id && rule.UserID == userID {
+			s.rules = append(s.rules[:i], s.rules[i+1:]...)
+			return nil
+		}
+	}
+	return ErrKeywordRuleNotFound
+}
+
+func (s *MemoryKeywords) All(_ context.Context) ([]models.KeywordRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.KeywordRule(nil), s.rules...), nil
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// LastSeenStore keeps the last moment users were connected
+type LastSeenStore interface {
+	Seen(ctx context.Context, userID string, at time.Time) error
+
+	// LastSeen returns the last moment every one of userIDs was seen. Users never seen are omitted.
+	LastSeen(ctx context.Context, userIDs []string) (map[string]time.Time, error)
+}
+
+type PostgresLastSeen struct {
+	db *sql.DB
+}
+
+func NewPostgresLastSeen(db *sql.DB) *PostgresLastSeen {
+	return &PostgresLastSeen{
+		db: db,
+	}
+}
+
+func (s *PostgresLastSeen) Seen(ctx context.Context, userID string, at time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO last_seen (user_id, seen_at)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET seen_at = greatest(last_seen.seen_at, excluded.seen_at)`, userID, at)
+	return err
+}
+
+func (s *PostgresLastSeen) LastSeen(ctx context.Context, userIDs []string) (map[string]time.Time, error) {
+	users, err := json.Marshal(userIDs)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, seen_at
+		FROM last_seen
+		WHERE user_id IN (SELECT jsonb_array_elements_text($1::JSONB))`, string(users))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[string]time.Time)
+	for rows.Next() {
+		var userID string
+		var at time.Time
+		if err := rows.Scan(&userID, &at); err != nil {
+			return nil, err
+		}
+		seen[userID] = at
+	}
+	return seen, rows.Err()
+}
+
+// MemoryLastSeen keeps last seen moments in process memory. It is meant for tests.
+type MemoryLastSeen struct {
+	mu   sync.Mutex
+	seen map[string]time.Time
+}
+
+func NewMemoryLastSeen() *MemoryLastSeen {
+	return &MemoryLastSeen{
+		seen: make(map[string]time.Time),
+	}
+}
+
+func (s *MemoryLastSeen) Seen(_ context.Context, userID string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if at.After(s.seen[userID]) {
+		s.seen[userID] = at
+	}
+	return nil
+}
+
+func (s *MemoryLastSeen) LastSeen(_ context.Context, userIDs []string) (map[string]time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := make(map[string]time.Time)
+	for _, userID := range userIDs {
+		if at, ok := s.seen[userID]; ok {
+			seen[userID] = at
+		}
+	}
+	return seen, nil
+}
@@ -13,7 +13,7 @@ func MessageSentUpdateToDomain(meta *updates.UpdateMeta, msg *updates.MessageSen
 	}
 	upd := &models.MessageSent{
 		UpdateMeta: models.UpdateMeta{
-			Timestamp: time.Unix(meta.Timestamp, 0).UTC(),
+			Timestamp: timeOf(meta.Timestamp),
 			Audience:  meta.Audience,
 		},
 		MessageID:   msg.MessageId,
@@ -36,7 +36,7 @@ func MessageSentUpdateToDomain(meta *updates.UpdateMeta, msg *updates.MessageSen
 func ChatCreatedToDomain(meta *updates.UpdateMeta, msg *updates.ChatCreated) *models.ChatCreated {
 	return &models.ChatCreated{
 		UpdateMeta: models.UpdateMeta{
-			Timestamp: time.Unix(meta.Timestamp, 0).UTC(),
+			Timestamp: timeOf(meta.Timestamp),
 			Audience:  meta.Audience,
 		},
 		ChatID:   msg.ChatId,
@@ -44,3 +44,44 @@ func ChatCreatedToDomain(meta *updates.UpdateMeta, msg *updates.ChatCreated) *mo
 		Members:  msg.Members,
 	}
 }
+
+func ChatDeletedToDomain(meta *updates.UpdateMeta, msg *updates.ChatDeleted) *models.ChatDeleted {
+	return &models.ChatDeleted{
+		UpdateMeta: models.UpdateMeta{
+			Timestamp: timeOf(meta.Timestamp),
+			Audience:  meta.Audience,
+		},
+		ChatID: msg.ChatId,
+	}
+}
+
+func MemberAddedToDomain(meta *updates.UpdateMeta, msg *updates.MemberAdded) *models.MemberAdded {
+	return &models.MemberAdded{
+		UpdateMeta: models.UpdateMeta{
+			Timestamp: timeOf(meta.Timestamp),
+			Audience:  meta.Audience,
+		},
+		ChatID: msg.ChatId,
+		UserID: msg.UserId,
+	}
+}
+
+func MemberRemovedToDomain(meta *updates.UpdateMeta, msg *updates.MemberRemoved) *models.MemberRemoved {
+	return &models.MemberRemoved{
+		UpdateMeta: models.UpdateMeta{
+			Timestamp: timeOf(meta.Timestamp),
+			Audience:  meta.Audience,
+		},
+		ChatID: msg.ChatId,
+		UserID: msg.UserId,
+	}
+}
+
+// timeOf converts a unix timestamp of an update. Timestamps which aren't positive
+// become the zero time, so the validator rejects them as missing.
+func timeOf(timestamp int64) time.Time {
+	if timestamp <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(timestamp, 0).UTC()
+}
@@ -0,0 +1,38 @@
+package storage
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	listenerOverflows = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "notifications",
+		Name:      "listener_overflows_total",
+		Help:      "Number of notifications which didn't fit into a listener buffer by applied overflow policy",
+	}, []string{"policy"})
+
+	webhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "notifications",
+		Name:      "webhook_deliveries_total",
+		Help:      "Number of notifications posted to webhooks by result: ok, failed after all retries or dropped",
+	}, []string{"result"})
+
+	pushNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "notifications",
+		Name:      "pushes_total",
+		Help:      "Number of push notifications by platform and result: ok, failed, invalid_token or dropped",
+	}, []string{"platform", "result"})
+
+	inboxSaveFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "notifications",
+		Name:      "inbox_save_failures_total",
+		Help:      "Number of failed attempts to save an update to the inbox",
+	})
+
+	forwardedNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "notifications",
+		Name:      "forwarded_total",
+		Help:      "Number of notifications forwarded to listeners on other instances by result: ok, failed or dropped",
+	}, []string{"result"})
+)
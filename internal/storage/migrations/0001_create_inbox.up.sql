@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS inbox
+(
+    id         UUID PRIMARY KEY,
+    user_id    TEXT        NOT NULL,
+    kind       TEXT        NOT NULL,
+    chat_id    TEXT        NOT NULL DEFAULT '',
+    payload    JSONB       NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS inbox_user_id_created_at_idx ON inbox (user_id, created_at DESC);
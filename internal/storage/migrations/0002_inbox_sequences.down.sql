@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS inbox_user_id_seq_idx;
+
+ALTER TABLE inbox
+    DROP COLUMN IF EXISTS seq;
+
+DROP TABLE IF EXISTS inbox_sequences;
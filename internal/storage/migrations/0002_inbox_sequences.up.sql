@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS inbox_sequences
+(
+    user_id  TEXT PRIMARY KEY,
+    last_seq BIGINT NOT NULL
+);
+
+ALTER TABLE inbox
+    ADD COLUMN seq BIGINT;
+
+UPDATE inbox
+SET seq = numbered.seq
+FROM (SELECT id, row_number() OVER (PARTITION BY user_id ORDER BY created_at, id) AS seq FROM inbox) AS numbered
+WHERE inbox.id = numbered.id;
+
+INSERT INTO inbox_sequences (user_id, last_seq)
+SELECT user_id, max(seq)
+FROM inbox
+GROUP BY user_id;
+
+ALTER TABLE inbox
+    ALTER COLUMN seq SET NOT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS inbox_user_id_seq_idx ON inbox (user_id, seq);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS inbox_user_id_chat_id_seq_idx;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS inbox_user_id_chat_id_seq_idx ON inbox (user_id, chat_id, seq DESC);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS inbox_unread_idx;
+
+ALTER TABLE inbox
+    DROP COLUMN IF EXISTS read_at;
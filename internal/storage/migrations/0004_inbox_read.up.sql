@@ -0,0 +1,4 @@
+ALTER TABLE inbox
+    ADD COLUMN read_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS inbox_unread_idx ON inbox (user_id, chat_id) WHERE read_at IS NULL;
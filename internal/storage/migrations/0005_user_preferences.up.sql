@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user_preferences
+(
+    user_id    TEXT PRIMARY KEY,
+    data       JSONB       NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS webhooks
+(
+    id          UUID PRIMARY KEY,
+    user_id     TEXT        NOT NULL,
+    url         TEXT        NOT NULL,
+    secret      TEXT        NOT NULL,
+    failures    INT         NOT NULL DEFAULT 0,
+    disabled_at TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id);
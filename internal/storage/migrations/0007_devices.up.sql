@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS devices
+(
+    token      TEXT PRIMARY KEY,
+    user_id    TEXT        NOT NULL,
+    platform   TEXT        NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS devices_user_id_idx ON devices (user_id);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS email_digests
+(
+    user_id         TEXT PRIMARY KEY,
+    email           TEXT        NOT NULL,
+    last_seen_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_digest_at  TIMESTAMPTZ,
+    last_digest_seq BIGINT      NOT NULL DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS email_digests_last_seen_at_idx ON email_digests (last_seen_at);
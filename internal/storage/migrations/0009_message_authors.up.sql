@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS message_authors
+(
+    message_id TEXT PRIMARY KEY,
+    user_id    TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
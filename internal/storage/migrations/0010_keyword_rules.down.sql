@@ -0,0 +1 @@
+DROP TABLE IF EXISTS keyword_rules;
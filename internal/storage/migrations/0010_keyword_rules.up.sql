@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS keyword_rules
+(
+    id         UUID PRIMARY KEY,
+    user_id    TEXT        NOT NULL,
+    pattern    TEXT        NOT NULL,
+    is_regexp  BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS keyword_rules_user_id_idx ON keyword_rules (user_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS presence;
+DROP TABLE IF EXISTS instances;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS instances
+(
+    id         TEXT PRIMARY KEY,
+    address    TEXT        NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS presence
+(
+    user_id     TEXT NOT NULL,
+    instance_id TEXT NOT NULL REFERENCES instances (id) ON DELETE CASCADE,
+    PRIMARY KEY (user_id, instance_id)
+);
+
+CREATE INDEX IF NOT EXISTS presence_instance_id_idx ON presence (instance_id);
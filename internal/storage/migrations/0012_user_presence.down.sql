@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS last_seen;
+
+ALTER TABLE presence
+    DROP COLUMN IF EXISTS listeners;
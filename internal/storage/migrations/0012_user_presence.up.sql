@@ -0,0 +1,8 @@
+ALTER TABLE presence
+    ADD COLUMN IF NOT EXISTS listeners INT NOT NULL DEFAULT 1;
+
+CREATE TABLE IF NOT EXISTS last_seen
+(
+    user_id TEXT PRIMARY KEY,
+    seen_at TIMESTAMPTZ NOT NULL
+);
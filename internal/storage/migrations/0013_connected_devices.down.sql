@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS read_cursors;
+
+ALTER TABLE presence
+    DROP COLUMN IF EXISTS devices;
@@ -0,0 +1,11 @@
+ALTER TABLE presence
+    ADD COLUMN IF NOT EXISTS devices JSONB NOT NULL DEFAULT '[]';
+
+CREATE TABLE IF NOT EXISTS read_cursors
+(
+    user_id    TEXT        NOT NULL,
+    device_id  TEXT        NOT NULL,
+    seq        BIGINT      NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (user_id, device_id)
+);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS message_authors_created_at_idx;
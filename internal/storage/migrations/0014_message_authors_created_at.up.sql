@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS message_authors_created_at_idx ON message_authors (created_at);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS chat_members
+(
+    chat_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    PRIMARY KEY (user_id, chat_id)
+);
+
+CREATE INDEX IF NOT EXISTS chat_members_chat_id_idx ON chat_members (chat_id);
@@ -3,71 +3,361 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"github.com/practice-sem-2/notification-service/internal/models"
 	"github.com/sirupsen/logrus"
-	"github.com/zyedidia/generic/multimap"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // TODO IDK how to correctly choose channel buffer size
 const readerBufferSize = 16
 
+const (
+	saveRetryDelay    = 100 * time.Millisecond
+	maxSaveRetryDelay = 10 * time.Second
+)
+
 type Worker func()
 
+// subscription is the state of a listener shared with the store
+type subscription struct {
+	ch      chan models.Notification
+	behind  chan struct{}
+	devices chan struct{}
+	policy  OverflowPolicy
+	device  models.ConnectedDevice
+	filter  ListenFilter
+	// spilling is set once a notification was left in the inbox and until the listener caught up
+	spilling atomic.Bool
+	closed   bool
+	err      error
+}
+
 type NotificationListener struct {
-	UserID   string
-	store    *NotificationStore
-	listener chan models.Update
+	UserID string
+	store  *NotificationStore
+	sub    *subscription
+}
+
+func (l *NotificationListener) Notifications() <-chan models.Notification {
+	return l.sub.ch
+}
+
+// Behind is signalled when notifications were left in the inbox because
+// the listener buffer was full. The listener should catch up from the inbox.
+func (l *NotificationListener) Behind() <-chan struct{} {
+	return l.sub.behind
+}
+
+// DevicesChanged is signalled when a device of the same user connects to or disconnects
+// from this instance. Devices listening on other instances aren't tracked.
+func (l *NotificationListener) DevicesChanged() <-chan struct{} {
+	return l.sub.devices
 }
 
-func (l *NotificationListener) Notifications() <-chan models.Update {
-	return l.listener
+// CaughtUp makes the store buffer stored notifications for the listener again after it spilled.
+// The listener must call it after draining the buffer and before replaying the inbox,
+// so notifications stored meanwhile are either replayed or buffered.
+func (l *NotificationListener) CaughtUp() {
+	l.sub.spilling.Store(false)
+}
+
+// Err returns the reason the store detached the listener, if it did
+func (l *NotificationListener) Err() error {
+	sh := l.store.shardOf(l.UserID)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	return l.sub.err
 }
 
 // Detach cancels listening and closes the listener channel
 func (l *NotificationListener) Detach() {
-	l.store.detach(l)
+	l.store.detach(l.UserID, l.sub, nil)
+}
+
+type ListenOptions struct {
+	// Overflow overrides the default overflow policy of the store
+	Overflow OverflowPolicy
+	// DeviceID identifies the device of the user. A listener of the same device
+	// replaces the previous one, which is detached with ErrDeviceReplaced.
+	DeviceID string
+	Client   models.ClientType
+	// Filter is applied before notifications are buffered, so filtered out ones cost the listener nothing
+	Filter ListenFilter
+}
+
+// ackedUpdate wraps an update whose source must be acknowledged
+// after the update has been fanned out to its audience.
+type ackedUpdate struct {
+	models.Update
+	ack func()
 }
 
 type Consumer interface {
 	Run(ctx context.Context, updates chan<- models.Update) error
 }
 
+// Sink gets notifications delivered by the store besides live listeners,
+// e.g. webhooks. Deliver is called during fan-out, so it must not block.
+// It is called by delivery workers of all shards concurrently.
+type Sink interface {
+	Deliver(ctx context.Context, n models.Notification)
+}
+
 type NotificationStore struct {
-	rm        sync.RWMutex
 	consumers []Consumer
-	listeners multimap.MultiMap[string, chan models.Update]
+	shards    []*shard
+	acks      ackQueue
+	inbox     Inbox
+	prefs     PreferencesStore
+	authors   MessageAuthors
+	members   ChatMembers
+	keywords  *KeywordAlerts
+	cluster   *Cluster
+	sinks     []Sink
+	overflow  OverflowPolicy
 	logger    *logrus.Logger
+	// retryDelay is the first delay before saving to the inbox is retried
+	retryDelay time.Duration
 }
 
 func NewNotificationStorage(logger *logrus.Logger, consumers ...Consumer) *NotificationStore {
 	store := &NotificationStore{
-		consumers: consumers,
-		listeners: multimap.NewMapSlice[string, chan models.Update](),
-		logger:    logger,
+		consumers:  consumers,
+		shards:     newShards(runtime.GOMAXPROCS(0)),
+		overflow:   OverflowDropNewest,
+		logger:     logger,
+		retryDelay: saveRetryDelay,
 	}
 	return store
 }
 
-func (s *NotificationStore) Notify(userID string, msg models.Update) {
-	data, _ := json.Marshal(msg)
-	s.logger.
-		WithField("update", string(data)).
-		Infof("Notifying %s", userID)
-	s.rm.RLock()
-	for _, reader := range s.listeners.Get(userID) {
-		reader <- msg
+// WithShards splits listeners into n shards, each with its own lock and delivery worker.
+// It must be called before anyone listens to the store.
+func (s *NotificationStore) WithShards(n int) *NotificationStore {
+	s.shards = newShards(n)
+	return s
+}
+
+func (s *NotificationStore) shardOf(userID string) *shard {
+	return s.shards[shardIndex(userID, len(s.shards))]
+}
+
+// WithInbox makes store persist every fanned out update in inbox.
+func (s *NotificationStore) WithInbox(inbox Inbox) *NotificationStore {
+	s.inbox = inbox
+	return s
+}
+
+// WithPreferences makes store skip live delivery of notifications muted by users.
+func (s *NotificationStore) WithPreferences(prefs PreferencesStore) *NotificationStore {
+	s.prefs = prefs
+	return s
+}
+
+// WithAuthors makes store remember authors of messages to tell users about replies to them.
+func (s *NotificationStore) WithAuthors(authors MessageAuthors) *NotificationStore {
+	s.authors = authors
+	return s
+}
+
+// WithMembers makes store track members of chats told by updates.
+func (s *NotificationStore) WithMembers(members ChatMembers) *NotificationStore {
+	s.members = members
+	return s
+}
+
+// WithKeywords makes store alert users about messages matching their keyword rules.
+func (s *NotificationStore) WithKeywords(keywords *KeywordAlerts) *NotificationStore {
+	s.keywords = keywords
+	return s
+}
+
+// Prioritize sets Reason of message notifications which concern their users in particular
+func (s *NotificationStore) Prioritize(ctx context.Context, notifications []models.Notification) {
+	// Notifications of one update reply to the same message, so it is looked up once
+	authors := make(map[string]string)
+	keywords := make(map[string]map[string]bool)
+	for i := range notifications {
+		msg, ok := notifications[i].Update.(*models.MessageSent)
+		if !ok {
+			continue
+		}
+		var repliedTo string
+		if msg.ReplyTo != nil && s.authors != nil {
+			author, known := authors[*msg.ReplyTo]
+			if !known {
+				var err error
+				author, err = s.authors.Author(ctx, *msg.ReplyTo)
+				if err != nil {
+					s.logger.Errorf("can't get author of message %s: %v", *msg.ReplyTo, err)
+				}
+				authors[*msg.ReplyTo] = author
+			}
+			repliedTo = author
+		}
+		notifications[i].Reason = msg.ReasonFor(notifications[i].UserID, repliedTo)
+		if notifications[i].Reason != models.ReasonNone || s.keywords == nil {
+			continue
+		}
+		matched, known := keywords[msg.MessageID]
+		if !known {
+			matched = s.keywords.Match(msg)
+			keywords[msg.MessageID] = matched
+		}
+		if matched[notifications[i].UserID] {
+			notifications[i].Reason = models.ReasonKeyword
+		}
+	}
+}
+
+// Deliverable reports whether n may be delivered to live listeners of its user.
+// During quiet hours of the user n is marked silent.
+func (s *NotificationStore) Deliverable(ctx context.Context, n *models.Notification) bool {
+	if s.prefs == nil {
+		return true
+	}
+	prefs, err := s.prefs.Get(ctx, n.UserID)
+	if err != nil {
+		s.logger.Errorf("can't get preferences of %s: %v", n.UserID, err)
+		return true
+	}
+	now := time.Now()
+	// Keyword rules are set up by users to hear about messages no matter what
+	if n.Reason != models.ReasonKeyword && prefs.Mutes(n.Update, n.UserID, now) {
+		return false
+	}
+	n.Silent = prefs.Quiet(now)
+	return true
+}
+
+// WithSinks makes store deliver every notification to sinks as well.
+func (s *NotificationStore) WithSinks(sinks ...Sink) *NotificationStore {
+	s.sinks = append(s.sinks, sinks...)
+	return s
+}
+
+// WithCluster makes store forward notifications to listeners on other instances
+// and keep the cluster aware of listeners of this one.
+func (s *NotificationStore) WithCluster(c *Cluster) *NotificationStore {
+	s.cluster = c
+	return s.WithSinks(c)
+}
+
+// WithOverflowPolicy sets the policy applied to listeners which don't read fast enough.
+func (s *NotificationStore) WithOverflowPolicy(p OverflowPolicy) *NotificationStore {
+	s.overflow = p
+	return s
+}
+
+// Notify delivers n to all listeners of its user without blocking.
+// What happens if a listener buffer is full is defined by the listener overflow policy.
+func (s *NotificationStore) Notify(n models.Notification) {
+	if s.logger.IsLevelEnabled(logrus.InfoLevel) {
+		data, _ := json.Marshal(n.Update)
+		s.logger.
+			WithField("update", string(data)).
+			WithField("seq", n.Seq).
+			Infof("Notifying %s", n.UserID)
+	}
+	var lagging []*subscription
+	sh := s.shardOf(n.UserID)
+	sh.mu.RLock()
+	for _, sub := range sh.listeners.Get(n.UserID) {
+		if !sub.filter.Matches(n) {
+			continue
+		}
+		if !s.offer(sub, n) {
+			lagging = append(lagging, sub)
+		}
+	}
+	sh.mu.RUnlock()
+
+	for _, sub := range lagging {
+		s.logger.Warnf("Listener of %s is lagging. Disconnecting", n.UserID)
+		s.detach(n.UserID, sub, ErrListenerLagging)
+	}
+}
+
+// Broadcast delivers n to listeners of its user on this and other instances without blocking.
+// Unlike fanned out notifications, n doesn't go to other sinks, so it is meant for ones which
+// aren't stored, e.g. changes of unread counts.
+func (s *NotificationStore) Broadcast(ctx context.Context, n models.Notification) {
+	s.Notify(n)
+	if s.cluster != nil {
+		s.cluster.Deliver(ctx, n)
+	}
+}
+
+// Listening reports whether the user of n has a live listener receiving n on this or any other instance.
+// Filters of listeners on other instances aren't known, so only those without a filter count there.
+func (s *NotificationStore) Listening(n models.Notification) bool {
+	sh := s.shardOf(n.UserID)
+	sh.mu.RLock()
+	local := false
+	for _, sub := range sh.listeners.Get(n.UserID) {
+		if sub.filter.Matches(n) {
+			local = true
+			break
+		}
+	}
+	sh.mu.RUnlock()
+	if local || s.cluster == nil {
+		return local
+	}
+	return s.cluster.Online(n.UserID)
+}
+
+// Listeners returns the number of live listeners every one of userIDs has on this and
+// other instances. Users without listeners are omitted.
+func (s *NotificationStore) Listeners(ctx context.Context, userIDs []string) (map[string]int, error) {
+	counts := make(map[string]int, len(userIDs))
+	for _, userID := range userIDs {
+		sh := s.shardOf(userID)
+		sh.mu.RLock()
+		if n := len(sh.listeners.Get(userID)); n > 0 {
+			counts[userID] = n
+		}
+		sh.mu.RUnlock()
 	}
-	defer s.rm.RUnlock()
+	if s.cluster == nil {
+		return counts, nil
+	}
+	remote, err := s.cluster.Count(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	for userID, n := range remote {
+		counts[userID] += n
+	}
+	return counts, nil
 }
 
-func (s *NotificationStore) detach(listener *NotificationListener) {
-	s.rm.Lock()
-	defer s.rm.Unlock()
-	s.listeners.Remove(listener.UserID, listener.listener)
-	close(listener.listener)
-	s.logger.Infof("Listener of %s detached", listener.UserID)
+func (s *NotificationStore) detach(userID string, sub *subscription, reason error) {
+	sh := s.shardOf(userID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	s.detachLocked(sh, userID, sub, reason)
+}
+
+// detachLocked must be called with the lock of sh held
+func (s *NotificationStore) detachLocked(sh *shard, userID string, sub *subscription, reason error) {
+	if sub.closed {
+		return
+	}
+	sh.listeners.Remove(userID, sub)
+	if s.cluster != nil {
+		s.cluster.listenersChanged(userID, devicesOf(sh.listeners.Get(userID)))
+	}
+	signalDevicesChanged(sh.listeners.Get(userID))
+	sub.closed = true
+	sub.err = reason
+	close(sub.ch)
+	s.logger.Infof("Listener of %s detached", userID)
 }
 
 func (s *NotificationStore) Run(ctx context.Context) error {
@@ -95,35 +385,158 @@ func (s *NotificationStore) Run(ctx context.Context) error {
 	return nil
 }
 
+// fanOutUpdates saves updates and hands their notifications to delivery workers of shards
 func (s *NotificationStore) fanOutUpdates(ctx context.Context, upds chan models.Update) {
+	for _, sh := range s.shards {
+		go s.deliverShard(ctx, sh)
+	}
 	for {
 		select {
 		case _ = <-ctx.Done():
-			break
+			return
 		case upd, ok := <-upds:
 			if !ok {
 				s.logger.Warn("Updates channel closed. Stop notifying clients")
-				break
+				return
+			}
+			var ack func()
+			if acked, isAcked := upd.(*ackedUpdate); isAcked {
+				upd, ack = acked.Update, acked.ack
 			}
 			s.logger.Infof("New updates for audience: %s", strings.Join(upd.GetAudience(), ","))
-			for _, dest := range upd.GetAudience() {
-				s.logger.Infof("Notifying %s", dest)
-				s.Notify(dest, upd)
+			s.remember(ctx, upd)
+			notifications, saved := s.save(ctx, upd)
+			if !saved {
+				// Update isn't acked, so it is consumed again after restart
+				return
+			}
+			s.Prioritize(ctx, notifications)
+			done := s.acks.push(len(notifications), ack)
+			for _, n := range notifications {
+				select {
+				case s.shardOf(n.UserID).queue <- delivery{n: n, done: done}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+// deliverShard delivers notifications of users of sh until ctx is done.
+// Notifications of a user are delivered in order they were fanned out.
+func (s *NotificationStore) deliverShard(ctx context.Context, sh *shard) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-sh.queue:
+			n := d.n
+			if s.Deliverable(ctx, &n) {
+				s.Notify(n)
+				for _, sink := range s.sinks {
+					sink.Deliver(ctx, n)
+				}
 			}
+			d.done()
+		}
+	}
+}
+
+// remember saves the author of upd if it is a message and members of its chat
+func (s *NotificationStore) remember(ctx context.Context, upd models.Update) {
+	if s.members != nil {
+		if err := s.members.Track(ctx, upd); err != nil {
+			s.logger.Errorf("can't track members of chat %s: %v", models.ChatOf(upd), err)
+		}
+	}
+	msg, ok := upd.(*models.MessageSent)
+	if !ok || s.authors == nil {
+		return
+	}
+	if err := s.authors.Remember(ctx, msg); err != nil {
+		s.logger.Errorf("can't remember author of message %s: %v", msg.MessageID, err)
+	}
+}
+
+// save stores upd in the inbox of its audience. Failed saves are retried with backoff,
+// which holds back consumption, until ctx is done. Then save returns false.
+// If there is no inbox or the update can't be stored at all,
+// notifications are returned without sequence numbers.
+func (s *NotificationStore) save(ctx context.Context, upd models.Update) ([]models.Notification, bool) {
+	for delay := s.retryDelay; s.inbox != nil; delay *= 2 {
+		if delay > maxSaveRetryDelay {
+			delay = maxSaveRetryDelay
 		}
+		notifications, err := s.inbox.Save(ctx, upd, upd.GetAudience())
+		if err == nil {
+			return notifications, true
+		}
+		if errors.Is(err, ErrUnknownUpdateKind) {
+			s.logger.Errorf("can't save update to inbox: %v", err)
+			break
+		}
+		inboxSaveFailures.Inc()
+		s.logger.Errorf("can't save update to inbox, retrying in %s: %v", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+
+	notifications := make([]models.Notification, 0, len(upd.GetAudience()))
+	for _, dest := range upd.GetAudience() {
+		notifications = append(notifications, models.Notification{
+			UserID:    dest,
+			Update:    upd,
+			CreatedAt: upd.GetTime(),
+		})
 	}
+	return notifications, true
 }
 
 // Listen returns a channel contains all notification connected to userID.
 func (s *NotificationStore) Listen(userID string) NotificationListener {
-	s.rm.Lock()
-	defer s.rm.Unlock()
-	listener := make(chan models.Update, readerBufferSize)
-	s.listeners.Put(userID, listener)
+	return s.ListenWith(userID, ListenOptions{})
+}
+
+// ListenWith is like Listen but allows to tune the listener.
+func (s *NotificationStore) ListenWith(userID string, opts ListenOptions) NotificationListener {
+	sh := s.shardOf(userID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sub := &subscription{
+		ch:      make(chan models.Notification, readerBufferSize),
+		behind:  make(chan struct{}, 1),
+		devices: make(chan struct{}, 1),
+		policy:  opts.Overflow,
+		filter:  opts.Filter,
+		device: models.ConnectedDevice{
+			ID:          opts.DeviceID,
+			Client:      opts.Client,
+			ConnectedAt: time.Now().UTC(),
+			Filtered:    !opts.Filter.Empty(),
+		},
+	}
+	if sub.policy == "" {
+		sub.policy = s.overflow
+	}
+	if opts.DeviceID != "" {
+		if previous := deviceListener(sh.listeners.Get(userID), opts.DeviceID); previous != nil {
+			s.logger.Infof("Device %s of %s reconnected. Detaching its previous listener", opts.DeviceID, userID)
+			s.detachLocked(sh, userID, previous, ErrDeviceReplaced)
+		}
+	}
+	signalDevicesChanged(sh.listeners.Get(userID))
+	sh.listeners.Put(userID, sub)
+	if s.cluster != nil {
+		s.cluster.listenersChanged(userID, devicesOf(sh.listeners.Get(userID)))
+	}
 	s.logger.Infof("Created listener for %s", userID)
 	return NotificationListener{
-		UserID:   userID,
-		store:    s,
-		listener: listener,
+		UserID: userID,
+		store:  s,
+		sub:    sub,
 	}
 }
@@ -67,10 +67,11 @@ func TestNotificationStore_Notify(t *testing.T) {
 		ReplyTo:     nil,
 		Attachments: make([]models.FileAttachment, 0),
 	}
-	store.Notify(userId, &expectedMsg)
+	store.Notify(models.Notification{UserID: userId, Update: &expectedMsg, Seq: 1})
 
-	msg := *ReadWithTimeout[models.Update](t, l.Notifications(), 1*time.Second, "should correctly read msg")
-	actualMsg := msg.(*models.MessageSent)
+	msg := *ReadWithTimeout[models.Notification](t, l.Notifications(), 1*time.Second, "should correctly read msg")
+	assert.Equal(t, int64(1), msg.Seq)
+	actualMsg := msg.Update.(*models.MessageSent)
 	assert.Equal(t, chatId, actualMsg.ChatID)
 	assert.Equal(t, "Hello, world!", actualMsg.Text)
 }
@@ -154,3 +155,31 @@ func TestNotificationStorage(t *testing.T) {
 	assert.Equal(t, "2", msg3.GetAudience()[0])
 	assert.Equal(t, "2", msg4.GetAudience()[0])
 }
+
+func TestNotificationStore_Prioritize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chatId := uuid.New().String()
+	meta := models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"burenotti", "alice"}}
+	question := &models.MessageSent{UpdateMeta: meta, MessageID: uuid.New().String(), ChatID: chatId, FromUser: "alice", Text: "any ideas?"}
+	answer := &models.MessageSent{UpdateMeta: meta, MessageID: uuid.New().String(), ChatID: chatId, FromUser: "burenotti", Text: "sure", ReplyTo: &question.MessageID}
+	mention := &models.MessageSent{UpdateMeta: meta, MessageID: uuid.New().String(), ChatID: chatId, FromUser: "alice", Text: "thanks @burenotti"}
+
+	cons := NewFakeConsumer()
+	cons.Fit(question, answer, mention)
+	store := NewNotificationStorage(logrus.New(), cons).WithAuthors(NewMemoryAuthors())
+	alice := store.Listen("alice")
+	burenotti := store.Listen("burenotti")
+	go store.Run(ctx)
+
+	for _, reason := range []models.Reason{models.ReasonNone, models.ReasonReply, models.ReasonNone} {
+		if n := ReadWithTimeout(t, alice.Notifications(), time.Second, "notification must be delivered"); n != nil {
+			assert.Equal(t, reason, n.Reason)
+		}
+	}
+	for _, reason := range []models.Reason{models.ReasonNone, models.ReasonNone, models.ReasonMention} {
+		if n := ReadWithTimeout(t, burenotti.Notifications(), time.Second, "notification must be delivered"); n != nil {
+			assert.Equal(t, reason, n.Reason)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/practice-sem-2/notification-service/internal/models"
+)
+
+// OverflowPolicy defines what happens to a notification when the buffer of a listener is full
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest discards the oldest buffered notification in favor of the new one
+	OverflowDropOldest OverflowPolicy = "drop_oldest"
+	// OverflowDropNewest discards the new notification
+	OverflowDropNewest OverflowPolicy = "drop_newest"
+	// OverflowDisconnect detaches the listener with ErrListenerLagging
+	OverflowDisconnect OverflowPolicy = "disconnect"
+	// OverflowSpill leaves the notification in the inbox and signals the listener to catch up from there
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// dropOldestAttempts limits retries when a concurrent sender takes the freed slot
+const dropOldestAttempts = 3
+
+var (
+	ErrListenerLagging       = errors.New("listener is lagging")
+	ErrUnknownOverflowPolicy = errors.New("unknown overflow policy")
+)
+
+func ParseOverflowPolicy(policy string) (OverflowPolicy, error) {
+	switch p := OverflowPolicy(policy); p {
+	case OverflowDropOldest, OverflowDropNewest, OverflowDisconnect, OverflowSpill:
+		return p, nil
+	}
+	return "", fmt.Errorf("%w: %s", ErrUnknownOverflowPolicy, policy)
+}
+
+// offer delivers n to sub without blocking. It returns false if sub must be disconnected.
+// Must be called with at least read lock of the shard of sub held.
+func (s *NotificationStore) offer(sub *subscription, n models.Notification) bool {
+	// Once a listener spilled, stored notifications wait in the inbox until it catches up.
+	// Otherwise a later one could take a freed slot and be sent before the spilled ones.
+	if n.Seq != 0 && sub.spilling.Load() {
+		s.signalBehind(sub)
+		return true
+	}
+	select {
+	case sub.ch <- n:
+		return true
+	default:
+	}
+
+	listenerOverflows.WithLabelValues(string(sub.policy)).Inc()
+	switch sub.policy {
+	case OverflowDropOldest:
+		for i := 0; i < dropOldestAttempts; i++ {
+			select {
+			case <-sub.ch:
+			default:
+			}
+			select {
+			case sub.ch <- n:
+				return true
+			default:
+			}
+		}
+		return true
+	case OverflowDisconnect:
+		return false
+	case OverflowSpill:
+		if n.Seq == 0 {
+			s.logger.Warnf("Notification for %s is not stored and can't be spilled. Dropping", n.UserID)
+			return true
+		}
+		sub.spilling.Store(true)
+		s.signalBehind(sub)
+		return true
+	}
+	return true
+}
+
+func (s *NotificationStore) signalBehind(sub *subscription) {
+	select {
+	case sub.behind <- struct{}{}:
+	default:
+	}
+}
+
+// signalDevicesChanged tells subs that devices of their user changed
+func signalDevicesChanged(subs []*subscription) {
+	for _, sub := range subs {
+		select {
+		case sub.devices <- struct{}{}:
+		default:
+		}
+	}
+}
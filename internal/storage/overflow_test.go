@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func fillListener(store *NotificationStore, userID string, count int) {
+	for i := 1; i <= count; i++ {
+		store.Notify(models.Notification{
+			Update: &models.ChatDeleted{},
+			UserID: userID,
+			Seq:    int64(i),
+		})
+	}
+}
+
+func readSeqs(l NotificationListener) []int64 {
+	var seqs []int64
+	for {
+		select {
+		case n, ok := <-l.Notifications():
+			if !ok {
+				return seqs
+			}
+			seqs = append(seqs, n.Seq)
+		default:
+			return seqs
+		}
+	}
+}
+
+func TestNotificationStore_NotifyDoesNotBlock(t *testing.T) {
+	store := NewNotificationStorage(logrus.New())
+	l := store.Listen("stalled")
+	defer l.Detach()
+
+	done := make(chan struct{})
+	go func() {
+		fillListener(store, "stalled", readerBufferSize*2)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(1 * time.Second):
+		assert.Fail(t, "notify must not block on a stalled listener")
+	}
+}
+
+func TestOverflowPolicies(t *testing.T) {
+	const userID = "slowpoke"
+	total := readerBufferSize + 2
+
+	t.Run("drop oldest", func(t *testing.T) {
+		store := NewNotificationStorage(logrus.New())
+		l := store.ListenWith(userID, ListenOptions{Overflow: OverflowDropOldest})
+		defer l.Detach()
+		fillListener(store, userID, total)
+
+		seqs := readSeqs(l)
+		assert.Len(t, seqs, readerBufferSize)
+		assert.Equal(t, int64(3), seqs[0])
+		assert.Equal(t, int64(total), seqs[len(seqs)-1])
+	})
+
+	t.Run("drop newest", func(t *testing.T) {
+		store := NewNotificationStorage(logrus.New()).WithOverflowPolicy(OverflowDropNewest)
+		l := store.Listen(userID)
+		defer l.Detach()
+		fillListener(store, userID, total)
+
+		seqs := readSeqs(l)
+		assert.Len(t, seqs, readerBufferSize)
+		assert.Equal(t, int64(1), seqs[0])
+		assert.Equal(t, int64(readerBufferSize), seqs[len(seqs)-1])
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		store := NewNotificationStorage(logrus.New())
+		l := store.ListenWith(userID, ListenOptions{Overflow: OverflowDisconnect})
+		other := store.Listen(userID)
+		defer l.Detach()
+		defer other.Detach()
+		fillListener(store, userID, readerBufferSize+1)
+
+		seqs := readSeqs(l)
+		assert.Len(t, seqs, readerBufferSize)
+		_, ok := <-l.Notifications()
+		assert.False(t, ok, "lagging listener must be closed")
+		assert.ErrorIs(t, l.Err(), ErrListenerLagging)
+		assert.NoError(t, other.Err(), "other listeners must stay attached")
+	})
+
+	t.Run("spill", func(t *testing.T) {
+		store := NewNotificationStorage(logrus.New()).WithOverflowPolicy(OverflowSpill)
+		l := store.Listen(userID)
+		defer l.Detach()
+		fillListener(store, userID, total)
+
+		select {
+		case <-l.Behind():
+		default:
+			assert.Fail(t, "listener must be signalled to catch up")
+		}
+		assert.Len(t, readSeqs(l), readerBufferSize)
+	})
+}
+
+func TestParseOverflowPolicy(t *testing.T) {
+	p, err := ParseOverflowPolicy("spill")
+	assert.NoError(t, err)
+	assert.Equal(t, OverflowSpill, p)
+
+	_, err = ParseOverflowPolicy("ignore")
+	assert.ErrorIs(t, err, ErrUnknownOverflowPolicy)
+}
+
+func TestOverflowSpill_KeepsOrderWhileCatchingUp(t *testing.T) {
+	ctx := context.Background()
+	const userID = "slowpoke"
+	inbox := NewMemoryInbox()
+	store := NewNotificationStorage(logrus.New()).WithInbox(inbox).WithOverflowPolicy(OverflowSpill)
+	l := store.Listen(userID)
+	defer l.Detach()
+	notify := func() {
+		saved, err := inbox.Save(ctx, &models.ChatDeleted{}, []string{userID})
+		assert.NoError(t, err)
+		store.Notify(saved[0])
+	}
+
+	for i := 0; i < readerBufferSize+2; i++ {
+		notify()
+	}
+	// A slot is freed before the listener notices it is behind
+	var received []int64
+	received = append(received, (<-l.Notifications()).Seq)
+	notify()
+	assert.Len(t, l.Notifications(), readerBufferSize-1, "live notification must not overtake spilled ones")
+
+	// Catching up as streams do: drain the buffer, then replay the inbox
+	<-l.Behind()
+	received = append(received, readSeqs(l)...)
+	l.CaughtUp()
+	spilled, err := inbox.Since(ctx, userID, received[len(received)-1], 100)
+	assert.NoError(t, err)
+	for _, n := range spilled {
+		received = append(received, n.Seq)
+	}
+	notify()
+	received = append(received, readSeqs(l)...)
+
+	expected := make([]int64, readerBufferSize+4)
+	for i := range expected {
+		expected[i] = int64(i + 1)
+	}
+	assert.Equal(t, expected, received, "every notification must be received once and in order")
+}
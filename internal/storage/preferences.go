@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"sync"
+	"time"
+)
+
+// PreferencesStore keeps notification preferences of users.
+// Users without stored preferences get everything.
+type PreferencesStore interface {
+	Get(ctx context.Context, userID string) (models.Preferences, error)
+
+	// Update atomically applies fn to preferences of userID and returns the result
+	Update(ctx context.Context, userID string, fn func(*models.Preferences) error) (models.Preferences, error)
+}
+
+type PostgresPreferences struct {
+	db *sql.DB
+}
+
+func NewPostgresPreferences(db *sql.DB) *PostgresPreferences {
+	return &PostgresPreferences{
+		db: db,
+	}
+}
+
+func (p *PostgresPreferences) Get(ctx context.Context, userID string) (models.Preferences, error) {
+	var data []byte
+	err := p.db.QueryRowContext(ctx,
+		`SELECT data FROM user_preferences WHERE user_id = $1`, userID,
+	).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Preferences{}, nil
+	}
+	if err != nil {
+		return models.Preferences{}, err
+	}
+
+	var prefs models.Preferences
+	err = json.Unmarshal(data, &prefs)
+	return prefs, err
+}
+
+func (p *PostgresPreferences) Update(
+	ctx context.Context,
+	userID string,
+	fn func(*models.Preferences) error,
+) (models.Preferences, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Preferences{}, err
+	}
+	defer tx.Rollback()
+
+	// Row is created first, so concurrent updates of a new user are serialized too
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_preferences (user_id, data)
+		VALUES ($1, '{}')
+		ON CONFLICT (user_id) DO NOTHING`, userID)
+	if err != nil {
+		return models.Preferences{}, err
+	}
+
+	var data []byte
+	err = tx.QueryRowContext(ctx,
+		`SELECT data FROM user_preferences WHERE user_id = $1 FOR UPDATE`, userID,
+	).Scan(&data)
+	if err != nil {
+		return models.Preferences{}, err
+	}
+
+	var prefs models.Preferences
+	if err := json.Unmarshal(data, &prefs); err != nil {
+		return models.Preferences{}, err
+	}
+	if err := fn(&prefs); err != nil {
+		return models.Preferences{}, err
+	}
+
+	data, err = json.Marshal(prefs)
+	if err != nil {
+		return models.Preferences{}, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE user_preferences SET data = $2, updated_at = now() WHERE user_id = $1`, userID, data)
+	if err != nil {
+		return models.Preferences{}, err
+	}
+	return prefs, tx.Commit()
+}
+
+// MemoryPreferences keeps preferences in process memory. It is meant for tests.
+type MemoryPreferences struct {
+	mu    sync.Mutex
+	prefs map[string][]byte
+}
+
+func NewMemoryPreferences() *MemoryPreferences {
+	return &MemoryPreferences{
+		prefs: make(map[string][]byte),
+	}
+}
+
+func (p *MemoryPreferences) Get(_ context.Context, userID string) (models.Preferences, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.get(userID)
+}
+
+func (p *MemoryPreferences) Update(
+	_ context.Context,
+	userID string,
+	fn func(*models.Preferences) error,
+) (models.Preferences, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	prefs, err := p.get(userID)
+	if err != nil {
+		return models.Preferences{}, err
+	}
+	if err := fn(&prefs); err != nil {
+		return models.Preferences{}, err
+	}
+	// Preferences are kept serialized so callers can't modify stored ones
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return models.Preferences{}, err
+	}
+	p.prefs[userID] = data
+	return prefs, nil
+}
+
+func (p *MemoryPreferences) get(userID string) (models.Preferences, error) {
+	var prefs models.Preferences
+	data, ok := p.prefs[userID]
+	if !ok {
+		return prefs, nil
+	}
+	err := json.Unmarshal(data, &prefs)
+	return prefs, err
+}
+
+type cachedPreferences struct {
+	prefs     models.Preferences
+	expiresAt time.Time
+}
+
+// CachedPreferences keeps preferences read from the underlying store for ttl.
+// Updates made through it invalidate the cache at once, others are seen after ttl.
+type CachedPreferences struct {
+	store PreferencesStore
+	ttl   time.Duration
+	mu    sync.RWMutex
+	cache map[string]cachedPreferences
+}
+
+func NewCachedPreferences(store PreferencesStore, ttl time.Duration) *CachedPreferences {
+	return &CachedPreferences{
+		store: store,
+		ttl:   ttl,
+		cache: make(map[string]cachedPreferences),
+	}
+}
+
+func (p *CachedPreferences) Get(ctx context.Context, userID string) (models.Preferences, error) {
+	now := time.Now()
+	p.mu.RLock()
+	cached, ok := p.cache[userID]
+	p.mu.RUnlock()
+	if ok && now.Before(cached.expiresAt) {
+		return cached.prefs, nil
+	}
+
+	prefs, err := p.store.Get(ctx, userID)
+	if err != nil {
+		return prefs, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cache[userID] = cachedPreferences{prefs: prefs, expiresAt: now.Add(p.ttl)}
+	p.evictExpired(now)
+	return prefs, nil
+}
+
+func (p *CachedPreferences) Update(
+	ctx context.Context,
+	userID string,
+	fn func(*models.Preferences) error,
+) (models.Preferences, error) {
+	prefs, err := p.store.Update(ctx, userID, fn)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.cache, userID)
+	return prefs, err
+}
+
+// evictExpired drops expired entries once the cache grew big. Must be called with lock held.
+func (p *CachedPreferences) evictExpired(now time.Time) {
+	const evictionThreshold = 10_000
+	if len(p.cache) < evictionThreshold {
+		return
+	}
+	for userID, cached := range p.cache {
+		if !now.Before(cached.expiresAt) {
+			delete(p.cache, userID)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNotificationStore_MutedChat(t *testing.T) {
+	ctx := context.Background()
+	const userId = "burenotti"
+	mutedChat, otherChat := uuid.New().String(), uuid.New().String()
+
+	prefs := NewMemoryPreferences()
+	_, err := prefs.Update(ctx, userId, func(p *models.Preferences) error {
+		p.ChatMutes = map[string]models.ChatMute{mutedChat: {ExceptMentions: true}}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	inbox := NewMemoryInbox()
+	cons := NewFakeConsumer()
+	store := NewNotificationStorage(logrus.New(), cons).
+		WithInbox(inbox).
+		WithPreferences(prefs)
+	l := store.Listen(userId)
+	defer l.Detach()
+
+	meta := models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{userId}}
+	cons.Fit(
+		&models.MessageSent{UpdateMeta: meta, MessageID: uuid.New().String(), ChatID: mutedChat, Text: "hi all"},
+		&models.MessageSent{UpdateMeta: meta, MessageID: uuid.New().String(), ChatID: mutedChat, Text: "@burenotti look"},
+		&models.MessageSent{UpdateMeta: meta, MessageID: uuid.New().String(), ChatID: otherChat, Text: "hi"},
+		&models.ChatDeleted{UpdateMeta: meta, ChatID: mutedChat},
+	)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go store.Run(ctx)
+
+	for _, seq := range []int64{2, 3, 4} {
+		n := ReadWithTimeout(t, l.Notifications(), time.Second, "notification must be delivered")
+		if n != nil {
+			assert.Equal(t, seq, n.Seq, "only the muted message must be skipped")
+		}
+	}
+	assert.Len(t, inbox.Notifications(userId), 4, "muted notifications must still be stored")
+}
+
+func TestPreferences_Mutes(t *testing.T) {
+	now := time.Date(2023, 04, 15, 20, 0, 0, 0, time.UTC)
+	expired := now.Add(-time.Minute)
+	chatId := uuid.New().String()
+	msg := func(text string) *models.MessageSent {
+		return &models.MessageSent{ChatID: chatId, Text: text}
+	}
+
+	tests := []struct {
+		name  string
+		prefs models.Preferences
+		upd   models.Update
+		muted bool
+	}{
+		{"no preferences", models.Preferences{}, msg("hi"), false},
+		{"muted chat", models.Preferences{ChatMutes: map[string]models.ChatMute{chatId: {}}}, msg("hi"), true},
+		{"expired mute", models.Preferences{ChatMutes: map[string]models.ChatMute{chatId: {Until: &expired}}}, msg("hi"), false},
+		{"mention", models.Preferences{ChatMutes: map[string]models.ChatMute{chatId: {ExceptMentions: true}}}, msg("hey @burenotti!"), false},
+		{"longer username", models.Preferences{ChatMutes: map[string]models.ChatMute{chatId: {ExceptMentions: true}}}, msg("@burenotti_2"), true},
+		{"dotted username", models.Preferences{ChatMutes: map[string]models.ChatMute{chatId: {ExceptMentions: true}}}, msg("@burenotti.smith"), true},
+		{"mention ending sentence", models.Preferences{ChatMutes: map[string]models.ChatMute{chatId: {ExceptMentions: true}}}, msg("thanks @burenotti."), false},
+		{"mention before dash", models.Preferences{ChatMutes: map[string]models.ChatMute{chatId: {ExceptMentions: true}}}, msg("@burenotti - look"), false},
+		{"glued to cyrillic word", models.Preferences{ChatMutes: map[string]models.ChatMute{chatId: {ExceptMentions: true}}}, msg("привет@burenotti"), true},
+		{"after cyrillic word", models.Preferences{ChatMutes: map[string]models.ChatMute{chatId: {ExceptMentions: true}}}, msg("привет @burenotti"), false},
+		{"chat update", models.Preferences{ChatMutes: map[string]models.ChatMute{chatId: {}}}, &models.ChatDeleted{ChatID: chatId}, false},
+		{"muted kind", models.Preferences{MutedKinds: []models.UpdateKind{models.KindChatDeleted}}, &models.ChatDeleted{ChatID: chatId}, true},
+		{"mention bypasses mute", models.Preferences{ChatMutes: map[string]models.ChatMute{chatId: {}}, MentionsBypassMutes: true}, msg("@burenotti"), false},
+		{"mention bypasses muted kind", models.Preferences{MutedKinds: []models.UpdateKind{models.KindMessageSent}, MentionsBypassMutes: true}, msg("@burenotti"), false},
+		{"no bypass without mention", models.Preferences{ChatMutes: map[string]models.ChatMute{chatId: {}}, MentionsBypassMutes: true}, msg("hi"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.muted, tt.prefs.Mutes(tt.upd, "burenotti", now))
+		})
+	}
+}
+
+func TestCachedPreferences_Update(t *testing.T) {
+	ctx := context.Background()
+	backend := NewMemoryPreferences()
+	cached := NewCachedPreferences(backend, time.Hour)
+
+	prefs, err := cached.Get(ctx, "burenotti")
+	assert.NoError(t, err)
+	assert.Empty(t, prefs.MutedKinds)
+
+	_, err = cached.Update(ctx, "burenotti", func(p *models.Preferences) error {
+		p.MutedKinds = []models.UpdateKind{models.KindMemberAdded}
+		return nil
+	})
+	assert.NoError(t, err)
+
+	prefs, err = cached.Get(ctx, "burenotti")
+	assert.NoError(t, err)
+	assert.Equal(t, []models.UpdateKind{models.KindMemberAdded}, prefs.MutedKinds, "update must invalidate the cache")
+}
+
+func TestNotificationStore_QuietHours(t *testing.T) {
+	ctx := context.Background()
+	const userId = "burenotti"
+	now := time.Now()
+	start := models.TimeOfDay(now.UTC().Hour()*60 + now.UTC().Minute())
+
+	prefs := NewMemoryPreferences()
+	store := NewNotificationStorage(logrus.New()).WithPreferences(prefs)
+	n := models.Notification{UserID: userId, Update: &models.ChatDeleted{ChatID: uuid.New().String()}}
+	assert.True(t, store.Deliverable(ctx, &n))
+	assert.False(t, n.Silent)
+
+	_, err := prefs.Update(ctx, userId, func(p *models.Preferences) error {
+		p.QuietHours = []models.QuietHours{{Start: start, End: (start + 60) % (24 * 60), TimeZone: "UTC"}}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.True(t, store.Deliverable(ctx, &n), "notifications must be delivered during quiet hours")
+	assert.True(t, n.Silent, "notifications must be silent during quiet hours")
+}
+
+func TestQuietHours_Contains(t *testing.T) {
+	weekdaysNight := models.QuietHours{
+		Start:    23 * 60,
+		End:      7 * 60,
+		TimeZone: "Europe/Moscow",
+		Weekdays: []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	}
+	assert.NoError(t, weekdaysNight.Validate())
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		now   time.Time
+		quiet bool
+	}{
+		// 2023-04-14 is Friday
+		{"friday evening", time.Date(2023, 04, 14, 22, 59, 0, 0, moscow), false},
+		{"friday night", time.Date(2023, 04, 14, 23, 0, 0, 0, moscow), true},
+		{"after friday midnight", time.Date(2023, 04, 15, 6, 59, 0, 0, moscow), true},
+		{"saturday morning", time.Date(2023, 04, 15, 7, 0, 0, 0, moscow), false},
+		{"saturday night", time.Date(2023, 04, 15, 23, 30, 0, 0, moscow), false},
+		{"after sunday midnight", time.Date(2023, 04, 17, 3, 0, 0, 0, moscow), false},
+		{"same moment in UTC", time.Date(2023, 04, 14, 20, 30, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.quiet, weekdaysNight.Contains(tt.now))
+		})
+	}
+
+	assert.ErrorIs(t, models.QuietHours{Start: 60, End: 60, TimeZone: "UTC"}.Validate(), models.ErrInvalidQuietHours)
+	assert.ErrorIs(t, models.QuietHours{Start: 60, End: 120, TimeZone: "Mars/Olympus"}.Validate(), models.ErrInvalidQuietHours)
+}
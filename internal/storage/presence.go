@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"sync"
+	"time"
+)
+
+// Presence maps users to instances holding their live listeners
+type Presence interface {
+	// Announce marks the instance alive for ttl. Users of instances which stop announcing
+	// themselves are considered offline. It reports whether the instance was registered
+	// anew, e.g. after it expired and was removed with its users.
+	Announce(ctx context.Context, inst models.Instance, ttl time.Duration) (bool, error)
+
+	// Join records that userID listens from the given devices on instanceID
+	Join(ctx context.Context, userID string, instanceID string, devices []models.ConnectedDevice) error
+
+	Leave(ctx context.Context, userID string, instanceID string) error
+
+	// Locate returns alive instances every one of userIDs listens on
+	Locate(ctx context.Context, userIDs []string) (map[string][]models.Instance, error)
+
+	// Count returns the number of listeners every one of userIDs has on alive instances except the given one
+	Count(ctx context.Context, userIDs []string, except string) (map[string]int, error)
+
+	// Devices returns devices userID listens from on alive instances except the given one
+	Devices(ctx context.Context, userID string, except string) ([]models.ConnectedDevice, error)
+}
+
+type PostgresPresence struct {
+	db *sql.DB
+}
+
+func NewPostgresPresence(db *sql.DB) *PostgresPresence {
+	return &PostgresPresence{
+		db: db,
+	}
+}
+
+func (p *PostgresPresence) Announce(ctx context.Context, inst models.Instance, ttl time.Duration) (bool, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Instances which died without leaving are removed with their users by the alive ones
+	_, err = tx.ExecContext(ctx, `DELETE FROM instances WHERE expires_at < now() AND id <> $1`, inst.ID)
+	if err != nil {
+		return false, err
+	}
+	res, err := tx.ExecContext(ctx, `
+		UPDATE instances
+		SET address = $2, expires_at = now() + $3 * INTERVAL '1 millisecond'
+		WHERE id = $1`, inst.ID, inst.Address, ttl.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if updated == 0 {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO instances (id, address, expires_at)
+			VALUES ($1, $2, now() + $3 * INTERVAL '1 millisecond')`, inst.ID, inst.Address, ttl.Milliseconds())
+		if err != nil {
+			return false, err
+		}
+	}
+	return updated == 0, tx.Commit()
+}
+
+func (p *PostgresPresence) Join(ctx context.Context, userID string, instanceID string, devices []models.ConnectedDevice) error {
+	data, err := json.Marshal(devices)
+	if err != nil {
+		return err
+	}
+	_, err = p.db.ExecContext(ctx, `
+		INSERT INTO presence (user_id, instance_id, listeners, devices)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, instance_id) DO UPDATE
+		SET listeners = excluded.listeners, devices = excluded.devices`, userID, instanceID, len(devices), data)
+	return err
+}
+
+func (p *PostgresPresence) Leave(ctx context.Context, userID string, instanceID string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM presence WHERE user_id = $1 AND instance_id = $2`, userID, instanceID)
+	return err
+}
+
+func (p *PostgresPresence) Locate(ctx context.Context, userIDs []string) (map[string][]models.Instance, error) {
+	users, err := json.Marshal(userIDs)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT presence.user_id, instances.id, instances.address
+		FROM presence
+		JOIN instances ON instances.id = presence.instance_id
+		WHERE presence.user_id IN (SELECT jsonb_array_elements_text($1::JSONB))
+		  AND instances.expires_at > now()`, string(users))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	located := make(map[string][]models.Instance)
+	for rows.Next() {
+		var userID string
+		var inst models.Instance
+		if err := rows.Scan(&userID, &inst.ID, &inst.Address); err != nil {
+			return nil, err
+		}
+		located[userID] = append(located[userID], inst)
+	}
+	return located, rows.Err()
+}
+
+func (p *PostgresPresence) Count(ctx context.Context, userIDs []string, except string) (map[string]int, error) {
+	users, err := json.Marshal(userIDs)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT presence.user_id, sum(presence.listeners)
+		FROM presence
+		JOIN instances ON instances.id = presence.instance_id
+		WHERE presence.user_id IN (SELECT jsonb_array_elements_text($1::JSONB))
+		  AND instances.expires_at > now()
+		  AND instances.id <> $2
+		GROUP BY presence.user_id`, string(users), except)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var userID string
+		var listeners int
+		if err := rows.Scan(&userID, &listeners); err != nil {
+			return nil, err
+		}
+		counts[userID] = listeners
+	}
+	return counts, rows.Err()
+}
+
+func (p *PostgresPresence) Devices(ctx context.Context, userID string, except string) ([]models.ConnectedDevice, error) {
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT presence.devices
+		FROM presence
+		JOIN instances ON instances.id = presence.instance_id
+		WHERE presence.user_id = $1
+		  AND instances.expires_at > now()
+		  AND instances.id <> $2`, userID, except)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []models.ConnectedDevice
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var onInstance []models.ConnectedDevice
+		if err := json.Unmarshal(data, &onInstance); err != nil {
+			return nil, err
+		}
+		devices = append(devices, onInstance...)
+	}
+	return devices, rows.Err()
+}
+
+type memoryInstance struct {
+	models.Instance
+	expiresAt time.Time
+	users     map[string][]models.ConnectedDevice
+}
+
+// MemoryPresence keeps presence in process memory. It is meant for tests
+// running several instances in one process.
+type MemoryPresence struct {
+	mu        sync.Mutex
+	instances map[string]*memoryInstance
+}
+
+func NewMemoryPresence() *MemoryPresence {
+	return &MemoryPresence{
+		instances: make(map[string]*memoryInstance),
+	}
+}
+
+func (p *MemoryPresence) Announce(_ context.Context, inst models.Instance, ttl time.Duration) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for id, other := range p.instances {
+		if id != inst.ID && other.expiresAt.Before(now) {
+			delete(p.instances, id)
+		}
+	}
+	known, ok := p.instances[inst.ID]
+	if !ok {
+		known = &memoryInstance{users: make(map[string][]models.ConnectedDevice)}
+		p.instances[inst.ID] = known
+	}
+	known.Instance = inst
+	known.expiresAt = now.Add(ttl)
+	return !ok, nil
+}
+
+func (p *MemoryPresence) Join(_ context.Context, userID string, instanceID string, devices []models.ConnectedDevice) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if inst, ok := p.instances[instanceID]; ok {
+		inst.users[userID] = append([]models.ConnectedDevice(nil), devices...)
+	}
+	return nil
+}
+
+func (p *MemoryPresence) Leave(_ context.Context, userID string, instanceID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if inst, ok := p.instances[instanceID]; ok {
+		delete(inst.users, userID)
+	}
+	return nil
+}
+
+func (p *MemoryPresence) Locate(_ context.Context, userIDs []string) (map[string][]models.Instance, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	located := make(map[string][]models.Instance)
+	for _, inst := range p.instances {
+		if inst.expiresAt.Before(now) {
+			continue
+		}
+		for _, userID := range userIDs {
+			if len(inst.users[userID]) > 0 {
+				located[userID] = append(located[userID], inst.Instance)
+			}
+		}
+	}
+	return located, nil
+}
+
+func (p *MemoryPresence) Count(_ context.Context, userIDs []string, except string) (map[string]int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	counts := make(map[string]int)
+	for id, inst := range p.instances {
+		if id == except || inst.expiresAt.Before(now) {
+			continue
+		}
+		for _, userID := range userIDs {
+			if n := len(inst.users[userID]); n > 0 {
+				counts[userID] += n
+			}
+		}
+	}
+	return counts, nil
+}
+
+func (p *MemoryPresence) Devices(_ context.Context, userID string, except string) ([]models.ConnectedDevice, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var devices []models.ConnectedDevice
+	for id, inst := range p.instances {
+		if id == except || inst.expiresAt.Before(now) {
+			continue
+		}
+		devices = append(devices, inst.users[userID]...)
+	}
+	return devices, nil
+}
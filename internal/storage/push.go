@@ -0,0 +1,177 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"strconv"
+)
+
+var (
+	// ErrInvalidDeviceToken is returned by push providers when the token
+	// is expired or the app was removed. Such tokens are forgotten.
+	ErrInvalidDeviceToken = errors.New("device token is no longer valid")
+)
+
+const (
+	pushQueueSize = 1024
+	// pushBodyLength limits message text shown in a push
+	pushBodyLength = 200
+)
+
+// PushMessage is a platform independent push notification
+type PushMessage struct {
+	// Title and Body are shown to the user.
+	// A message without them wakes the app up in background instead.
+	Title string
+	Body  string
+	// Data is passed to the app as is
+	Data map[string]string
+}
+
+func (m PushMessage) Alert() bool {
+	return m.Title != "" || m.Body != ""
+}
+
+// PushProvider sends push notifications through a push service such as FCM or APNs
+type PushProvider interface {
+	Push(ctx context.Context, token string, msg PushMessage) error
+}
+
+// PushMessageOf builds a push for n. Messages alert the user,
+// other updates only let the app sync in background.
+func PushMessageOf(n models.Notification) PushMessage {
+	msg := PushMessage{
+		Data: map[string]string{
+			"id":      n.ID,
+			"seq":     strconv.FormatInt(n.Seq, 10),
+			"kind":    string(models.KindOf(n.Update)),
+			"chat_id": models.ChatOf(n.Update),
+		},
+	}
+	if sent, ok := n.Update.(*models.MessageSent); ok {
+		msg.Title = "New message"
+		msg.Body = truncate(sent.Text, pushBodyLength)
+		if msg.Body == "" && len(sent.Attachments) > 0 {
+			msg.Body = "Attachment"
+		}
+		// Coalesced burst shows the latest message as a preview
+		if n.Coalesced > 1 {
+			msg.Title = fmt.Sprintf("%d new messages", n.Coalesced)
+			msg.Data["count"] = strconv.Itoa(n.Coalesced)
+		}
+		switch n.Reason {
+		case models.ReasonMention:
+			msg.Title = fmt.Sprintf("%s mentioned you", sent.FromUser)
+		case models.ReasonReply:
+			msg.Title = fmt.Sprintf("%s replied to you", sent.FromUser)
+		case models.ReasonKeyword:
+			msg.Title = fmt.Sprintf("%s wrote about your keyword", sent.FromUser)
+		}
+		if n.Reason != models.ReasonNone {
+			msg.Data["reason"] = string(n.Reason)
+		}
+	}
+	return msg
+}
+
+func truncate(s string, length int) string {
+	runes := []rune(s)
+	if len(runes) <= length {
+		return s
+	}
+	return string(runes[:length-1]) + "…"
+}
+
+// PushNotifier sends pushes to devices of users who have no live listeners,
+// e.g. whose phones are in background
+type PushNotifier struct {
+	devices   DeviceStore
+	providers map[models.Platform]PushProvider
+	online    func(n models.Notification) bool
+	queue     chan models.Notification
+	workers   int
+	logger    *logrus.Logger
+}
+
+// NewPushNotifier creates a notifier which skips notifications for which online returns true,
+// i.e. their users get them on live listeners
+func NewPushNotifier(devices DeviceStore, online func(n models.Notification) bool, logger *logrus.Logger) *PushNotifier {
+	return &PushNotifier{
+		devices:   devices,
+		providers: make(map[models.Platform]PushProvider),
+		online:    online,
+		queue:     make(chan models.Notification, pushQueueSize),
+		workers:   8,
+		logger:    logger,
+	}
+}
+
+// WithProvider makes notifier send pushes to devices of platform through provider.
+// Devices of platforms without a provider are skipped.
+func (p *PushNotifier) WithProvider(platform models.Platform, provider PushProvider) *PushNotifier {
+	p.providers[platform] = provider
+	return p
+}
+
+// WithWorkers sets the number of concurrent pushes.
+func (p *PushNotifier) WithWorkers(n int) *PushNotifier {
+	p.workers = n
+	return p
+}
+
+// Deliver queues a push for n without blocking unless n came during quiet hours.
+// If the queue is full, n is dropped.
+func (p *PushNotifier) Deliver(_ context.Context, n models.Notification) {
+	if n.Silent {
+		return
+	}
+	select {
+	case p.queue <- n:
+	default:
+		pushNotifications.WithLabelValues("", "dropped").Inc()
+		p.logger.Warnf("Push queue is full. Dropping notification %d of %s", n.Seq, n.UserID)
+	}
+}
+
+// Run sends queued pushes until ctx is done
+func (p *PushNotifier) Run(ctx context.Context) error {
+	runWorkers(ctx, p.workers, p.queue, p.push)
+	return ctx.Err()
+}
+
+// push sends n to devices of its user unless the user is online. Whether the user
+// listens on other instances may take a lookup, so it is checked here rather than in Deliver.
+func (p *PushNotifier) push(ctx context.Context, n models.Notification) {
+	if p.online(n) {
+		return
+	}
+	devices, err := p.devices.List(ctx, n.UserID)
+	if err != nil {
+		p.logger.Errorf("can't get devices of %s: %v", n.UserID, err)
+		return
+	}
+	msg := PushMessageOf(n)
+	for _, d := range devices {
+		provider, ok := p.providers[d.Platform]
+		if !ok {
+			continue
+		}
+		err := provider.Push(ctx, d.Token, msg)
+		switch {
+		case err == nil:
+			pushNotifications.WithLabelValues(string(d.Platform), "ok").Inc()
+		case errors.Is(err, ErrInvalidDeviceToken):
+			pushNotifications.WithLabelValues(string(d.Platform), "invalid_token").Inc()
+			p.logger.Infof("Removing invalid %s device of %s", d.Platform, n.UserID)
+			if err := p.devices.Remove(ctx, d.Token); err != nil {
+				p.logger.Errorf("can't remove device of %s: %v", n.UserID, err)
+			}
+		default:
+			pushNotifications.WithLabelValues(string(d.Platform), "failed").Inc()
+			p.logger.Errorf("can't push to %s device of %s: %v", d.Platform, n.UserID, err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const DefaultAPNsEndpoint = "https://api.push.apple.com"
+
+// apnsTokenLifetime is less than an hour APNs accepts a provider token for
+const apnsTokenLifetime = 50 * time.Minute
+
+// APNsProvider sends pushes through Apple Push Notification service
+// authenticating with a token signed by a .p8 key
+type APNsProvider struct {
+	endpoint string
+	topic    string
+	key      *ecdsa.PrivateKey
+	keyID    string
+	teamID   string
+	client   *http.Client
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// NewAPNsProvider creates a provider sending pushes of the app with topic bundle id
+// to endpoint, which is DefaultAPNsEndpoint everywhere except tests
+func NewAPNsProvider(endpoint string, topic string, key *ecdsa.PrivateKey, keyID string, teamID string) *APNsProvider {
+	return &APNsProvider{
+		endpoint: endpoint,
+		topic:    topic,
+		key:      key,
+		keyID:    keyID,
+		teamID:   teamID,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// ParseAPNsKey parses a .p8 signing key
+func ParseAPNsKey(pem []byte) (*ecdsa.PrivateKey, error) {
+	return jwt.ParseECPrivateKeyFromPEM(pem)
+}
+
+type apnsAlert struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+type apnsAps struct {
+	Alert            *apnsAlert `json:"alert,omitempty"`
+	Sound            string     `json:"sound,omitempty"`
+	ContentAvailable int        `json:"content-available,omitempty"`
+}
+
+func (p *APNsProvider) Push(ctx context.Context, token string, msg PushMessage) error {
+	aps := apnsAps{ContentAvailable: 1}
+	pushType, priority := "background", "5"
+	if msg.Alert() {
+		aps = apnsAps{Alert: &apnsAlert{Title: msg.Title, Body: msg.Body}, Sound: "default"}
+		pushType, priority = "alert", "10"
+	}
+	// Custom data goes next to aps in the payload
+	payload := make(map[string]interface{}, len(msg.Data)+1)
+	for k, v := range msg.Data {
+		payload[k] = v
+	}
+	payload["aps"] = aps
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/3/device/"+token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	auth, err := p.providerToken()
+	if err != nil {
+		return fmt.Errorf("can't sign apns token: %w", err)
+	}
+	req.Header.Set("Authorization", "bearer "+auth)
+	req.Header.Set("apns-topic", p.topic)
+	req.Header.Set("apns-push-type", pushType)
+	req.Header.Set("apns-priority", priority)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	var apnsErr struct {
+		Reason string `json:"reason"`
+	}
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&apnsErr)
+	if resp.StatusCode == http.StatusGone ||
+		apnsErr.Reason == "BadDeviceToken" ||
+		apnsErr.Reason == "DeviceTokenNotForTopic" {
+		return ErrInvalidDeviceToken
+	}
+	return fmt.Errorf("apns responded with status %d: %s", resp.StatusCode, apnsErr.Reason)
+}
+
+// providerToken returns a cached token, because APNs rejects tokens renewed too often
+func (p *APNsProvider) providerToken() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if p.token != "" && now.Sub(p.issuedAt) < apnsTokenLifetime {
+		return p.token, nil
+	}
+	t := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": p.teamID,
+		"iat": now.Unix(),
+	})
+	t.Header["kid"] = p.keyID
+	signed, err := t.SignedString(p.key)
+	if err != nil {
+		return "", err
+	}
+	p.token, p.issuedAt = signed, now
+	return signed, nil
+}
@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/oauth2"
+	"io"
+	"net/http"
+	"time"
+)
+
+const DefaultFCMEndpoint = "https://fcm.googleapis.com"
+
+// FCMScope is the OAuth2 scope the FCM token source must be created with
+const FCMScope = "https://www.googleapis.com/auth/firebase.messaging"
+
+// FCMProvider sends pushes through Firebase Cloud Messaging HTTP v1 API
+type FCMProvider struct {
+	endpoint  string
+	projectID string
+	tokens    oauth2.TokenSource
+	client    *http.Client
+}
+
+// NewFCMProvider creates a provider sending pushes of projectID to endpoint,
+// which is DefaultFCMEndpoint everywhere except tests
+func NewFCMProvider(endpoint string, projectID string, tokens oauth2.TokenSource) *FCMProvider {
+	return &FCMProvider{
+		endpoint:  endpoint,
+		projectID: projectID,
+		tokens:    tokens,
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type fcmNotification struct {
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+}
+
+type fcmAndroidConfig struct {
+	Priority string `json:"priority"`
+}
+
+type fcmMessage struct {
+	Token        string            `json:"token"`
+	Notification *fcmNotification  `json:"notification,omitempty"`
+	Data         map[string]string `json:"data,omitempty"`
+	Android      fcmAndroidConfig  `json:"android"`
+}
+
+type fcmError struct {
+	Error struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Details []struct {
+			ErrorCode string `json:"errorCode"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+func (p *FCMProvider) Push(ctx context.Context, token string, msg PushMessage) error {
+	message := fcmMessage{
+		Token:   token,
+		Data:    msg.Data,
+		Android: fcmAndroidConfig{Priority: "normal"},
+	}
+	if msg.Alert() {
+		message.Notification = &fcmNotification{Title: msg.Title, Body: msg.Body}
+		message.Android.Priority = "high"
+	}
+	body, err := json.Marshal(map[string]fcmMessage{"message": message})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("%s/v1/projects/%s/messages:send", p.endpoint, p.projectID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	auth, err := p.tokens.Token()
+	if err != nil {
+		return fmt.Errorf("can't get fcm access token: %w", err)
+	}
+	auth.SetAuthHeader(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	var fcmErr fcmError
+	_ = json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&fcmErr)
+	for _, d := range fcmErr.Error.Details {
+		if d.ErrorCode == "UNREGISTERED" {
+			return ErrInvalidDeviceToken
+		}
+	}
+	return fmt.Errorf("fcm responded with status %d: %s", resp.StatusCode, fcmErr.Error.Message)
+}
@@ -0,0 +1,168 @@
+package storage
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type FakePushProvider struct {
+	pushes chan string
+	err    error
+}
+
+func (p *FakePushProvider) Push(_ context.Context, token string, _ PushMessage) error {
+	p.pushes <- token
+	return p.err
+}
+
+func TestPushNotifier_Deliver(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	devices := NewMemoryDevices()
+	_ = devices.Register(ctx, models.Device{Token: "phone", UserID: "burenotti", Platform: models.PlatformFCM})
+	_ = devices.Register(ctx, models.Device{Token: "stale", UserID: "burenotti", Platform: models.PlatformAPNs})
+
+	store := NewNotificationStorage(logrus.New())
+	fcm := &FakePushProvider{pushes: make(chan string, 16)}
+	apns := &FakePushProvider{pushes: make(chan string, 16), err: ErrInvalidDeviceToken}
+	notifier := NewPushNotifier(devices, store.Listening, logrus.New()).
+		WithProvider(models.PlatformFCM, fcm).
+		WithProvider(models.PlatformAPNs, apns)
+	go notifier.Run(ctx)
+
+	n := models.Notification{UserID: "burenotti", Seq: 1, Update: &models.MessageSent{Text: "hi"}}
+	l := store.Listen("burenotti")
+	notifier.Deliver(ctx, n)
+	// Whether the user is online is checked when the push is about to be sent
+	select {
+	case <-fcm.pushes:
+		assert.Fail(t, "online user must not be pushed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.Detach()
+	notifier.Deliver(ctx, models.Notification{UserID: "burenotti", Seq: 2, Silent: true, Update: n.Update})
+	notifier.Deliver(ctx, n)
+
+	token := ReadWithTimeout(t, fcm.pushes, time.Second, "offline user must get a push")
+	if token != nil {
+		assert.Equal(t, "phone", *token)
+	}
+	ReadWithTimeout(t, apns.pushes, time.Second, "offline user must get a push")
+	assert.Eventually(t, func() bool {
+		stored, _ := devices.List(ctx, "burenotti")
+		return len(stored) == 1
+	}, time.Second, 5*time.Millisecond, "invalid token must be removed")
+
+	select {
+	case <-fcm.pushes:
+		assert.Fail(t, "silent notifications must not be pushed")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestFCMProvider_Push(t *testing.T) {
+	var got map[string]fcmMessage
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/projects/chats/messages:send", r.URL.Path)
+		assert.Equal(t, "Bearer access", r.Header.Get("Authorization"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		switch got["message"].Token {
+		case "gone":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"status":"NOT_FOUND","details":[{"errorCode":"UNREGISTERED"}]}}`))
+		case "unknown":
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":{"status":"NOT_FOUND","message":"Requested entity was not found."}}`))
+		}
+	}))
+	defer fake.Close()
+	provider := NewFCMProvider(fake.URL, "chats", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access"}))
+
+	msg := PushMessageOf(models.Notification{ID: uuid.New().String(), Seq: 7, Update: &models.MessageSent{Text: "hi"}})
+	assert.NoError(t, provider.Push(context.Background(), "phone", msg))
+	assert.Equal(t, "phone", got["message"].Token)
+	assert.Equal(t, "hi", got["message"].Notification.Body)
+	assert.Equal(t, "7", got["message"].Data["seq"])
+	assert.Equal(t, "high", got["message"].Android.Priority)
+
+	err := provider.Push(context.Background(), "gone", msg)
+	assert.ErrorIs(t, err, ErrInvalidDeviceToken)
+
+	err = provider.Push(context.Background(), "unknown", msg)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalidDeviceToken, "404 without UNREGISTERED may come from a wrong project")
+}
+
+func TestPushNotifier_KeepsTokensOnNotFound(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	requests := make(chan struct{}, 16)
+	// A wrong project ID gets 404 for every token
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		requests <- struct{}{}
+	}))
+	defer fake.Close()
+
+	devices := NewMemoryDevices()
+	_ = devices.Register(ctx, models.Device{Token: "phone", UserID: "burenotti", Platform: models.PlatformFCM})
+	store := NewNotificationStorage(logrus.New())
+	provider := NewFCMProvider(fake.URL, "wrong", oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "access"}))
+	notifier := NewPushNotifier(devices, store.Listening, logrus.New()).
+		WithProvider(models.PlatformFCM, provider).
+		WithWorkers(1)
+	go notifier.Run(ctx)
+
+	notifier.Deliver(ctx, models.Notification{UserID: "burenotti", Seq: 1, Update: &models.MessageSent{Text: "hi"}})
+	notifier.Deliver(ctx, models.Notification{UserID: "burenotti", Seq: 2, Update: &models.MessageSent{Text: "hi"}})
+	ReadWithTimeout(t, requests, time.Second, "push must be sent")
+	// The second push is taken by the only worker after the first one is handled
+	ReadWithTimeout(t, requests, time.Second, "push must be sent")
+	stored, err := devices.List(ctx, "burenotti")
+	assert.NoError(t, err)
+	assert.Len(t, stored, 1, "token must be kept")
+}
+
+func TestAPNsProvider_Push(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	fake := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "bearer ")
+		parsed, err := jwt.Parse(token, func(parsed *jwt.Token) (interface{}, error) {
+			assert.Equal(t, "key", parsed.Header["kid"])
+			return &key.PublicKey, nil
+		})
+		assert.NoError(t, err)
+		assert.True(t, parsed.Valid)
+		assert.Equal(t, "com.example.chats", r.Header.Get("apns-topic"))
+		assert.Equal(t, "background", r.Header.Get("apns-push-type"))
+
+		var payload map[string]interface{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		assert.Equal(t, map[string]interface{}{"content-available": float64(1)}, payload["aps"])
+		if strings.HasSuffix(r.URL.Path, "/gone") {
+			w.WriteHeader(http.StatusGone)
+			_, _ = w.Write([]byte(`{"reason":"Unregistered"}`))
+		}
+	}))
+	defer fake.Close()
+	provider := NewAPNsProvider(fake.URL, "com.example.chats", key, "key", "team")
+
+	msg := PushMessageOf(models.Notification{Update: &models.ChatDeleted{ChatID: uuid.New().String()}})
+	assert.NoError(t, provider.Push(context.Background(), "phone", msg))
+	assert.ErrorIs(t, provider.Push(context.Background(), "gone", msg), ErrInvalidDeviceToken)
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// ReadCursors keep how far every device of a user has read notifications
+type ReadCursors interface {
+	// Advance moves the cursor of deviceID to seq unless it is already further
+	Advance(ctx context.Context, userID string, deviceID string, seq int64) error
+
+	// Get returns the cursor of deviceID or 0 if the device never read anything
+	Get(ctx context.Context, userID string, deviceID string) (int64, error)
+}
+
+type PostgresReadCursors struct {
+	db *sql.DB
+}
+
+func NewPostgresReadCursors(db *sql.DB) *PostgresReadCursors {
+	return &PostgresReadCursors{
+		db: db,
+	}
+}
+
+func (c *PostgresReadCursors) Advance(ctx context.Context, userID string, deviceID string, seq int64) error {
+	_, err := c.db.ExecContext(ctx, `
+		INSERT INTO read_cursors (user_id, device_id, seq)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, device_id) DO UPDATE
+		SET seq = greatest(read_cursors.seq, excluded.seq), updated_at = now()`, userID, deviceID, seq)
+	return err
+}
+
+func (c *PostgresReadCursors) Get(ctx context.Context, userID string, deviceID string) (int64, error) {
+	var seq int64
+	err := c.db.QueryRowContext(ctx,
+		`SELECT seq FROM read_cursors WHERE user_id = $1 AND device_id = $2`, userID, deviceID,
+	).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return seq, err
+}
+
+type cursorKey struct {
+	userID   string
+	deviceID string
+}
+
+// MemoryReadCursors keeps read cursors in process memory. It is meant for tests.
+type MemoryReadCursors struct {
+	mu      sync.Mutex
+	cursors map[cursorKey]int64
+}
+
+func NewMemoryReadCursors() *MemoryReadCursors {
+	return &MemoryReadCursors{
+		cursors: make(map[cursorKey]int64),
+	}
+}
+
+func (c *MemoryReadCursors) Advance(_ context.Context, userID string, deviceID string, seq int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cursorKey{userID: userID, deviceID: deviceID}
+	if seq > c.cursors[key] {
+		c.cursors[key] = seq
+	}
+	return nil
+}
+
+func (c *MemoryReadCursors) Get(_ context.Context, userID string, deviceID string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cursors[cursorKey{userID: userID, deviceID: deviceID}], nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/zyedidia/generic/multimap"
+	"hash/fnv"
+	"sync"
+)
+
+// shardQueueSize is how many notifications may wait for the worker of a shard
+const shardQueueSize = 1024
+
+// shard owns listeners of a part of users. Shards are guarded by their own locks
+// and delivered to by their own workers, so busy users don't slow down the others.
+type shard struct {
+	mu        sync.RWMutex
+	listeners multimap.MultiMap[string, *subscription]
+	queue     chan delivery
+}
+
+// delivery is a notification waiting for the worker of the shard of its user
+type delivery struct {
+	n    models.Notification
+	done func()
+}
+
+func newShards(n int) []*shard {
+	if n < 1 {
+		n = 1
+	}
+	shards := make([]*shard, n)
+	for i := range shards {
+		shards[i] = &shard{
+			listeners: multimap.NewMapSlice[string, *subscription](),
+			queue:     make(chan delivery, shardQueueSize),
+		}
+	}
+	return shards
+}
+
+func shardIndex(userID string, shards int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(userID))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// ackQueue acknowledges updates in order they were consumed, though shards may deliver
+// them out of order. Consumers commit offsets of acknowledged updates, so acknowledging
+// a later update first could make an earlier one lost on restart.
+type ackQueue struct {
+	mu      sync.Mutex
+	pending []*pendingAck
+}
+
+type pendingAck struct {
+	remaining int
+	ack       func()
+}
+
+// push queues ack of an update fanned out in the given number of deliveries.
+// The returned function must be called once every delivery is done.
+func (q *ackQueue) push(deliveries int, ack func()) func() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p := &pendingAck{remaining: deliveries, ack: ack}
+	q.pending = append(q.pending, p)
+	q.flush()
+	return func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		p.remaining--
+		q.flush()
+	}
+}
+
+func (q *ackQueue) flush() {
+	for len(q.pending) > 0 && q.pending[0].remaining <= 0 {
+		if q.pending[0].ack != nil {
+			q.pending[0].ack()
+		}
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+	}
+}
@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"context"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAckQueue_AcksInOrder(t *testing.T) {
+	var q ackQueue
+	var acked []int
+	first := q.push(2, func() { acked = append(acked, 1) })
+	second := q.push(1, func() { acked = append(acked, 2) })
+	q.push(0, func() { acked = append(acked, 3) })
+
+	second()
+	assert.Empty(t, acked, "update must not be acked before earlier ones")
+	first()
+	assert.Empty(t, acked)
+	first()
+	assert.Equal(t, []int{1, 2, 3}, acked)
+}
+
+func TestNotificationStore_Shards(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	users := []string{"burenotti", "alice", "bob", "carol", "dave"}
+
+	// Updates fit into listener buffers, so nothing is lost while other listeners are read
+	const updates = readerBufferSize - 1
+	cons := NewFakeConsumer()
+	for i := 0; i < updates; i++ {
+		cons.Fit(&models.ChatDeleted{
+			UpdateMeta: models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: users},
+			ChatID:     strconv.Itoa(i),
+		})
+	}
+	store := NewNotificationStorage(logrus.New(), cons).WithShards(3)
+	listeners := make([]NotificationListener, 0, len(users))
+	for _, userID := range users {
+		listeners = append(listeners, store.ListenWith(userID, ListenOptions{Overflow: OverflowDisconnect}))
+	}
+	go store.Run(ctx)
+
+	for _, l := range listeners {
+		for i := 0; i < updates; i++ {
+			n := ReadWithTimeout(t, l.Notifications(), time.Second, "notification must be delivered")
+			if n != nil {
+				assert.Equal(t, strconv.Itoa(i), models.ChatOf(n.Update), "notifications of a user must keep their order")
+			}
+		}
+	}
+}
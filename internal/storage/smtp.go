@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"time"
+)
+
+// Email is a message with plain text and HTML versions of the same content
+type Email struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, email Email) error
+}
+
+// SMTPMailer sends emails through an SMTP server. It upgrades
+// the connection with STARTTLS if the server supports it.
+type SMTPMailer struct {
+	addr    string
+	from    mail.Address
+	auth    smtp.Auth
+	timeout time.Duration
+}
+
+// NewSMTPMailer creates a mailer sending through the server at addr (host:port).
+// auth may be nil if the server doesn't require it.
+func NewSMTPMailer(addr string, from mail.Address, auth smtp.Auth) *SMTPMailer {
+	return &SMTPMailer{
+		addr:    addr,
+		from:    from,
+		auth:    auth,
+		timeout: 30 * time.Second,
+	}
+}
+
+// WithTimeout limits how long sending a single email may take.
+func (m *SMTPMailer) WithTimeout(timeout time.Duration) *SMTPMailer {
+	m.timeout = timeout
+	return m
+}
+
+// Send delivers email in a single SMTP session. The session is aborted
+// once ctx is done or the timeout of the mailer is exceeded.
+func (m *SMTPMailer) Send(ctx context.Context, email Email) error {
+	to, err := mail.ParseAddress(email.To)
+	if err != nil {
+		return err
+	}
+	msg, err := buildEmail(m.from, *to, email, time.Now())
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(m.addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	// Cancellation interrupts the session, which the deadline alone wouldn't do
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+	return m.send(c, host, to.Address, msg)
+}
+
+// send runs the session the way smtp.SendMail does
+func (m *SMTPMailer) send(c *smtp.Client, host string, to string, msg []byte) error {
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if m.auth != nil {
+		if ok, _ := c.Extension("AUTH"); !ok {
+			return errors.New("smtp server doesn't support AUTH")
+		}
+		if err := c.Auth(m.auth); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(m.from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// buildEmail renders email as a multipart/alternative MIME message
+func buildEmail(from mail.Address, to mail.Address, email Email, date time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	headers := []struct{ key, value string }{
+		{"From", from.String()},
+		{"To", to.String()},
+		{"Subject", mime.QEncoding.Encode("utf-8", email.Subject)},
+		{"Date", date.Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", body.Boundary())},
+	}
+	var msg bytes.Buffer
+	for _, h := range headers {
+		fmt.Fprintf(&msg, "%s: %s\r\n", h.key, h.value)
+	}
+	msg.WriteString("\r\n")
+
+	// The last alternative is the preferred one
+	parts := []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	}
+	for _, p := range parts {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {p.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(p.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	msg.Write(buf.Bytes())
+	return msg.Bytes(), nil
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"bufio"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+// FakeSMTP accepts a single session and passes received message data to messages
+type FakeSMTP struct {
+	ln       net.Listener
+	messages chan string
+}
+
+func NewFakeSMTP(t *testing.T) *FakeSMTP {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	f := &FakeSMTP{ln: ln, messages: make(chan string, 1)}
+	t.Cleanup(func() { _ = ln.Close() })
+	go f.serve()
+	return f
+}
+
+func (f *FakeSMTP) Addr() string {
+	return f.ln.Addr().String()
+}
+
+func (f *FakeSMTP) serve() {
+	conn, err := f.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			f.messages <- data.String()
+			reply("250 ok")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func TestSMTPMailer_Send(t *testing.T) {
+	fake := NewFakeSMTP(t)
+	mailer := NewSMTPMailer(fake.Addr(), mail.Address{Name: "Chats", Address: "noreply@example.com"}, nil)
+
+	err := mailer.Send(context.Background(), Email{
+		To:      "burenotti@example.com",
+		Subject: "3 unread messages",
+		Text:    "plain version",
+		HTML:    "<p>html version</p>",
+	})
+	assert.NoError(t, err)
+
+	data := ReadWithTimeout(t, fake.messages, time.Second, "message must be sent")
+	if data == nil {
+		return
+	}
+	msg, err := mail.ReadMessage(strings.NewReader(*data))
+	assert.NoError(t, err)
+	assert.Equal(t, "<burenotti@example.com>", msg.Header.Get("To"))
+	assert.Equal(t, `"Chats" <noreply@example.com>`, msg.Header.Get("From"))
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	var contents []string
+	for {
+		part, err := parts.NextPart()
+		if err != nil {
+			break
+		}
+		content, _ := io.ReadAll(part)
+		contents = append(contents, part.Header.Get("Content-Type")+": "+string(content))
+	}
+	assert.Equal(t, []string{
+		"text/plain; charset=utf-8: plain version",
+		"text/html; charset=utf-8: <p>html version</p>",
+	}, contents)
+}
+
+func TestSMTPMailer_SendTimeout(t *testing.T) {
+	// The server accepts connections but never greets
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			t.Cleanup(func() { _ = conn.Close() })
+		}
+	}()
+
+	mailer := NewSMTPMailer(ln.Addr().String(), mail.Address{Address: "noreply@example.com"}, nil).
+		WithTimeout(time.Minute)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = mailer.Send(ctx, Email{To: "burenotti@example.com", Subject: "digest"})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second, "sending must stop once ctx is done")
+}
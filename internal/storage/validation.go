@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-playground/validator/v10"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"strings"
+	"time"
+)
+
+// maxClockSkew is how far in the future an update may be timestamped
+const maxClockSkew = 5 * time.Minute
+
+var (
+	ErrInvalidUpdate = errors.New("invalid update")
+)
+
+// FieldError describes a single failed validation rule.
+type FieldError struct {
+	Field string
+	Rule  string
+	Param string
+}
+
+func (e FieldError) String() string {
+	if e.Param == "" {
+		return fmt.Sprintf("%s failed on '%s'", e.Field, e.Rule)
+	}
+	return fmt.Sprintf("%s failed on '%s=%s'", e.Field, e.Rule, e.Param)
+}
+
+// ValidationError lists all fields of an update that failed validation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		fields = append(fields, f.String())
+	}
+	return fmt.Sprintf("%v: %s", ErrInvalidUpdate, strings.Join(fields, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidUpdate
+}
+
+// UpdateValidator enforces validate tags of update models and checks
+// that the update has an audience and a sane timestamp.
+type UpdateValidator struct {
+	validate *validator.Validate
+	now      func() time.Time
+}
+
+func NewUpdateValidator() *UpdateValidator {
+	v := validator.New()
+	v.RegisterStructValidation(validateMessageSent, models.MessageSent{})
+	return &UpdateValidator{
+		validate: v,
+		now:      time.Now,
+	}
+}
+
+func (v *UpdateValidator) Validate(upd models.Update) error {
+	verr := &ValidationError{}
+
+	err := v.validate.Struct(upd)
+	var fieldErrs validator.ValidationErrors
+	if errors.As(err, &fieldErrs) {
+		for _, fe := range fieldErrs {
+			verr.Fields = append(verr.Fields, FieldError{
+				Field: fe.Namespace(),
+				Rule:  fe.Tag(),
+				Param: fe.Param(),
+			})
+		}
+	} else if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidUpdate, err)
+	}
+
+	if upd.GetTime().After(v.now().Add(maxClockSkew)) {
+		verr.Fields = append(verr.Fields, FieldError{
+			Field: "Timestamp",
+			Rule:  "not_in_future",
+		})
+	}
+
+	if len(verr.Fields) > 0 {
+		return verr
+	}
+	return nil
+}
+
+// validateMessageSent requires a message to have either text or attachments.
+// The required_without tag can't do it since attachments are never nil.
+func validateMessageSent(sl validator.StructLevel) {
+	msg := sl.Current().Interface().(models.MessageSent)
+	if msg.Text == "" && len(msg.Attachments) == 0 {
+		sl.ReportError(msg.Text, "Text", "Text", "required_without", "Attachments")
+	}
+}
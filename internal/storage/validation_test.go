@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestUpdateValidator_Validate(t *testing.T) {
+	now := time.Date(2023, 04, 15, 20, 0, 0, 0, time.UTC)
+	meta := models.UpdateMeta{
+		Timestamp: now,
+		Audience:  []string{"burenotti"},
+	}
+	validMessage := func() *models.MessageSent {
+		return &models.MessageSent{
+			UpdateMeta:  meta,
+			MessageID:   uuid.New().String(),
+			FromUser:    "burenotti",
+			ChatID:      uuid.New().String(),
+			Text:        "Hello, world!",
+			Attachments: make([]models.FileAttachment, 0),
+		}
+	}
+
+	cases := []struct {
+		name   string
+		update func() models.Update
+		fields []string
+	}{
+		{
+			name:   "valid message",
+			update: func() models.Update { return validMessage() },
+		},
+		{
+			name: "group chat created",
+			update: func() models.Update {
+				return &models.ChatCreated{UpdateMeta: meta, ChatID: uuid.New().String(), IsDirect: false}
+			},
+		},
+		{
+			name: "malformed ids",
+			update: func() models.Update {
+				msg := validMessage()
+				replyTo := "not-a-uuid"
+				msg.MessageID = "42"
+				msg.ReplyTo = &replyTo
+				msg.Attachments = append(msg.Attachments, models.FileAttachment{MimeType: "image/png", FileID: "file"})
+				return msg
+			},
+			fields: []string{"MessageSent.MessageID", "MessageSent.ReplyTo", "MessageSent.Attachments[0].FileID"},
+		},
+		{
+			name: "empty message",
+			update: func() models.Update {
+				msg := validMessage()
+				msg.Text = ""
+				return msg
+			},
+			fields: []string{"MessageSent.Text"},
+		},
+		{
+			name: "empty message without attachments",
+			update: func() models.Update {
+				msg := validMessage()
+				msg.Text = ""
+				msg.Attachments = nil
+				return msg
+			},
+			fields: []string{"MessageSent.Text"},
+		},
+		{
+			name: "no audience",
+			update: func() models.Update {
+				return &models.ChatDeleted{
+					UpdateMeta: models.UpdateMeta{Timestamp: now},
+					ChatID:     uuid.New().String(),
+				}
+			},
+			fields: []string{"ChatDeleted.UpdateMeta.Audience"},
+		},
+		{
+			name: "timestamp in future",
+			update: func() models.Update {
+				msg := validMessage()
+				msg.Timestamp = now.Add(time.Hour)
+				return msg
+			},
+			fields: []string{"Timestamp"},
+		},
+		{
+			name: "zero timestamp",
+			update: func() models.Update {
+				msg := validMessage()
+				msg.Timestamp = time.Time{}
+				return msg
+			},
+			fields: []string{"MessageSent.UpdateMeta.Timestamp"},
+		},
+	}
+
+	v := NewUpdateValidator()
+	v.now = func() time.Time { return now }
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := v.Validate(c.update())
+			if c.fields == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidUpdate)
+			verr, ok := err.(*ValidationError)
+			assert.True(t, ok, "validation error must carry field details")
+			actual := make([]string, 0, len(verr.Fields))
+			for _, f := range verr.Fields {
+				actual = append(actual, f.Field)
+			}
+			assert.ElementsMatch(t, c.fields, actual)
+		})
+	}
+}
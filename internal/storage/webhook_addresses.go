@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+var (
+	ErrForbiddenWebhookAddress = errors.New("webhook address is not public")
+)
+
+// WebhookAddresses decides which addresses webhooks may be posted to. Loopback, private,
+// link-local, multicast and unspecified addresses are forbidden unless allowed explicitly,
+// so users can't make the service reach internal hosts.
+type WebhookAddresses struct {
+	allowed  []*net.IPNet
+	resolver *net.Resolver
+}
+
+// NewWebhookAddresses creates a policy permitting public addresses and the allowed networks
+func NewWebhookAddresses(allowed ...*net.IPNet) *WebhookAddresses {
+	return &WebhookAddresses{
+		allowed:  allowed,
+		resolver: net.DefaultResolver,
+	}
+}
+
+// ParseNetworks parses networks in CIDR notation
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// Permits reports whether webhooks may be posted to ip
+func (a *WebhookAddresses) Permits(ip net.IP) bool {
+	for _, network := range a.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast())
+}
+
+// CheckHost fails if host doesn't resolve or any of its addresses isn't permitted
+func (a *WebhookAddresses) CheckHost(ctx context.Context, host string) error {
+	addrs, err := a.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if !a.Permits(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrForbiddenWebhookAddress, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// control is a net.Dialer hook checking the address actually dialed,
+// so hosts resolving to another address after registration are rejected too.
+func (a *WebhookAddresses) control(_ string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !a.Permits(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenWebhookAddress, host)
+	}
+	return nil
+}
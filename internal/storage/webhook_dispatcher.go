@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Headers of a webhook delivery
+const (
+	WebhookSignatureHeader = "X-Notification-Signature"
+	WebhookTimestampHeader = "X-Notification-Timestamp"
+	WebhookIDHeader        = "X-Notification-Id"
+)
+
+const (
+	webhookQueueSize = 1024
+	// maxWebhookRetries limits deliveries waiting for a retry
+	maxWebhookRetries = 4096
+)
+
+// SignWebhook returns the value of WebhookSignatureHeader: hex encoded HMAC-SHA256
+// of the timestamp, a dot and the body keyed with the webhook secret.
+// The timestamp is signed too, so receivers can reject replayed deliveries.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff defines how failed deliveries are retried
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	// Attempts is the number of tries including the first one
+	Attempts int
+}
+
+// Delay returns a randomized pause before the next try after attempt tries failed
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Initial
+	for i := 1; i < attempt && d < b.Max; i++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	// Jitter spreads retries of deliveries which failed at the same moment
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// WebhookDispatcher posts fanned out notifications to webhooks of their users.
+// Deliveries run on a pool of workers, so slow endpoints don't hold fan-out.
+// Only notifications of users having webhooks are queued. Owners are kept in memory
+// and reloaded from the store on changes and periodically, like keyword rules.
+// Failed deliveries wait for a retry in a delay queue rather than on a worker.
+type WebhookDispatcher struct {
+	hooks          WebhookStore
+	encode         func(models.Notification) ([]byte, error)
+	client         *http.Client
+	queue          chan models.Notification
+	retries        *delayQueue[webhookDelivery]
+	due            chan webhookDelivery
+	owners         atomic.Pointer[map[string]bool]
+	reloadInterval time.Duration
+	workers        int
+	backoff        Backoff
+	maxFailures    int
+	logger         *logrus.Logger
+}
+
+// webhookDelivery is a notification to be posted to a single webhook
+type webhookDelivery struct {
+	hook models.Webhook
+	n    models.Notification
+	body []byte
+	// attempt is the number of the next try
+	attempt int
+}
+
+// NewWebhookDispatcher creates a dispatcher posting notifications encoded with encode
+func NewWebhookDispatcher(
+	hooks WebhookStore,
+	encode func(models.Notification) ([]byte, error),
+	logger *logrus.Logger,
+) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		hooks:          hooks,
+		encode:         encode,
+		client:         newWebhookClient(NewWebhookAddresses()),
+		queue:          make(chan models.Notification, webhookQueueSize),
+		retries:        newDelayQueue[webhookDelivery](maxWebhookRetries),
+		due:            make(chan webhookDelivery),
+		reloadInterval: time.Minute,
+		workers:        8,
+		backoff:        Backoff{Initial: time.Second, Max: time.Minute, Attempts: 5},
+		maxFailures:    10,
+		logger:         logger,
+	}
+}
+
+// newWebhookClient creates a client which connects only to addresses permitted by addresses.
+// Proxies aren't used, since they would be dialed instead of webhooks.
+func newWebhookClient(addresses *WebhookAddresses) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: addresses.control,
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	}
+}
+
+// WithAddresses sets which addresses webhooks may be posted to. By default only public ones are.
+func (d *WebhookDispatcher) WithAddresses(addresses *WebhookAddresses) *WebhookDispatcher {
+	d.client = newWebhookClient(addresses)
+	return d
+}
+
+// WithBackoff sets how failed deliveries are retried.
+func (d *WebhookDispatcher) WithBackoff(b Backoff) *WebhookDispatcher {
+	d.backoff = b
+	return d
+}
+
+// WithMaxFailures sets the number of deliveries failed in a row which disables a webhook.
+func (d *WebhookDispatcher) WithMaxFailures(n int) *WebhookDispatcher {
+	d.maxFailures = n
+	return d
+}
+
+// WithWorkers sets the number of concurrent deliveries. Retries run on a pool of the same size.
+func (d *WebhookDispatcher) WithWorkers(n int) *WebhookDispatcher {
+	d.workers = n
+	return d
+}
+
+// WithReloadInterval sets how often owners of webhooks are reloaded from the store.
+func (d *WebhookDispatcher) WithReloadInterval(interval time.Duration) *WebhookDispatcher {
+	d.reloadInterval = interval
+	return d
+}
+
+// Reload refreshes the set of users having enabled webhooks
+func (d *WebhookDispatcher) Reload(ctx context.Context) error {
+	userIDs, err := d.hooks.Owners(ctx)
+	if err != nil {
+		return err
+	}
+	owners := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		owners[userID] = true
+	}
+	d.owners.Store(&owners)
+	return nil
+}
+
+// Deliver queues n for delivery without blocking. If the queue is full, n is dropped.
+// Notifications of users without webhooks are skipped once owners are loaded.
+func (d *WebhookDispatcher) Deliver(_ context.Context, n models.Notification) {
+	if owners := d.owners.Load(); owners != nil && !(*owners)[n.UserID] {
+		return
+	}
+	select {
+	case d.queue <- n:
+	default:
+		webhookDeliveries.WithLabelValues("dropped").Inc()
+		d.logger.Warnf("Webhook queue is full. Dropping notification %d of %s", n.Seq, n.UserID)
+	}
+}
+
+// Run delivers queued notifications and reloads owners of webhooks until ctx is done
+func (d *WebhookDispatcher) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		d.reloadOwners(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		d.retries.run(ctx, d.due)
+	}()
+	go func() {
+		defer wg.Done()
+		runWorkers(ctx, d.workers, d.due, d.attempt)
+	}()
+	runWorkers(ctx, d.workers, d.queue, d.dispatch)
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (d *WebhookDispatcher) reloadOwners(ctx context.Context) {
+	ticker := time.NewTicker(d.reloadInterval)
+	defer ticker.Stop()
+	for {
+		if err := d.Reload(ctx); err != nil {
+			d.logger.Errorf("can't reload owners of webhooks: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *WebhookDispatcher) dispatch(ctx context.Context, n models.Notification) {
+	hooks, err := d.hooks.List(ctx, n.UserID)
+	if err != nil {
+		d.logger.Errorf("can't get webhooks of %s: %v", n.UserID, err)
+		return
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	body, err := d.encode(n)
+	if err != nil {
+		d.logger.Errorf("can't encode notification %d of %s: %v", n.Seq, n.UserID, err)
+		return
+	}
+	for _, hook := range hooks {
+		if !hook.Disabled {
+			d.attempt(ctx, webhookDelivery{hook: hook, n: n, body: body, attempt: 1})
+		}
+	}
+}
+
+// attempt posts a delivery once. A failed delivery worth retrying is scheduled again after a backoff.
+func (d *WebhookDispatcher) attempt(ctx context.Context, delivery webhookDelivery) {
+	hook := delivery.hook
+	retry, err := d.post(ctx, hook, delivery.n, delivery.body)
+	if err == nil {
+		webhookDeliveries.WithLabelValues("ok").Inc()
+		if hook.Failures > 0 {
+			if err := d.hooks.RecordSuccess(ctx, hook.ID); err != nil {
+				d.logger.Errorf("can't reset failures of webhook %s: %v", hook.ID, err)
+			}
+		}
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+	d.logger.Infof("Delivery to webhook %s failed on attempt %d: %v", hook.ID, delivery.attempt, err)
+	if retry && delivery.attempt < d.backoff.Attempts {
+		at := time.Now().Add(d.backoff.Delay(delivery.attempt))
+		delivery.attempt++
+		if d.retries.push(delivery, at) {
+			return
+		}
+		d.logger.Warnf("Webhook retry queue is full. Giving up delivery to webhook %s", hook.ID)
+	}
+
+	webhookDeliveries.WithLabelValues("failed").Inc()
+	disabled, err := d.hooks.RecordFailure(ctx, hook.ID, d.maxFailures)
+	if err != nil {
+		d.logger.Errorf("can't record failure of webhook %s: %v", hook.ID, err)
+		return
+	}
+	if disabled {
+		d.logger.Warnf("Webhook %s of %s is disabled after %d failed deliveries", hook.ID, hook.UserID, d.maxFailures)
+	}
+}
+
+// post makes a single delivery attempt and reports whether it is worth retrying
+func (d *WebhookDispatcher) post(ctx context.Context, hook models.Webhook, n models.Notification, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookIDHeader, n.ID)
+	req.Header.Set(WebhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhook(hook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return !errors.Is(err, ErrForbiddenWebhookAddress), err
+	}
+	// Body is drained, so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 ||
+		resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("unexpected status %d", resp.StatusCode)
+}
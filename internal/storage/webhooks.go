@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"sync"
+)
+
+var (
+	ErrWebhookNotFound = errors.New("webhook not found")
+)
+
+// WebhookStore keeps webhooks registered by users
+type WebhookStore interface {
+	Create(ctx context.Context, hook models.Webhook) error
+
+	// List returns all webhooks of userID including disabled ones
+	List(ctx context.Context, userID string) ([]models.Webhook, error)
+
+	Delete(ctx context.Context, userID string, id string) error
+
+	// Enable turns a disabled webhook of userID back on and resets its failures
+	Enable(ctx context.Context, userID string, id string) error
+
+	// RecordSuccess resets failures of the webhook
+	RecordSuccess(ctx context.Context, id string) error
+
+	// RecordFailure counts a failed delivery and disables the webhook once
+	// maxFailures deliveries failed in a row. It reports whether the webhook is disabled.
+	RecordFailure(ctx context.Context, id string, maxFailures int) (bool, error)
+
+	// Owners returns ids of users having enabled webhooks
+	Owners(ctx context.Context) ([]string, error)
+}
+
+type PostgresWebhooks struct {
+	db *sql.DB
+}
+
+func NewPostgresWebhooks(db *sql.DB) *PostgresWebhooks {
+	return &PostgresWebhooks{
+		db: db,
+	}
+}
+
+func (w *PostgresWebhooks) Create(ctx context.Context, hook models.Webhook) error {
+	_, err := w.db.ExecContext(ctx, `
+		INSERT INTO webhooks (id, user_id, url, secret, created_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		hook.ID, hook.UserID, hook.URL, hook.Secret, hook.CreatedAt)
+	return err
+}
+
+func (w *PostgresWebhooks) List(ctx context.Context, userID string) ([]models.Webhook, error) {
+	rows, err := w.db.QueryContext(ctx, `
+		SELECT id, url, secret, failures, disabled_at IS NOT NULL, created_at
+		FROM webhooks
+		WHERE user_id = $1
+		ORDER BY created_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hooks []models.Webhook
+	for rows.Next() {
+		hook := models.Webhook{UserID: userID}
+		err := rows.Scan(&hook.ID, &hook.URL, &hook.Secret, &hook.Failures, &hook.Disabled, &hook.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, rows.Err()
+}
+
+func (w *PostgresWebhooks) Delete(ctx context.Context, userID string, id string) error {
+	res, err := w.db.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1 AND user_id = $2`, id, userID)
+	return affectedWebhook(res, err)
+}
+
+func (w *PostgresWebhooks) Enable(ctx context.Context, userID string, id string) error {
+	res, err := w.db.ExecContext(ctx, `
+		UPDATE webhooks
+		SET failures = 0, disabled_at = NULL
+		WHERE id = $1 AND user_id = $2`, id, userID)
+	return affectedWebhook(res, err)
+}
+
+func (w *PostgresWebhooks) RecordSuccess(ctx context.Context, id string) error {
+	_, err := w.db.ExecContext(ctx, `UPDATE webhooks SET failures = 0 WHERE id = $1`, id)
+	return err
+}
+
+func (w *PostgresWebhooks) RecordFailure(ctx context.Context, id string, maxFailures int) (bool, error) {
+	var disabled bool
+	err := w.db.QueryRowContext(ctx, `
+		UPDATE webhooks
+		SET failures    = failures + 1,
+		    disabled_at = CASE WHEN failures + 1 >= $2 THEN coalesce(disabled_at, now()) ELSE disabled_at END
+		WHERE id = $1
+		RETURNING disabled_at IS NOT NULL`, id, maxFailures).Scan(&disabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrWebhookNotFound
+	}
+	return disabled, err
+}
+
+func (w *PostgresWebhooks) Owners(ctx context.Context) ([]string, error) {
+	rows, err := w.db.QueryContext(ctx, `SELECT DISTINCT user_id FROM webhooks WHERE disabled_at IS NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var owners []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		owners = append(owners, userID)
+	}
+	return owners, rows.Err()
+}
+
+func affectedWebhook(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// MemoryWebhooks keeps webhooks in process memory. It is meant for tests.
+type MemoryWebhooks struct {
+	mu    sync.Mutex
+	hooks []models.Webhook
+}
+
+func NewMemoryWebhooks() *MemoryWebhooks {
+	return &MemoryWebhooks{}
+}
+
+func (w *MemoryWebhooks) Create(_ context.Context, hook models.Webhook) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.hooks = append(w.hooks, hook)
+	return nil
+}
+
+func (w *MemoryWebhooks) List(_ context.Context, userID string) ([]models.Webhook, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var hooks []models.Webhook
+	for _, hook := range w.hooks {
+		if hook.UserID == userID {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks, nil
+}
+
+func (w *MemoryWebhooks) Delete(_ context.Context, userID string, id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i, hook := range w.hooks {
+		if hook.ID == id && hook.UserID == userID {
+			w.hooks = append(w.hooks[:i], w.hooks[i+1:]...)
+			return nil
+		}
+	}
+	return ErrWebhookNotFound
+}
+
+func (w *MemoryWebhooks) Enable(_ context.Context, userID string, id string) error {
+	return w.update(func(hook *models.Webhook) bool {
+		if hook.ID != id || hook.UserID != userID {
+			return false
+		}
+		hook.Failures = 0
+		hook.Disabled = false
+		return true
+	})
+}
+
+func (w *MemoryWebhooks) RecordSuccess(_ context.Context, id string) error {
+	return w.update(func(hook *models.Webhook) bool {
+		if hook.ID != id {
+			return false
+		}
+		hook.Failures = 0
+		return true
+	})
+}
+
+func (w *MemoryWebhooks) RecordFailure(_ context.Context, id string, maxFailures int) (bool, error) {
+	var disabled bool
+	err := w.update(func(hook *models.Webhook) bool {
+		if hook.ID != id {
+			return false
+		}
+		hook.Failures++
+		hook.Disabled = hook.Disabled || hook.Failures >= maxFailures
+		disabled = hook.Disabled
+		return true
+	})
+	return disabled, err
+}
+
+func (w *MemoryWebhooks) Owners(_ context.Context) ([]string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	seen := make(map[string]bool)
+	var owners []string
+	for _, hook := range w.hooks {
+		if !hook.Disabled && !seen[hook.UserID] {
+			seen[hook.UserID] = true
+			owners = append(owners, hook.UserID)
+		}
+	}
+	return owners, nil
+}
+
+func (w *MemoryWebhooks) update(fn func(hook *models.Webhook) bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for i := range w.hooks {
+		if fn(&w.hooks[i]) {
+			return nil
+		}
+	}
+	return ErrWebhookNotFound
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type webhookReceiver struct {
+	*httptest.Server
+	secret     string
+	failFirst  int32
+	requests   int32
+	deliveries chan []byte
+}
+
+// newWebhookReceiver starts a receiver which fails the first failFirst requests
+func newWebhookReceiver(t *testing.T, secret string, failFirst int32) *webhookReceiver {
+	r := &webhookReceiver{secret: secret, failFirst: failFirst, deliveries: make(chan []byte, 16)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&r.requests, 1) <= r.failFirst {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		timestamp, err := strconv.ParseInt(req.Header.Get(WebhookTimestampHeader), 10, 64)
+		assert.NoError(t, err)
+		assert.Equal(t, SignWebhook(r.secret, timestamp, body), req.Header.Get(WebhookSignatureHeader))
+		r.deliveries <- body
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// loopback lets tests post to receivers started on localhost
+var loopback = &net.IPNet{IP: net.IPv4(127, 0, 0, 0), Mask: net.CIDRMask(8, 32)}
+
+func newTestWebhookDispatcher(hooks WebhookStore, maxFailures int) *WebhookDispatcher {
+	return NewWebhookDispatcher(hooks, func(n models.Notification) ([]byte, error) {
+		return json.Marshal(n.Update)
+	}, logrus.New()).
+		WithBackoff(Backoff{Initial: time.Millisecond, Max: 4 * time.Millisecond, Attempts: 3}).
+		WithMaxFailures(maxFailures).
+		WithWorkers(1).
+		WithAddresses(NewWebhookAddresses(loopback))
+}
+
+func runWebhookDispatcher(t *testing.T, d *WebhookDispatcher) *WebhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go d.Run(ctx)
+	return d
+}
+
+func TestWebhookDispatcher_Retry(t *testing.T) {
+	ctx := context.Background()
+	receiver := newWebhookReceiver(t, "secret", 2)
+	hooks := NewMemoryWebhooks()
+	hook := models.Webhook{ID: uuid.New().String(), UserID: "bot", URL: receiver.URL, Secret: "secret", Failures: 1}
+	_ = hooks.Create(ctx, hook)
+	d := runWebhookDispatcher(t, newTestWebhookDispatcher(hooks, 3))
+
+	chatId := uuid.New().String()
+	d.Deliver(ctx, models.Notification{UserID: "bot", Seq: 1, Update: &models.ChatDeleted{ChatID: chatId}})
+
+	body := ReadWithTimeout(t, receiver.deliveries, time.Second, "notification must be delivered after retries")
+	if body != nil {
+		var upd models.ChatDeleted
+		assert.NoError(t, json.Unmarshal(*body, &upd))
+		assert.Equal(t, chatId, upd.ChatID)
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&receiver.requests))
+	assert.Eventually(t, func() bool {
+		stored, _ := hooks.List(ctx, "bot")
+		return stored[0].Failures == 0
+	}, time.Second, 5*time.Millisecond, "successful delivery must reset failures")
+}
+
+func TestWebhookDispatcher_Disable(t *testing.T) {
+	ctx := context.Background()
+	receiver := newWebhookReceiver(t, "secret", 1000)
+	hooks := NewMemoryWebhooks()
+	_ = hooks.Create(ctx, models.Webhook{ID: uuid.New().String(), UserID: "bot", URL: receiver.URL, Secret: "secret"})
+	d := runWebhookDispatcher(t, newTestWebhookDispatcher(hooks, 2))
+
+	// Retries don't hold workers, so notifications are sent one by one to fail in order
+	for seq := int64(1); seq <= 2; seq++ {
+		d.Deliver(ctx, models.Notification{UserID: "bot", Seq: seq, Update: &models.ChatDeleted{}})
+		assert.Eventually(t, func() bool {
+			stored, _ := hooks.List(ctx, "bot")
+			return stored[0].Failures == int(seq)
+		}, time.Second, 5*time.Millisecond, "failed delivery must be recorded")
+	}
+	stored, _ := hooks.List(ctx, "bot")
+	assert.True(t, stored[0].Disabled, "webhook must be disabled after repeated failures")
+	d.Deliver(ctx, models.Notification{UserID: "bot", Seq: 3, Update: &models.ChatDeleted{}})
+
+	// Give the third notification time to be skipped
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(2*3), atomic.LoadInt32(&receiver.requests), "disabled webhook must not be called")
+}
+
+func TestWebhookDispatcher_RetriesDontBlockWorkers(t *testing.T) {
+	ctx := context.Background()
+	failing := newWebhookReceiver(t, "secret", 1000)
+	receiver := newWebhookReceiver(t, "secret", 0)
+	hooks := NewMemoryWebhooks()
+	_ = hooks.Create(ctx, models.Webhook{ID: uuid.New().String(), UserID: "alice", URL: failing.URL, Secret: "secret"})
+	_ = hooks.Create(ctx, models.Webhook{ID: uuid.New().String(), UserID: "bot", URL: receiver.URL, Secret: "secret"})
+	d := runWebhookDispatcher(t, newTestWebhookDispatcher(hooks, 10).
+		WithBackoff(Backoff{Initial: time.Minute, Max: time.Minute, Attempts: 3}))
+
+	d.Deliver(ctx, models.Notification{UserID: "alice", Seq: 1, Update: &models.ChatDeleted{}})
+	d.Deliver(ctx, models.Notification{UserID: "bot", Seq: 1, Update: &models.ChatDeleted{}})
+
+	ReadWithTimeout(t, receiver.deliveries, time.Second, "delivery must not wait for retries of another one")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&failing.requests))
+}
+
+func TestWebhookDispatcher_SkipsUsersWithoutWebhooks(t *testing.T) {
+	ctx := context.Background()
+	hooks := NewMemoryWebhooks()
+	_ = hooks.Create(ctx, models.Webhook{ID: uuid.New().String(), UserID: "bot", URL: "https://example.com", Secret: "secret"})
+	_ = hooks.Create(ctx, models.Webhook{ID: uuid.New().String(), UserID: "bob", URL: "https://example.com", Secret: "secret", Disabled: true})
+	d := newTestWebhookDispatcher(hooks, 10)
+	assert.NoError(t, d.Reload(ctx))
+
+	d.Deliver(ctx, models.Notification{UserID: "alice", Seq: 1, Update: &models.ChatDeleted{}})
+	d.Deliver(ctx, models.Notification{UserID: "bob", Seq: 1, Update: &models.ChatDeleted{}})
+	assert.Len(t, d.queue, 0, "notifications of users without enabled webhooks must not be queued")
+	d.Deliver(ctx, models.Notification{UserID: "bot", Seq: 1, Update: &models.ChatDeleted{}})
+	assert.Len(t, d.queue, 1)
+}
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"seq":"1"}`)
+	assert.Equal(t, SignWebhook("secret", 1681588800, body), SignWebhook("secret", 1681588800, body))
+	assert.NotEqual(t, SignWebhook("secret", 1681588800, body), SignWebhook("other", 1681588800, body))
+	assert.NotEqual(t, SignWebhook("secret", 1681588800, body), SignWebhook("secret", 1681588801, body))
+}
+
+func TestWebhookAddresses_Permits(t *testing.T) {
+	addresses := NewWebhookAddresses()
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0", "::1", "fe80::1", "fd00::1", "::ffff:127.0.0.1"} {
+		assert.False(t, addresses.Permits(net.ParseIP(ip)), "%s must be forbidden", ip)
+	}
+	for _, ip := range []string{"8.8.8.8", "2001:4860:4860::8888"} {
+		assert.True(t, addresses.Permits(net.ParseIP(ip)), "%s must be permitted", ip)
+	}
+	assert.True(t, NewWebhookAddresses(loopback).Permits(net.ParseIP("127.0.0.1")), "allowed networks must be permitted")
+
+	err := addresses.CheckHost(context.Background(), "localhost")
+	assert.ErrorIs(t, err, ErrForbiddenWebhookAddress)
+}
+
+func TestWebhookDispatcher_ForbidsInternalAddresses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	receiver := newWebhookReceiver(t, "secret", 0)
+	hooks := NewMemoryWebhooks()
+	_ = hooks.Create(ctx, models.Webhook{ID: uuid.New().String(), UserID: "bot", URL: receiver.URL, Secret: "secret"})
+	// Receiver listens on localhost, which isn't allowed by default
+	d := NewWebhookDispatcher(hooks, func(n models.Notification) ([]byte, error) {
+		return json.Marshal(n.Update)
+	}, logrus.New()).WithWorkers(1)
+	go d.Run(ctx)
+
+	d.Deliver(ctx, models.Notification{UserID: "bot", Seq: 1, Update: &models.ChatDeleted{}})
+	assert.Eventually(t, func() bool {
+		stored, _ := hooks.List(ctx, "bot")
+		return stored[0].Failures == 1
+	}, time.Second, 5*time.Millisecond, "delivery to internal address must fail without retries")
+	assert.Equal(t, int32(0), atomic.LoadInt32(&receiver.requests))
+}
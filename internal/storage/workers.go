@@ -0,0 +1,26 @@
+package storage
+
+import (
+	"context"
+	"sync"
+)
+
+// runWorkers handles items of queue on the given number of goroutines until ctx is done
+func runWorkers[T any](ctx context.Context, workers int, queue <-chan T, handle func(context.Context, T)) {
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case item := <-queue:
+					handle(ctx, item)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,101 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+)
+
+var (
+	ErrDeviceNotConnected = errors.New("device is not connected")
+)
+
+// DeviceState is a connected device along with how far it has read
+type DeviceState struct {
+	models.ConnectedDevice
+	ReadSeq int64
+}
+
+// ConnectedDevicesUseCase manages devices users listen from
+type ConnectedDevicesUseCase struct {
+	store   *storage.NotificationStore
+	cursors storage.ReadCursors
+	prefs   storage.PreferencesStore
+}
+
+func NewConnectedDevicesUseCase(
+	store *storage.NotificationStore,
+	cursors storage.ReadCursors,
+	prefs storage.PreferencesStore,
+) *ConnectedDevicesUseCase {
+	return &ConnectedDevicesUseCase{
+		store:   store,
+		cursors: cursors,
+		prefs:   prefs,
+	}
+}
+
+func (u *ConnectedDevicesUseCase) List(ctx context.Context, userID string) ([]DeviceState, error) {
+	devices, err := u.store.Devices(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	states := make([]DeviceState, 0, len(devices))
+	for _, device := range devices {
+		seq, err := u.cursors.Get(ctx, userID, device.ID)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, DeviceState{ConnectedDevice: device, ReadSeq: seq})
+	}
+	return states, nil
+}
+
+// Disconnect ends the stream of deviceID wherever it listens
+func (u *ConnectedDevicesUseCase) Disconnect(ctx context.Context, userID string, deviceID string) error {
+	kicked, err := u.store.Kick(ctx, userID, deviceID)
+	if err != nil {
+		return err
+	}
+	if !kicked {
+		return ErrDeviceNotConnected
+	}
+	return nil
+}
+
+// Connected makes deviceID listen on this instance only. Its listeners on other
+// instances are detached, since a device keeps a single stream.
+func (u *ConnectedDevicesUseCase) Connected(ctx context.Context, userID string, deviceID string) error {
+	return u.store.ReplaceRemote(ctx, userID, deviceID)
+}
+
+// Read moves the read cursor of deviceID to seq unless it is already further
+func (u *ConnectedDevicesUseCase) Read(ctx context.Context, userID string, deviceID string, seq int64) error {
+	return u.cursors.Advance(ctx, userID, deviceID, seq)
+}
+
+func (u *ConnectedDevicesUseCase) ReadCursor(ctx context.Context, userID string, deviceID string) (int64, error) {
+	return u.cursors.Get(ctx, userID, deviceID)
+}
+
+// Silent reports whether notifications must reach deviceID silently according to its preferences
+func (u *ConnectedDevicesUseCase) Silent(ctx context.Context, userID string, deviceID string) (bool, error) {
+	prefs, err := u.prefs.Get(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	device, ok := prefs.Devices[deviceID]
+	if !ok {
+		return false, nil
+	}
+	// Devices are looked up only if it matters which of them are connected
+	var connected []models.ConnectedDevice
+	if !device.Silent && len(device.SilentWhileActive) > 0 {
+		connected, err = u.store.Devices(ctx, userID)
+		if err != nil {
+			return false, err
+		}
+	}
+	return prefs.DeviceSilent(deviceID, connected), nil
+}
@@ -0,0 +1,61 @@
+package usecase
+
+import (
+	"context"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConnectedDevicesUseCase_Silent(t *testing.T) {
+	ctx := context.Background()
+	prefs := storage.NewMemoryPreferences()
+	store := storage.NewNotificationStorage(logrus.New()).WithPreferences(prefs)
+	u := NewConnectedDevicesUseCase(store, storage.NewMemoryReadCursors(), prefs)
+	_, err := NewPreferencesUseCase(prefs).SetDevicePreferences(ctx, "burenotti", "desktop",
+		models.DevicePreferences{SilentWhileActive: []models.ClientType{models.ClientMobile}})
+	assert.NoError(t, err)
+
+	desktop := store.ListenWith("burenotti", storage.ListenOptions{DeviceID: "desktop", Client: models.ClientDesktop})
+	defer desktop.Detach()
+	silent, err := u.Silent(ctx, "burenotti", "desktop")
+	assert.NoError(t, err)
+	assert.False(t, silent)
+
+	phone := store.ListenWith("burenotti", storage.ListenOptions{DeviceID: "phone", Client: models.ClientMobile})
+	silent, err = u.Silent(ctx, "burenotti", "desktop")
+	assert.NoError(t, err)
+	assert.True(t, silent, "desktop must be silent while the phone is connected")
+	silent, err = u.Silent(ctx, "burenotti", "phone")
+	assert.NoError(t, err)
+	assert.False(t, silent, "devices without preferences must not be silent")
+
+	phone.Detach()
+	silent, err = u.Silent(ctx, "burenotti", "desktop")
+	assert.NoError(t, err)
+	assert.False(t, silent)
+}
+
+func TestConnectedDevicesUseCase_List(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewNotificationStorage(logrus.New())
+	u := NewConnectedDevicesUseCase(store, storage.NewMemoryReadCursors(), storage.NewMemoryPreferences())
+
+	phone := store.ListenWith("burenotti", storage.ListenOptions{DeviceID: "phone", Client: models.ClientMobile})
+	defer phone.Detach()
+	assert.NoError(t, u.Read(ctx, "burenotti", "phone", 7))
+	assert.NoError(t, u.Read(ctx, "burenotti", "phone", 3), "cursor must not move back")
+
+	devices, err := u.List(ctx, "burenotti")
+	assert.NoError(t, err)
+	if assert.Len(t, devices, 1) {
+		assert.Equal(t, "phone", devices[0].ID)
+		assert.Equal(t, int64(7), devices[0].ReadSeq)
+	}
+
+	assert.ErrorIs(t, u.Disconnect(ctx, "burenotti", "laptop"), ErrDeviceNotConnected)
+	assert.NoError(t, u.Disconnect(ctx, "burenotti", "phone"))
+	assert.ErrorIs(t, phone.Err(), storage.ErrDeviceKicked)
+}
@@ -0,0 +1,40 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"time"
+)
+
+var (
+	ErrInvalidDevice = errors.New("device token and platform are required")
+)
+
+type DevicesUseCase struct {
+	devices storage.DeviceStore
+}
+
+func NewDevicesUseCase(devices storage.DeviceStore) *DevicesUseCase {
+	return &DevicesUseCase{
+		devices: devices,
+	}
+}
+
+// Register makes pushes of userID go to the device with token
+func (u *DevicesUseCase) Register(ctx context.Context, userID string, token string, platform models.Platform) error {
+	if token == "" || (platform != models.PlatformFCM && platform != models.PlatformAPNs) {
+		return ErrInvalidDevice
+	}
+	return u.devices.Register(ctx, models.Device{
+		Token:     token,
+		UserID:    userID,
+		Platform:  platform,
+		UpdatedAt: time.Now().UTC(),
+	})
+}
+
+func (u *DevicesUseCase) Unregister(ctx context.Context, userID string, token string) error {
+	return u.devices.Unregister(ctx, userID, token)
+}
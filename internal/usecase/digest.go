@@ -0,0 +1,252 @@
+package usecase
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/sirupsen/logrus"
+	htmltemplate "html/template"
+	"net/mail"
+	"sort"
+	"text/template"
+	"time"
+)
+
+const (
+	// digestBatchSize is how many users are handled per query of due digests
+	digestBatchSize = 100
+	// digestMessages limits messages collected for a single digest
+	digestMessages = 100
+	// digestChatMessages limits messages shown per chat
+	digestChatMessages = 5
+)
+
+var (
+	ErrInvalidEmail = errors.New("invalid email")
+)
+
+//go:embed templates/digest.txt templates/digest.html
+var digestTemplates embed.FS
+
+var (
+	digestText = template.Must(template.ParseFS(digestTemplates, "templates/digest.txt"))
+	digestHTML = htmltemplate.Must(htmltemplate.ParseFS(digestTemplates, "templates/digest.html"))
+)
+
+type digestMessage struct {
+	FromUser string
+	Text     string
+	SentAt   time.Time
+}
+
+type digestChat struct {
+	ChatID   string
+	Count    int
+	Messages []digestMessage
+	More     int
+}
+
+type digestData struct {
+	UserID string
+	Total  int
+	Chats  []digestChat
+}
+
+// DigestUseCase emails unread messages to users who have been offline for a while
+type DigestUseCase struct {
+	digests      storage.DigestStore
+	inbox        storage.Inbox
+	prefs        storage.PreferencesStore
+	mailer       storage.Mailer
+	offlineAfter time.Duration
+	logger       *logrus.Logger
+}
+
+// NewDigestUseCase creates a use case which sends a digest to users offline for offlineAfter
+// and then at most once per offlineAfter while they stay offline
+func NewDigestUseCase(
+	digests storage.DigestStore,
+	inbox storage.Inbox,
+	prefs storage.PreferencesStore,
+	mailer storage.Mailer,
+	offlineAfter time.Duration,
+	logger *logrus.Logger,
+) *DigestUseCase {
+	return &DigestUseCase{
+		digests:      digests,
+		inbox:        inbox,
+		prefs:        prefs,
+		mailer:       mailer,
+		offlineAfter: offlineAfter,
+		logger:       logger,
+	}
+}
+
+// Subscribe sends digests of userID to email. Empty email unsubscribes.
+func (u *DigestUseCase) Subscribe(ctx context.Context, userID string, email string) error {
+	if email != "" {
+		addr, err := mail.ParseAddress(email)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidEmail, err)
+		}
+		email = addr.Address
+	}
+	return u.digests.Subscribe(ctx, userID, email)
+}
+
+// Seen records that userID is connected to a live stream at the moment
+func (u *DigestUseCase) Seen(ctx context.Context, userID string) error {
+	return u.digests.Seen(ctx, userID, time.Now().UTC())
+}
+
+// Run sends due digests every interval until ctx is done
+func (u *DigestUseCase) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := u.SendDue(ctx, time.Now().UTC()); err != nil {
+			u.logger.Errorf("can't send digests: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue sends digests to all users who are due one at the moment now
+func (u *DigestUseCase) SendDue(ctx context.Context, now time.Time) error {
+	since := now.Add(-u.offlineAfter)
+	var after storage.DigestCursor
+	for {
+		subs, err := u.digests.Due(ctx, since, since, after, digestBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, sub := range subs {
+			if err := u.send(ctx, sub, now); err != nil {
+				u.logger.Errorf("can't send digest to %s: %v", sub.UserID, err)
+			}
+		}
+		if len(subs) < digestBatchSize {
+			return nil
+		}
+		// Subscriptions which failed before being claimed are still due,
+		// so the next batch starts after this one rather than from the beginning
+		after = storage.DigestCursorOf(subs[len(subs)-1])
+	}
+}
+
+func (u *DigestUseCase) send(ctx context.Context, sub models.DigestSubscription, now time.Time) error {
+	messages, err := u.unreadMessages(ctx, sub, now)
+	if err != nil {
+		return err
+	}
+	data := makeDigest(sub.UserID, messages)
+	var text, html bytes.Buffer
+	if err := digestText.Execute(&text, data); err != nil {
+		return err
+	}
+	if err := digestHTML.Execute(&html, data); err != nil {
+		return err
+	}
+
+	// Claim comes first, so replicas running the job at once don't send it twice.
+	// Messages are marked as sent only after the email is, so failed digests are retried.
+	// If the claim can't be released, the digest is retried once the next one is due.
+	claimed, err := u.digests.Claim(ctx, sub, now)
+	if err != nil || !claimed || len(messages) == 0 {
+		return err
+	}
+	u.logger.Infof("Sending digest of %d messages to %s", data.Total, sub.UserID)
+	err = u.mailer.Send(ctx, storage.Email{
+		To:      sub.Email,
+		Subject: digestSubject(data.Total),
+		Text:    text.String(),
+		HTML:    html.String(),
+	})
+	if err != nil {
+		if err := u.digests.Release(ctx, sub, now); err != nil {
+			u.logger.Errorf("can't release digest of %s: %v", sub.UserID, err)
+		}
+		return err
+	}
+	return u.digests.Sent(ctx, sub.UserID, messages[0].Seq)
+}
+
+func digestSubject(total int) string {
+	if total == 1 {
+		return "1 unread message"
+	}
+	return fmt.Sprintf("%d unread messages", total)
+}
+
+// unreadMessages returns unread messages of the user newer than the previous digest, newest first.
+// Messages muted by the user are left out like they are on live streams.
+func (u *DigestUseCase) unreadMessages(ctx context.Context, sub models.DigestSubscription, now time.Time) ([]models.Notification, error) {
+	prefs, err := u.prefs.Get(ctx, sub.UserID)
+	if err != nil {
+		return nil, err
+	}
+	var messages []models.Notification
+	q := storage.InboxQuery{Limit: digestMessages, Kinds: []models.UpdateKind{models.KindMessageSent}}
+	for len(messages) < digestMessages {
+		page, err := u.inbox.List(ctx, sub.UserID, q)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range page {
+			if n.Seq <= sub.LastDigestSeq || len(messages) == digestMessages {
+				return messages, nil
+			}
+			// Keyword rules are set up by users to hear about messages no matter what
+			muted := n.Reason != models.ReasonKeyword && prefs.Mutes(n.Update, sub.UserID, now)
+			if !n.Read && !muted {
+				messages = append(messages, n)
+			}
+		}
+		if len(page) < q.Limit {
+			break
+		}
+		q.BeforeSeq = page[len(page)-1].Seq
+	}
+	return messages, nil
+}
+
+// makeDigest groups messages by chat putting chats with the newest messages first
+func makeDigest(userID string, messages []models.Notification) digestData {
+	data := digestData{UserID: userID, Total: len(messages)}
+	chats := make(map[string]int)
+	for _, n := range messages {
+		msg := n.Update.(*models.MessageSent)
+		idx, ok := chats[msg.ChatID]
+		if !ok {
+			idx = len(data.Chats)
+			chats[msg.ChatID] = idx
+			data.Chats = append(data.Chats, digestChat{ChatID: msg.ChatID})
+		}
+		chat := &data.Chats[idx]
+		chat.Count++
+		if len(chat.Messages) == digestChatMessages {
+			chat.More++
+			continue
+		}
+		chat.Messages = append(chat.Messages, digestMessage{
+			FromUser: msg.FromUser,
+			Text:     msg.Text,
+			SentAt:   msg.GetTime(),
+		})
+	}
+	// Messages within a chat read better in the order they were sent
+	for _, chat := range data.Chats {
+		sort.Slice(chat.Messages, func(i, j int) bool {
+			return chat.Messages[i].SentAt.Before(chat.Messages[j].SentAt)
+		})
+	}
+	return data
+}
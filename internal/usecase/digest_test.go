@@ -0,0 +1,171 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+type FakeMailer struct {
+	sent []storage.Email
+	err  error
+}
+
+func (m *FakeMailer) Send(_ context.Context, email storage.Email) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, email)
+	return nil
+}
+
+func TestDigestUseCase_SendDue(t *testing.T) {
+	ctx := context.Background()
+	inbox := storage.NewMemoryInbox()
+	digests := storage.NewMemoryDigests()
+	mailer := &FakeMailer{}
+	u := NewDigestUseCase(digests, inbox, storage.NewMemoryPreferences(), mailer, time.Hour, logrus.New())
+	assert.ErrorIs(t, u.Subscribe(ctx, "burenotti", "not an email"), ErrInvalidEmail)
+	assert.NoError(t, u.Subscribe(ctx, "burenotti", "Burenotti <burenotti@example.com>"))
+	assert.NoError(t, u.Subscribe(ctx, "online", "online@example.com"))
+
+	chat1, chat2 := uuid.New().String(), uuid.New().String()
+	sentAt := time.Date(2023, 04, 15, 20, 0, 0, 0, time.UTC)
+	meta := models.UpdateMeta{Timestamp: sentAt, Audience: []string{"burenotti", "online"}}
+	save := func(chatId string, text string) []models.Notification {
+		n, err := inbox.Save(ctx, &models.MessageSent{
+			UpdateMeta: meta, MessageID: uuid.New().String(), FromUser: "friend", ChatID: chatId, Text: text,
+		}, meta.Audience)
+		assert.NoError(t, err)
+		return n
+	}
+	save(chat1, "first")
+	read := save(chat1, "already read")
+	_, _ = inbox.Save(ctx, &models.ChatDeleted{UpdateMeta: meta, ChatID: chat2}, meta.Audience)
+	save(chat2, "<b>second</b>")
+	_, _ = inbox.MarkRead(ctx, "burenotti", storage.ReadSelector{IDs: []string{read[0].ID}})
+
+	now := time.Now().UTC().Add(2 * time.Hour)
+	assert.NoError(t, digests.Seen(ctx, "online", now.Add(5*time.Hour)))
+	assert.NoError(t, u.SendDue(ctx, now))
+
+	if assert.Len(t, mailer.sent, 1, "only offline users must get a digest") {
+		email := mailer.sent[0]
+		assert.Equal(t, "burenotti@example.com", email.To)
+		assert.Equal(t, "2 unread messages", email.Subject)
+		assert.Contains(t, email.Text, "friend: first")
+		assert.NotContains(t, email.Text, "already read")
+		assert.Less(t, strings.Index(email.Text, chat2), strings.Index(email.Text, chat1),
+			"chats with newer messages must go first")
+		assert.Contains(t, email.HTML, "&lt;b&gt;second&lt;/b&gt;", "html must be escaped")
+	}
+
+	assert.NoError(t, u.SendDue(ctx, now.Add(2*time.Hour)))
+	assert.Len(t, mailer.sent, 1, "digest must not repeat messages")
+
+	save(chat1, "third")
+	assert.NoError(t, u.SendDue(ctx, now.Add(30*time.Minute)))
+	assert.Len(t, mailer.sent, 1, "digests must not be sent more often than offline period")
+	assert.NoError(t, u.SendDue(ctx, now.Add(4*time.Hour)))
+	if assert.Len(t, mailer.sent, 2) {
+		assert.Equal(t, "1 unread message", mailer.sent[1].Subject)
+		assert.Contains(t, mailer.sent[1].Text, "friend: third")
+	}
+}
+
+// FailingInbox fails to list notifications
+type FailingInbox struct {
+	*storage.MemoryInbox
+}
+
+func (i FailingInbox) List(context.Context, string, storage.InboxQuery) ([]models.Notification, error) {
+	return nil, errors.New("inbox is unavailable")
+}
+
+func TestDigestUseCase_SendDueSkipsFailed(t *testing.T) {
+	ctx := context.Background()
+	digests := storage.NewMemoryDigests()
+	u := NewDigestUseCase(digests, FailingInbox{storage.NewMemoryInbox()}, storage.NewMemoryPreferences(), &FakeMailer{}, time.Hour, logrus.New())
+	// More than a batch, every one failing before it is claimed
+	for i := 0; i <= digestBatchSize; i++ {
+		assert.NoError(t, u.Subscribe(ctx, fmt.Sprintf("user-%d", i), "user@example.com"))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- u.SendDue(ctx, time.Now().UTC().Add(2*time.Hour))
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "sending must end when every digest fails")
+	}
+}
+
+func TestDigestUseCase_SendDueRetriesFailed(t *testing.T) {
+	ctx := context.Background()
+	inbox := storage.NewMemoryInbox()
+	mailer := &FakeMailer{err: errors.New("smtp is unavailable")}
+	u := NewDigestUseCase(storage.NewMemoryDigests(), inbox, storage.NewMemoryPreferences(), mailer, time.Hour, logrus.New())
+	assert.NoError(t, u.Subscribe(ctx, "burenotti", "burenotti@example.com"))
+	meta := models.UpdateMeta{Timestamp: time.Now(), Audience: []string{"burenotti"}}
+	_, err := inbox.Save(ctx, &models.MessageSent{
+		UpdateMeta: meta, MessageID: uuid.New().String(), FromUser: "friend", ChatID: uuid.New().String(), Text: "hi",
+	}, meta.Audience)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC().Add(2 * time.Hour)
+	assert.NoError(t, u.SendDue(ctx, now))
+	assert.Empty(t, mailer.sent)
+
+	mailer.err = nil
+	assert.NoError(t, u.SendDue(ctx, now.Add(time.Minute)))
+	if assert.Len(t, mailer.sent, 1, "failed digest must be sent by the next run") {
+		assert.Contains(t, mailer.sent[0].Text, "friend: hi")
+	}
+	assert.NoError(t, u.SendDue(ctx, now.Add(2*time.Hour)))
+	assert.Len(t, mailer.sent, 1, "sent digest must not repeat")
+}
+
+func TestDigestUseCase_SendDueSkipsMuted(t *testing.T) {
+	ctx := context.Background()
+	inbox := storage.NewMemoryInbox()
+	prefs := storage.NewMemoryPreferences()
+	mailer := &FakeMailer{}
+	u := NewDigestUseCase(storage.NewMemoryDigests(), inbox, prefs, mailer, time.Hour, logrus.New())
+	assert.NoError(t, u.Subscribe(ctx, "burenotti", "burenotti@example.com"))
+
+	mutedChat, chat := uuid.New().String(), uuid.New().String()
+	_, err := prefs.Update(ctx, "burenotti", func(p *models.Preferences) error {
+		p.ChatMutes = map[string]models.ChatMute{mutedChat: {ExceptMentions: true}}
+		return nil
+	})
+	assert.NoError(t, err)
+	meta := models.UpdateMeta{Timestamp: time.Now(), Audience: []string{"burenotti"}}
+	for _, msg := range []struct{ chatId, text string }{
+		{mutedChat, "muted"},
+		{mutedChat, "hey @burenotti"},
+		{chat, "not muted"},
+	} {
+		_, err := inbox.Save(ctx, &models.MessageSent{
+			UpdateMeta: meta, MessageID: uuid.New().String(), FromUser: "friend", ChatID: msg.chatId, Text: msg.text,
+		}, meta.Audience)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, u.SendDue(ctx, time.Now().UTC().Add(2*time.Hour)))
+	if assert.Len(t, mailer.sent, 1) {
+		assert.Equal(t, "2 unread messages", mailer.sent[0].Subject)
+		assert.NotContains(t, mailer.sent[0].Text, "friend: muted", "muted messages must not be emailed")
+		assert.Contains(t, mailer.sent[0].Text, "friend: hey @burenotti")
+	}
+}
@@ -0,0 +1,78 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+var (
+	ErrTooManyKeywordRules = errors.New("too many keyword rules")
+)
+
+// Every message is matched against rules of all users, so their number is limited
+const maxKeywordRules = 50
+
+type KeywordsUseCase struct {
+	rules  storage.KeywordStore
+	alerts *storage.KeywordAlerts
+	logger *logrus.Logger
+}
+
+func NewKeywordsUseCase(rules storage.KeywordStore, alerts *storage.KeywordAlerts, logger *logrus.Logger) *KeywordsUseCase {
+	return &KeywordsUseCase{
+		rules:  rules,
+		alerts: alerts,
+		logger: logger,
+	}
+}
+
+// Add creates a keyword rule of userID. Pattern is a regular expression if isRegexp is set.
+func (u *KeywordsUseCase) Add(ctx context.Context, userID string, pattern string, isRegexp bool) (models.KeywordRule, error) {
+	rule := models.KeywordRule{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		Pattern:   pattern,
+		Regexp:    isRegexp,
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := rule.Validate(); err != nil {
+		return models.KeywordRule{}, err
+	}
+	rules, err := u.rules.List(ctx, userID)
+	if err != nil {
+		return models.KeywordRule{}, err
+	}
+	if len(rules) >= maxKeywordRules {
+		return models.KeywordRule{}, ErrTooManyKeywordRules
+	}
+	if err := u.rules.Create(ctx, rule); err != nil {
+		return models.KeywordRule{}, err
+	}
+	u.reload(ctx)
+	return rule, nil
+}
+
+func (u *KeywordsUseCase) List(ctx context.Context, userID string) ([]models.KeywordRule, error) {
+	return u.rules.List(ctx, userID)
+}
+
+func (u *KeywordsUseCase) Delete(ctx context.Context, userID string, id string) error {
+	if err := u.rules.Delete(ctx, userID, id); err != nil {
+		return err
+	}
+	u.reload(ctx)
+	return nil
+}
+
+// reload applies changed rules at once. The rule is saved already,
+// so a failure only delays it until the next periodic reload.
+func (u *KeywordsUseCase) reload(ctx context.Context) {
+	if err := u.alerts.Reload(ctx); err != nil {
+		u.logger.Errorf("can't reload keyword rules: %v", err)
+	}
+}
@@ -1,17 +1,169 @@
 package usecase
 
-import "github.com/practice-sem-2/notification-service/internal/storage"
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"strconv"
+	"time"
+)
+
+const (
+	replayPageSize     = 100
+	defaultHistorySize = 20
+	maxHistorySize     = 100
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+type HistoryRequest struct {
+	// Cursor is NextCursor of the previous page. Empty cursor means the first page.
+	Cursor string
+	Limit  int
+	ChatID string
+	Kinds  []models.UpdateKind
+}
+
+type HistoryPage struct {
+	Notifications []models.Notification
+	// NextCursor is empty if there are no more pages
+	NextCursor string
+}
 
 type NotificationsUseCase struct {
 	store *storage.NotificationStore
+	inbox storage.Inbox
 }
 
-func NewNotificationUseCase(store *storage.NotificationStore) *NotificationsUseCase {
+func NewNotificationUseCase(store *storage.NotificationStore, inbox storage.Inbox) *NotificationsUseCase {
 	return &NotificationsUseCase{
 		store: store,
+		inbox: inbox,
+	}
+}
+
+func (u *NotificationsUseCase) Listen(userID string, opts storage.ListenOptions) storage.NotificationListener {
+	return u.store.ListenWith(userID, opts)
+}
+
+// Replay sends stored notifications of userID with sequence number greater than sinceSeq
+// in ascending order skipping muted ones. It returns sequence number of the last replayed notification.
+func (u *NotificationsUseCase) Replay(
+	ctx context.Context,
+	userID string,
+	sinceSeq int64,
+	send func(models.Notification) error,
+) (int64, error) {
+	last := sinceSeq
+	for {
+		page, err := u.inbox.Since(ctx, userID, last, replayPageSize)
+		if err != nil {
+			return last, err
+		}
+		u.store.Prioritize(ctx, page)
+		for _, n := range page {
+			if u.store.Deliverable(ctx, &n) {
+				if err := send(n); err != nil {
+					return last, err
+				}
+			}
+			last = n.Seq
+		}
+		if len(page) < replayPageSize {
+			return last, nil
+		}
+	}
+}
+
+// History returns a page of past notifications of userID from newest to oldest
+func (u *NotificationsUseCase) History(ctx context.Context, userID string, r HistoryRequest) (HistoryPage, error) {
+	beforeSeq, err := decodeCursor(r.Cursor)
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	limit := r.Limit
+	if limit <= 0 {
+		limit = defaultHistorySize
+	}
+	if limit > maxHistorySize {
+		limit = maxHistorySize
+	}
+
+	// One extra notification tells whether there is a next page
+	notifications, err := u.inbox.List(ctx, userID, storage.InboxQuery{
+		BeforeSeq: beforeSeq,
+		Limit:     limit + 1,
+		ChatID:    r.ChatID,
+		Kinds:     r.Kinds,
+	})
+	if err != nil {
+		return HistoryPage{}, err
+	}
+
+	page := HistoryPage{Notifications: notifications}
+	if len(notifications) > limit {
+		page.Notifications = notifications[:limit]
+		page.NextCursor = encodeCursor(page.Notifications[limit-1].Seq)
+	}
+	// Reasons aren't stored, so they are set the way Replay sets them
+	u.store.Prioritize(ctx, page.Notifications)
+	return page, nil
+}
+
+func encodeCursor(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(seq, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	seq, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || seq <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return seq, nil
+}
+
+// MarkRead marks notifications of userID selected by sel as read and returns
+// updated unread counts. Listeners of userID are notified if counts changed.
+func (u *NotificationsUseCase) MarkRead(ctx context.Context, userID string, sel storage.ReadSelector) (models.UnreadCounts, error) {
+	marked, err := u.inbox.MarkRead(ctx, userID, sel)
+	if err != nil {
+		return models.UnreadCounts{}, err
+	}
+
+	counts, err := u.inbox.UnreadCounts(ctx, userID)
+	if err != nil {
+		return models.UnreadCounts{}, err
+	}
+
+	if marked > 0 {
+		now := time.Now().UTC()
+		u.store.Broadcast(ctx, models.Notification{
+			Update: &models.UnreadChanged{
+				UpdateMeta: models.UpdateMeta{
+					Timestamp: now,
+					Audience:  []string{userID},
+				},
+				Counts: counts,
+			},
+			UserID:    userID,
+			CreatedAt: now,
+		})
 	}
+	return counts, nil
 }
 
-func (u *NotificationsUseCase) Listen(userID string) storage.NotificationListener {
-	return u.store.Listen(userID)
+func (u *NotificationsUseCase) UnreadCounts(ctx context.Context, userID string) (models.UnreadCounts, error) {
+	return u.inbox.UnreadCounts(ctx, userID)
 }
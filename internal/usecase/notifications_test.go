@@ -0,0 +1,129 @@
+package usecase
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestNotificationsUseCase_Replay(t *testing.T) {
+	ctx := context.Background()
+	inbox := storage.NewMemoryInbox()
+	const total = replayPageSize*2 + 3
+	for i := 0; i < total; i++ {
+		_, err := inbox.Save(ctx, &models.ChatDeleted{
+			UpdateMeta: models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"burenotti"}},
+			ChatID:     uuid.New().String(),
+		}, []string{"burenotti"})
+		assert.NoError(t, err)
+	}
+
+	u := NewNotificationUseCase(storage.NewNotificationStorage(logrus.New()), inbox)
+	var sent []int64
+	last, err := u.Replay(ctx, "burenotti", 2, func(n models.Notification) error {
+		sent = append(sent, n.Seq)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(total), last)
+	assert.Len(t, sent, total-2)
+	for i, seq := range sent {
+		assert.Equal(t, int64(i+3), seq, "notifications must be replayed in order without gaps")
+	}
+}
+
+func TestNotificationsUseCase_History(t *testing.T) {
+	ctx := context.Background()
+	inbox := storage.NewMemoryInbox()
+	chatId := uuid.New().String()
+	meta := models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"burenotti"}}
+	for i := 0; i < 5; i++ {
+		_, _ = inbox.Save(ctx, &models.MemberAdded{UpdateMeta: meta, ChatID: chatId, UserID: "friend"}, meta.Audience)
+		_, _ = inbox.Save(ctx, &models.ChatDeleted{UpdateMeta: meta, ChatID: chatId}, meta.Audience)
+		_, _ = inbox.Save(ctx, &models.MemberAdded{UpdateMeta: meta, ChatID: uuid.New().String()}, meta.Audience)
+	}
+	u := NewNotificationUseCase(storage.NewNotificationStorage(logrus.New()), inbox)
+
+	var seqs []int64
+	cursor := ""
+	for pages := 0; ; pages++ {
+		page, err := u.History(ctx, "burenotti", HistoryRequest{
+			Cursor: cursor,
+			Limit:  2,
+			ChatID: chatId,
+			Kinds:  []models.UpdateKind{models.KindMemberAdded},
+		})
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Notifications), 2)
+		for _, n := range page.Notifications {
+			assert.Equal(t, chatId, n.Update.(*models.MemberAdded).ChatID)
+			seqs = append(seqs, n.Seq)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		assert.Less(t, pages, 5, "pagination must end")
+		cursor = page.NextCursor
+	}
+	assert.Equal(t, []int64{13, 10, 7, 4, 1}, seqs, "history must be newest first")
+
+	_, err := u.History(ctx, "burenotti", HistoryRequest{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	_, _ = inbox.Save(ctx, &models.MessageSent{
+		UpdateMeta: meta, MessageID: uuid.New().String(), FromUser: "friend", ChatID: chatId, Text: "hey @burenotti",
+	}, meta.Audience)
+	page, err := u.History(ctx, "burenotti", HistoryRequest{Limit: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, page.Notifications, 1) {
+		assert.Equal(t, models.ReasonMention, page.Notifications[0].Reason, "history must tell why messages concern the user")
+	}
+}
+
+func TestNotificationsUseCase_MarkRead(t *testing.T) {
+	ctx := context.Background()
+	inbox := storage.NewMemoryInbox()
+	store := storage.NewNotificationStorage(logrus.New())
+	chat1, chat2 := uuid.New().String(), uuid.New().String()
+	meta := models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"burenotti"}}
+	var saved []models.Notification
+	for _, chatId := range []string{chat1, chat1, chat1, chat2, chat2} {
+		n, _ := inbox.Save(ctx, &models.ChatDeleted{UpdateMeta: meta, ChatID: chatId}, meta.Audience)
+		saved = append(saved, n...)
+	}
+	u := NewNotificationUseCase(store, inbox)
+	otherDevice := store.Listen("burenotti")
+	defer otherDevice.Detach()
+
+	counts, err := u.UnreadCounts(ctx, "burenotti")
+	assert.NoError(t, err)
+	assert.Equal(t, models.UnreadCounts{Total: 5, Chats: map[string]int64{chat1: 3, chat2: 2}}, counts)
+
+	counts, err = u.MarkRead(ctx, "burenotti", storage.ReadSelector{ChatID: chat1, UpToSeq: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, models.UnreadCounts{Total: 3, Chats: map[string]int64{chat1: 1, chat2: 2}}, counts)
+
+	counts, err = u.MarkRead(ctx, "burenotti", storage.ReadSelector{IDs: []string{saved[3].ID}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), counts.Total)
+
+	select {
+	case n := <-otherDevice.Notifications():
+		changed, ok := n.Update.(*models.UnreadChanged)
+		assert.True(t, ok, "listeners must be notified about changed counters")
+		assert.Equal(t, int64(3), changed.Counts.Total)
+	default:
+		assert.Fail(t, "listeners must be notified about changed counters")
+	}
+
+	counts, err = u.MarkRead(ctx, "burenotti", storage.ReadSelector{})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), counts.Total)
+	assert.Empty(t, counts.Chats)
+}
@@ -0,0 +1,118 @@
+package usecase
+
+import (
+	"context"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"time"
+)
+
+type PreferencesUseCase struct {
+	prefs storage.PreferencesStore
+}
+
+func NewPreferencesUseCase(prefs storage.PreferencesStore) *PreferencesUseCase {
+	return &PreferencesUseCase{
+		prefs: prefs,
+	}
+}
+
+func (u *PreferencesUseCase) Get(ctx context.Context, userID string) (models.Preferences, error) {
+	return u.prefs.Get(ctx, userID)
+}
+
+// MuteChat mutes messages of chatID until the given moment or forever if until is nil
+func (u *PreferencesUseCase) MuteChat(
+	ctx context.Context,
+	userID string,
+	chatID string,
+	until *time.Time,
+	exceptMentions bool,
+) (models.Preferences, error) {
+	return u.prefs.Update(ctx, userID, func(p *models.Preferences) error {
+		if p.ChatMutes == nil {
+			p.ChatMutes = make(map[string]models.ChatMute)
+		}
+		p.ChatMutes[chatID] = models.ChatMute{
+			Until:          until,
+			ExceptMentions: exceptMentions,
+		}
+		return nil
+	})
+}
+
+func (u *PreferencesUseCase) UnmuteChat(ctx context.Context, userID string, chatID string) (models.Preferences, error) {
+	return u.prefs.Update(ctx, userID, func(p *models.Preferences) error {
+		delete(p.ChatMutes, chatID)
+		return nil
+	})
+}
+
+// SetMutedKinds replaces the list of update kinds muted by userID
+func (u *PreferencesUseCase) SetMutedKinds(
+	ctx context.Context,
+	userID string,
+	kinds []models.UpdateKind,
+) (models.Preferences, error) {
+	return u.prefs.Update(ctx, userID, func(p *models.Preferences) error {
+		p.MutedKinds = kinds
+		return nil
+	})
+}
+
+// SetQuietHours replaces do-not-disturb windows of userID
+func (u *PreferencesUseCase) SetQuietHours(
+	ctx context.Context,
+	userID string,
+	schedule []models.QuietHours,
+) (models.Preferences, error) {
+	for _, q := range schedule {
+		if err := q.Validate(); err != nil {
+			return models.Preferences{}, err
+		}
+	}
+	return u.prefs.Update(ctx, userID, func(p *models.Preferences) error {
+		p.QuietHours = schedule
+		return nil
+	})
+}
+
+// SetDevicePreferences replaces preferences of deviceID of userID. Empty preferences are removed.
+func (u *PreferencesUseCase) SetDevicePreferences(
+	ctx context.Context,
+	userID string,
+	deviceID string,
+	device models.DevicePreferences,
+) (models.Preferences, error) {
+	return u.prefs.Update(ctx, userID, func(p *models.Preferences) error {
+		if !device.Silent && len(device.SilentWhileActive) == 0 {
+			delete(p.Devices, deviceID)
+			return nil
+		}
+		if p.Devices == nil {
+			p.Devices = make(map[string]models.DevicePreferences)
+		}
+		p.Devices[deviceID] = device
+		return nil
+	})
+}
+
+// SetMentionsBypassMutes makes messages mentioning userID get through mutes or stop doing so
+func (u *PreferencesUseCase) SetMentionsBypassMutes(
+	ctx context.Context,
+	userID string,
+	enabled bool,
+) (models.Preferences, error) {
+	return u.prefs.Update(ctx, userID, func(p *models.Preferences) error {
+		p.MentionsBypassMutes = enabled
+		return nil
+	})
+}
+
+// SetHidePresence hides presence of userID from other users or shows it again
+func (u *PreferencesUseCase) SetHidePresence(ctx context.Context, userID string, hidden bool) (models.Preferences, error) {
+	return u.prefs.Update(ctx, userID, func(p *models.Preferences) error {
+		p.HidePresence = hidden
+		return nil
+	})
+}
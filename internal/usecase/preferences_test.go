@@ -0,0 +1,50 @@
+package usecase
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestPreferencesUseCase_MuteChat(t *testing.T) {
+	ctx := context.Background()
+	prefs := storage.NewMemoryPreferences()
+	inbox := storage.NewMemoryInbox()
+	chatId := uuid.New().String()
+	meta := models.UpdateMeta{Timestamp: time.Now().UTC(), Audience: []string{"burenotti"}}
+	for i := 0; i < 3; i++ {
+		_, _ = inbox.Save(ctx, &models.MessageSent{UpdateMeta: meta, ChatID: chatId}, meta.Audience)
+		_, _ = inbox.Save(ctx, &models.MemberAdded{UpdateMeta: meta, ChatID: chatId}, meta.Audience)
+	}
+	store := storage.NewNotificationStorage(logrus.New()).WithPreferences(prefs)
+	notifications := NewNotificationUseCase(store, inbox)
+	u := NewPreferencesUseCase(prefs)
+
+	until := time.Now().Add(time.Hour).UTC()
+	p, err := u.MuteChat(ctx, "burenotti", chatId, &until, false)
+	assert.NoError(t, err)
+	assert.Equal(t, until.Unix(), p.ChatMutes[chatId].Until.Unix())
+
+	var replayed []models.UpdateKind
+	last, err := notifications.Replay(ctx, "burenotti", 0, func(n models.Notification) error {
+		replayed = append(replayed, models.KindOf(n.Update))
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), last, "muted notifications must be skipped but not stop the replay")
+	assert.Equal(t, []models.UpdateKind{models.KindMemberAdded, models.KindMemberAdded, models.KindMemberAdded}, replayed)
+
+	p, err = u.UnmuteChat(ctx, "burenotti", chatId)
+	assert.NoError(t, err)
+	assert.Empty(t, p.ChatMutes)
+
+	p, err = u.SetMutedKinds(ctx, "burenotti", []models.UpdateKind{models.KindMemberAdded})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.UpdateKind{models.KindMemberAdded}, p.MutedKinds)
+	assert.Empty(t, p.ChatMutes, "muting kinds must keep chat mutes intact")
+}
@@ -0,0 +1,294 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/sirupsen/logrus"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTooManyUsers = errors.New("too many users requested")
+)
+
+const maxPresenceBatch = 200
+
+// presenceDebounce is how long a user must stay offline before watchers learn about it,
+// so clients reconnecting after a network hiccup don't look offline
+const presenceDebounce = 15 * time.Second
+
+// presenceSharingPeriod is how often chats shared by watchers and watched users are
+// checked again, so users leaving a chat stop seeing presence of its members
+const presenceSharingPeriod = time.Minute
+
+// PresenceUseCase tells whether users are online and when they were last seen.
+// Presence is visible only to users sharing a chat. Users may hide their presence
+// from them too, then they look offline and never seen to others.
+type PresenceUseCase struct {
+	store    *storage.NotificationStore
+	lastSeen storage.LastSeenStore
+	prefs    storage.PreferencesStore
+	members  storage.ChatMembers
+	logger   *logrus.Logger
+
+	mu      sync.Mutex
+	watches map[*PresenceSubscription]bool
+}
+
+func NewPresenceUseCase(
+	store *storage.NotificationStore,
+	lastSeen storage.LastSeenStore,
+	prefs storage.PreferencesStore,
+	members storage.ChatMembers,
+	logger *logrus.Logger,
+) *PresenceUseCase {
+	return &PresenceUseCase{
+		store:    store,
+		lastSeen: lastSeen,
+		prefs:    prefs,
+		members:  members,
+		logger:   logger,
+		watches:  make(map[*PresenceSubscription]bool),
+	}
+}
+
+// PresenceSubscription gets presence of watched users every time it is polled
+type PresenceSubscription struct {
+	viewerID string
+	userIDs  []string
+	updates  chan []models.UserPresence
+	// shared tells which watched users share a chat with the viewer.
+	// It is accessed by polls only once the subscription is watched.
+	shared    map[string]bool
+	checkedAt time.Time
+}
+
+// Updates returns presence of watched users in the order they were given.
+// Only the latest presence is kept if it isn't read in time.
+func (s *PresenceSubscription) Updates() <-chan []models.UserPresence {
+	return s.updates
+}
+
+// Seen records that userID is connected at the moment
+func (u *PresenceUseCase) Seen(ctx context.Context, userID string) error {
+	return u.lastSeen.Seen(ctx, userID, time.Now().UTC())
+}
+
+// Get returns presence of userIDs as viewerID sees it in the same order
+func (u *PresenceUseCase) Get(ctx context.Context, viewerID string, userIDs []string) ([]models.UserPresence, error) {
+	if len(userIDs) > maxPresenceBatch {
+		return nil, ErrTooManyUsers
+	}
+	shared, err := u.members.Shared(ctx, viewerID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	presence, hidden, err := u.lookup(ctx, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i, p := range presence {
+		presence[i] = visibleTo(viewerID, p, hidden[p.UserID] || !shared[p.UserID])
+	}
+	return presence, nil
+}
+
+// Watch subscribes viewerID to presence of userIDs polled by Run.
+// The subscription must be cancelled with Unwatch.
+func (u *PresenceUseCase) Watch(ctx context.Context, viewerID string, userIDs []string) (*PresenceSubscription, error) {
+	if len(userIDs) > maxPresenceBatch {
+		return nil, ErrTooManyUsers
+	}
+	shared, err := u.members.Shared(ctx, viewerID, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	sub := &PresenceSubscription{
+		viewerID:  viewerID,
+		userIDs:   userIDs,
+		updates:   make(chan []models.UserPresence, 1),
+		shared:    shared,
+		checkedAt: time.Now(),
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.watches[sub] = true
+	return sub, nil
+}
+
+func (u *PresenceUseCase) Unwatch(sub *PresenceSubscription) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.watches, sub)
+}
+
+// Run polls presence of watched users every period until ctx is done. Every user
+// is looked up once per poll no matter how many subscriptions watch them.
+func (u *PresenceUseCase) Run(ctx context.Context, period time.Duration) error {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := u.poll(ctx); err != nil {
+				u.logger.Errorf("can't poll presence: %v", err)
+			}
+		}
+	}
+}
+
+func (u *PresenceUseCase) poll(ctx context.Context) error {
+	u.mu.Lock()
+	subs := make([]*PresenceSubscription, 0, len(u.watches))
+	watched := make(map[string]bool)
+	var userIDs []string
+	for sub := range u.watches {
+		subs = append(subs, sub)
+		for _, userID := range sub.userIDs {
+			if !watched[userID] {
+				watched[userID] = true
+				userIDs = append(userIDs, userID)
+			}
+		}
+	}
+	u.mu.Unlock()
+
+	current := make(map[string]models.UserPresence, len(userIDs))
+	hidden := make(map[string]bool)
+	for start := 0; start < len(userIDs); start += maxPresenceBatch {
+		end := start + maxPresenceBatch
+		if end > len(userIDs) {
+			end = len(userIDs)
+		}
+		presence, batchHidden, err := u.lookup(ctx, userIDs[start:end])
+		if err != nil {
+			return err
+		}
+		for _, p := range presence {
+			current[p.UserID] = p
+			hidden[p.UserID] = batchHidden[p.UserID]
+		}
+	}
+
+	for _, sub := range subs {
+		if time.Since(sub.checkedAt) >= presenceSharingPeriod {
+			shared, err := u.members.Shared(ctx, sub.viewerID, sub.userIDs)
+			if err != nil {
+				return err
+			}
+			sub.shared, sub.checkedAt = shared, time.Now()
+		}
+		presence := make([]models.UserPresence, 0, len(sub.userIDs))
+		for _, userID := range sub.userIDs {
+			presence = append(presence, visibleTo(sub.viewerID, current[userID], hidden[userID] || !sub.shared[userID]))
+		}
+		// Polls are the only sender, so the slot is free once an unread update is dropped
+		select {
+		case <-sub.updates:
+		default:
+		}
+		sub.updates <- presence
+	}
+	return nil
+}
+
+// lookup returns presence of userIDs in the same order and which of them hide it
+func (u *PresenceUseCase) lookup(ctx context.Context, userIDs []string) ([]models.UserPresence, map[string]bool, error) {
+	listeners, err := u.store.Listeners(ctx, userIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen, err := u.lastSeen.LastSeen(ctx, userIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+	hidden := make(map[string]bool)
+	for _, userID := range userIDs {
+		prefs, err := u.prefs.Get(ctx, userID)
+		if err != nil {
+			return nil, nil, err
+		}
+		hidden[userID] = prefs.HidePresence
+	}
+
+	now := time.Now().UTC()
+	presence := make([]models.UserPresence, 0, len(userIDs))
+	for _, userID := range userIDs {
+		p := models.UserPresence{
+			UserID:  userID,
+			Online:  listeners[userID] > 0,
+			Devices: listeners[userID],
+		}
+		// Activity of connected users is recorded only from time to time
+		if p.Online {
+			p.LastSeen = &now
+		} else if at, ok := seen[userID]; ok {
+			p.LastSeen = &at
+		}
+		presence = append(presence, p)
+	}
+	return presence, hidden, nil
+}
+
+// visibleTo returns p as viewerID sees it if p is hidden from the viewer.
+// Users always see their own presence.
+func visibleTo(viewerID string, p models.UserPresence, hidden bool) models.UserPresence {
+	if hidden && viewerID != p.UserID {
+		return models.UserPresence{UserID: p.UserID}
+	}
+	return p
+}
+
+// PresenceWatch tells which of watched users changed their presence since it was last checked.
+// Users going offline are reported once they stay offline for presenceDebounce.
+type PresenceWatch struct {
+	reported     map[string]bool
+	offlineSince map[string]time.Time
+}
+
+func NewPresenceWatch() *PresenceWatch {
+	return &PresenceWatch{
+		reported:     make(map[string]bool),
+		offlineSince: make(map[string]time.Time),
+	}
+}
+
+// Changes returns presence to report given the current one at the moment now.
+// Presence of every user is reported on the first check.
+func (w *PresenceWatch) Changes(current []models.UserPresence, now time.Time) []models.UserPresence {
+	var changed []models.UserPresence
+	for _, p := range current {
+		reported, known := w.reported[p.UserID]
+		if p.Online {
+			delete(w.offlineSince, p.UserID)
+			if !known || !reported {
+				w.reported[p.UserID] = true
+				changed = append(changed, p)
+			}
+			continue
+		}
+
+		if known && !reported {
+			continue
+		}
+		if known {
+			since, waiting := w.offlineSince[p.UserID]
+			if !waiting {
+				w.offlineSince[p.UserID] = now
+				continue
+			}
+			if now.Sub(since) < presenceDebounce {
+				continue
+			}
+		}
+		delete(w.offlineSince, p.UserID)
+		w.reported[p.UserID] = false
+		changed = append(changed, p)
+	}
+	return changed
+}
@@ -0,0 +1,156 @@
+package usecase
+
+import (
+	"context"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// membersOf returns chat members where all of users share a chat
+func membersOf(t *testing.T, users ...string) *storage.MemoryChatMembers {
+	members := storage.NewMemoryChatMembers()
+	assert.NoError(t, members.Track(context.Background(), &models.ChatCreated{ChatID: "chat", Members: users}))
+	return members
+}
+
+func TestPresenceUseCase_Get(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewNotificationStorage(logrus.New())
+	lastSeen := storage.NewMemoryLastSeen()
+	members := membersOf(t, "carol", "burenotti", "alice", "bob")
+	u := NewPresenceUseCase(store, lastSeen, storage.NewMemoryPreferences(), members, logrus.New())
+
+	phone, laptop := store.Listen("burenotti"), store.Listen("burenotti")
+	defer phone.Detach()
+	defer laptop.Detach()
+	seenAt := time.Date(2023, 4, 15, 20, 0, 0, 0, time.UTC)
+	assert.NoError(t, lastSeen.Seen(ctx, "alice", seenAt))
+
+	presence, err := u.Get(ctx, "carol", []string{"burenotti", "alice", "bob"})
+	assert.NoError(t, err)
+	if assert.Len(t, presence, 3) {
+		assert.True(t, presence[0].Online)
+		assert.Equal(t, 2, presence[0].Devices)
+		assert.NotNil(t, presence[0].LastSeen)
+		assert.Equal(t, models.UserPresence{UserID: "alice", LastSeen: &seenAt}, presence[1])
+		assert.Equal(t, models.UserPresence{UserID: "bob"}, presence[2])
+	}
+
+	_, err = u.Get(ctx, "carol", make([]string, maxPresenceBatch+1))
+	assert.ErrorIs(t, err, ErrTooManyUsers)
+
+	assert.NoError(t, members.Track(ctx, &models.MemberRemoved{ChatID: "chat", UserID: "burenotti"}))
+	presence, err = u.Get(ctx, "carol", []string{"burenotti"})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.UserPresence{{UserID: "burenotti"}}, presence,
+		"presence must be hidden from users not sharing a chat")
+	presence, err = u.Get(ctx, "burenotti", []string{"burenotti"})
+	assert.NoError(t, err)
+	if assert.Len(t, presence, 1) {
+		assert.True(t, presence[0].Online, "users must see their own presence")
+	}
+}
+
+func TestPresenceUseCase_HidePresence(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewNotificationStorage(logrus.New())
+	lastSeen := storage.NewMemoryLastSeen()
+	prefs := storage.NewMemoryPreferences()
+	u := NewPresenceUseCase(store, lastSeen, prefs, membersOf(t, "alice", "burenotti"), logrus.New())
+
+	l := store.Listen("burenotti")
+	defer l.Detach()
+	assert.NoError(t, lastSeen.Seen(ctx, "burenotti", time.Now()))
+	_, err := NewPreferencesUseCase(prefs).SetHidePresence(ctx, "burenotti", true)
+	assert.NoError(t, err)
+
+	presence, err := u.Get(ctx, "alice", []string{"burenotti"})
+	assert.NoError(t, err)
+	assert.Equal(t, []models.UserPresence{{UserID: "burenotti"}}, presence, "hidden presence must look offline")
+	presence, err = u.Get(ctx, "burenotti", []string{"burenotti"})
+	assert.NoError(t, err)
+	if assert.Len(t, presence, 1) {
+		assert.True(t, presence[0].Online, "users must see their own presence")
+	}
+}
+
+func TestPresenceUseCase_Watch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := storage.NewNotificationStorage(logrus.New())
+	prefs := storage.NewMemoryPreferences()
+	u := NewPresenceUseCase(store, storage.NewMemoryLastSeen(), prefs, membersOf(t, "carol", "burenotti", "bob", "dave"), logrus.New())
+	_, err := NewPreferencesUseCase(prefs).SetHidePresence(ctx, "bob", true)
+	assert.NoError(t, err)
+
+	first, err := u.Watch(ctx, "carol", []string{"burenotti", "bob"})
+	assert.NoError(t, err)
+	second, err := u.Watch(ctx, "bob", []string{"bob"})
+	assert.NoError(t, err)
+	unwatched, err := u.Watch(ctx, "dave", []string{"burenotti"})
+	assert.NoError(t, err)
+	u.Unwatch(unwatched)
+	stranger, err := u.Watch(ctx, "eve", []string{"burenotti"})
+	assert.NoError(t, err)
+	defer u.Unwatch(stranger)
+	_, err = u.Watch(ctx, "carol", make([]string, maxPresenceBatch+1))
+	assert.ErrorIs(t, err, ErrTooManyUsers)
+
+	l, other := store.Listen("burenotti"), store.Listen("bob")
+	defer l.Detach()
+	defer other.Detach()
+	go u.Run(ctx, 10*time.Millisecond)
+
+	select {
+	case presence := <-first.Updates():
+		if assert.Len(t, presence, 2) {
+			assert.True(t, presence[0].Online)
+			assert.Equal(t, models.UserPresence{UserID: "bob"}, presence[1], "hidden presence must look offline")
+		}
+	case <-time.After(time.Second):
+		assert.Fail(t, "presence must be polled")
+	}
+	select {
+	case presence := <-second.Updates():
+		if assert.Len(t, presence, 1) {
+			assert.True(t, presence[0].Online, "users must see their own presence")
+		}
+	case <-time.After(time.Second):
+		assert.Fail(t, "presence must be polled")
+	}
+	select {
+	case presence := <-stranger.Updates():
+		assert.Equal(t, []models.UserPresence{{UserID: "burenotti"}}, presence,
+			"presence must be hidden from users not sharing a chat")
+	case <-time.After(time.Second):
+		assert.Fail(t, "presence must be polled")
+	}
+	select {
+	case <-unwatched.Updates():
+		assert.Fail(t, "unwatched subscription must not be polled")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPresenceWatch_Changes(t *testing.T) {
+	now := time.Date(2023, 4, 15, 20, 0, 0, 0, time.UTC)
+	online := models.UserPresence{UserID: "burenotti", Online: true, Devices: 1}
+	offline := models.UserPresence{UserID: "burenotti"}
+	w := NewPresenceWatch()
+
+	assert.Equal(t, []models.UserPresence{online}, w.Changes([]models.UserPresence{online}, now), "first check must report everyone")
+	assert.Empty(t, w.Changes([]models.UserPresence{online}, now.Add(time.Second)))
+
+	assert.Empty(t, w.Changes([]models.UserPresence{offline}, now.Add(2*time.Second)), "going offline must be debounced")
+	assert.Empty(t, w.Changes([]models.UserPresence{online}, now.Add(5*time.Second)), "reconnect within debounce must not be reported")
+	assert.Empty(t, w.Changes([]models.UserPresence{offline}, now.Add(6*time.Second)))
+	assert.Empty(t, w.Changes([]models.UserPresence{offline}, now.Add(6*time.Second+presenceDebounce/2)))
+	assert.Equal(t, []models.UserPresence{offline}, w.Changes([]models.UserPresence{offline}, now.Add(6*time.Second+presenceDebounce)))
+	assert.Empty(t, w.Changes([]models.UserPresence{offline}, now.Add(time.Minute)))
+
+	assert.Equal(t, []models.UserPresence{online}, w.Changes([]models.UserPresence{online}, now.Add(2*time.Minute)), "coming online must be reported at once")
+}
@@ -3,13 +3,37 @@ package usecase
 import "github.com/practice-sem-2/auth-tools"
 
 type UseCase struct {
-	Verifier      *auth.VerifierService
-	Notifications *NotificationsUseCase
+	Verifier         *auth.VerifierService
+	Notifications    *NotificationsUseCase
+	Preferences      *PreferencesUseCase
+	Webhooks         *WebhooksUseCase
+	Devices          *DevicesUseCase
+	Digests          *DigestUseCase
+	Keywords         *KeywordsUseCase
+	Presence         *PresenceUseCase
+	ConnectedDevices *ConnectedDevicesUseCase
 }
 
-func NewUseCase(notifications *NotificationsUseCase, verifier *auth.VerifierService) *UseCase {
+func NewUseCase(
+	notifications *NotificationsUseCase,
+	preferences *PreferencesUseCase,
+	webhooks *WebhooksUseCase,
+	devices *DevicesUseCase,
+	digests *DigestUseCase,
+	keywords *KeywordsUseCase,
+	presence *PresenceUseCase,
+	connectedDevices *ConnectedDevicesUseCase,
+	verifier *auth.VerifierService,
+) *UseCase {
 	return &UseCase{
-		Notifications: notifications,
-		Verifier:      verifier,
+		Notifications:    notifications,
+		Preferences:      preferences,
+		Webhooks:         webhooks,
+		Devices:          devices,
+		Digests:          digests,
+		Keywords:         keywords,
+		Presence:         presence,
+		ConnectedDevices: connectedDevices,
+		Verifier:         verifier,
 	}
 }
@@ -0,0 +1,101 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/practice-sem-2/notification-service/internal/models"
+	"github.com/practice-sem-2/notification-service/internal/storage"
+	"github.com/sirupsen/logrus"
+	"net/url"
+	"time"
+)
+
+var (
+	ErrInvalidWebhookURL  = errors.New("webhook url must be an absolute http or https url")
+	ErrWebhookUnreachable = errors.New("webhook host must resolve to public addresses")
+)
+
+const webhookSecretSize = 32
+
+type WebhooksUseCase struct {
+	hooks      storage.WebhookStore
+	dispatcher *storage.WebhookDispatcher
+	addresses  *storage.WebhookAddresses
+	logger     *logrus.Logger
+}
+
+func NewWebhooksUseCase(
+	hooks storage.WebhookStore,
+	dispatcher *storage.WebhookDispatcher,
+	addresses *storage.WebhookAddresses,
+	logger *logrus.Logger,
+) *WebhooksUseCase {
+	return &WebhooksUseCase{
+		hooks:      hooks,
+		dispatcher: dispatcher,
+		addresses:  addresses,
+		logger:     logger,
+	}
+}
+
+// Register creates a webhook of userID with a new signing secret
+func (u *WebhooksUseCase) Register(ctx context.Context, userID string, rawURL string) (models.Webhook, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return models.Webhook{}, ErrInvalidWebhookURL
+	}
+	// Addresses are checked again on every delivery, since DNS records may change
+	if err := u.addresses.CheckHost(ctx, parsed.Hostname()); err != nil {
+		return models.Webhook{}, fmt.Errorf("%w: %v", ErrWebhookUnreachable, err)
+	}
+
+	secret := make([]byte, webhookSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return models.Webhook{}, err
+	}
+	hook := models.Webhook{
+		ID:        uuid.New().String(),
+		UserID:    userID,
+		URL:       parsed.String(),
+		Secret:    hex.EncodeToString(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := u.hooks.Create(ctx, hook); err != nil {
+		return models.Webhook{}, err
+	}
+	u.reload(ctx)
+	return hook, nil
+}
+
+func (u *WebhooksUseCase) List(ctx context.Context, userID string) ([]models.Webhook, error) {
+	return u.hooks.List(ctx, userID)
+}
+
+func (u *WebhooksUseCase) Delete(ctx context.Context, userID string, id string) error {
+	if err := u.hooks.Delete(ctx, userID, id); err != nil {
+		return err
+	}
+	u.reload(ctx)
+	return nil
+}
+
+// Enable turns on a webhook disabled after failed deliveries
+func (u *WebhooksUseCase) Enable(ctx context.Context, userID string, id string) error {
+	if err := u.hooks.Enable(ctx, userID, id); err != nil {
+		return err
+	}
+	u.reload(ctx)
+	return nil
+}
+
+// reload makes the dispatcher pick up changed owners of webhooks at once.
+// The change is saved already, so a failure only delays it until the next periodic reload.
+func (u *WebhooksUseCase) reload(ctx context.Context) {
+	if err := u.dispatcher.Reload(ctx); err != nil {
+		u.logger.Errorf("can't reload owners of webhooks: %v", err)
+	}
+}